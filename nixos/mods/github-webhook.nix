@@ -16,29 +16,188 @@ let
   makeConfig =
     {
       port,
+      maxConcurrentRuns,
+      logFormat,
+      tracing,
+      publicUrl,
+      dashboardAddress,
+      allowedCidrs,
+      githubMetaPath,
+      trustedProxies,
+      maxAuthFailuresPerMinute,
+      adminTokenSecretName,
+      github,
+      githubApp,
       repos,
     }:
     let
       mkRepo = repoFullName: repoCfg: {
-        secret_path = "%d/${repoCfg.secretName}";
-        branches = repoCfg.branches;
+        secret_path = if repoCfg.secretName == null then "" else "%d/${repoCfg.secretName}";
+        branches = map (
+          branch:
+          if builtins.isString branch then
+            branch
+          else
+            {
+              inherit (branch) name command env;
+              working_dir = if branch.workingDir == null then "" else branch.workingDir;
+              timeout_ms = if branch.timeoutMs == null then 0 else branch.timeoutMs;
+            }
+        ) repoCfg.branches;
         command = repoCfg.command;
+        steps = map (step: {
+          inherit (step) name command;
+          timeout_ms = if step.timeoutMs == null then 0 else step.timeoutMs;
+          continue_on_error = step.continueOnError;
+          "if" = step."if";
+        }) repoCfg.steps;
         working_dir = repoCfg.workingDir;
         quiet_ms = repoCfg.quietMs;
         run_on_startup = repoCfg.runOnStartup;
         timeout_ms = repoCfg.timeoutMs;
+        max_body_bytes = if repoCfg.maxBodyBytes == null then 0 else repoCfg.maxBodyBytes;
+        priority = repoCfg.priority;
+        run_as = repoCfg.runAs;
+        pass_env = repoCfg.passEnv;
+        clean_env = repoCfg.cleanEnv;
+        isolation = repoCfg.isolation;
+        systemd_run =
+          if repoCfg.isolation == "systemd-run" then
+            {
+              protect_system = repoCfg.systemdRun.protectSystem;
+              private_tmp = repoCfg.systemdRun.privateTmp;
+              memory_max = repoCfg.systemdRun.memoryMax;
+              read_write_paths = repoCfg.systemdRun.readWritePaths;
+              properties = repoCfg.systemdRun.properties;
+            }
+          else
+            null;
+        secrets = lib.mapAttrs (_: secretName: "%d/${secretName}") repoCfg.secrets;
+        secrets_mode = repoCfg.secretsMode;
+        environment = repoCfg.environment;
+        forward_to = map (target: {
+          url = target.url;
+          secret_path = "%d/${target.secretName}";
+        }) repoCfg.forwardTo;
+        remote_url = repoCfg.remoteUrl;
+        poll_interval_ms = repoCfg.pollIntervalMs;
+        schedule = map (sched: {
+          inherit (sched)
+            name
+            cron
+            timezone
+            command
+            missed
+            environment
+            ;
+        }) repoCfg.schedule;
+        triggers = map (trigger: {
+          inherit (trigger)
+            name
+            event
+            command
+            environment
+            actions
+            prerelease
+            workflows
+            conclusions
+            branches
+            events
+            ;
+        }) repoCfg.triggers;
+        disable_push = repoCfg.disablePush;
+        stdin_payload = repoCfg.stdinPayload;
+        freeze = map (window: {
+          inherit (window) cron timezone reason;
+          duration_ms = window.durationMs;
+        }) repoCfg.freeze;
+        artifacts = {
+          max_runs = repoCfg.artifacts.maxRuns;
+          max_bytes = repoCfg.artifacts.maxBytes;
+        };
+        retry = {
+          max_attempts = repoCfg.retry.maxAttempts;
+          initial_backoff_ms = repoCfg.retry.initialBackoffMs;
+          max_backoff_ms = repoCfg.retry.maxBackoffMs;
+          exit_codes = repoCfg.retry.exitCodes;
+        };
       };
     in
     {
       port = toString port;
+      max_concurrent_runs = maxConcurrentRuns;
+      log_format = logFormat;
+      tracing =
+        if tracing.endpoint == null then
+          null
+        else
+          {
+            endpoint = tracing.endpoint;
+            service_name = tracing.serviceName;
+          };
+      public_url = publicUrl;
+      dashboard_addr = if dashboardAddress == null then "" else dashboardAddress;
+      allowed_cidrs = allowedCidrs;
+      github_meta_path = if githubMetaPath == null then "" else githubMetaPath;
+      trusted_proxies = trustedProxies;
+      max_auth_failures_per_minute = maxAuthFailuresPerMinute;
+      # Last successful commits, so restarts don't re-run deployed commits.
+      state_path = "/var/lib/github-webhook/state.json";
+      data_dir = "/var/lib/github-webhook";
+      admin_token_path = if adminTokenSecretName == null then "" else "%d/${adminTokenSecretName}";
+      github =
+        if github.tokenSecretName == null && github.tokenSocket == null then
+          null
+        else
+          {
+            api_url = github.apiUrl;
+            token_path =
+              if github.tokenSecretName == null then "" else "%d/${github.tokenSecretName}";
+            token_socket = if github.tokenSocket == null then "" else github.tokenSocket;
+          };
+      github_app =
+        if githubApp.secretName == null then
+          null
+        else
+          {
+            secret_path = "%d/${githubApp.secretName}";
+            auto_enable = githubApp.autoEnable;
+          };
       repos = lib.mapAttrs mkRepo repos;
     };
 
   configJson = builtins.toJSON (makeConfig {
-    inherit (cfg) port repos;
+    inherit (cfg)
+      port
+      maxConcurrentRuns
+      logFormat
+      tracing
+      publicUrl
+      dashboardAddress
+      allowedCidrs
+      githubMetaPath
+      trustedProxies
+      maxAuthFailuresPerMinute
+      adminTokenSecretName
+      github
+      githubApp
+      repos
+      ;
   });
 
-  configFile = pkgs.writeText "github-webhook-config.json" configJson;
+  # Strictly validate the config at build time. Working dirs and secrets only
+  # exist at runtime, so only the static checks run here.
+  configFile =
+    pkgs.runCommand "github-webhook-config.json"
+      {
+        nativeBuildInputs = [ cfg.package ];
+        inherit configJson;
+        passAsFile = [ "configJson" ];
+      }
+      ''
+        github-webhook check-config --static --config "$configJsonPath"
+        cp "$configJsonPath" "$out"
+      '';
 in
 {
   options.services.github-webhook = {
@@ -56,6 +215,181 @@ in
       description = "TCP port to listen on.";
     };
 
+    maxConcurrentRuns = lib.mkOption {
+      type = lib.types.ints.unsigned;
+      default = 0;
+      description = ''
+        Maximum command runs in flight across all repos. Queued runs are
+        served by descending repo `priority`. 0 means unlimited.
+      '';
+    };
+
+    logFormat = lib.mkOption {
+      type = lib.types.enum [
+        "text"
+        "json"
+      ];
+      default = "text";
+      description = ''
+        Log record format. "json" emits one object per record, with `run_id`
+        and `delivery_id` fields for correlating command output with the
+        webhook delivery that triggered it.
+      '';
+    };
+
+    tracing = {
+      endpoint = lib.mkOption {
+        type = lib.types.nullOr lib.types.str;
+        default = null;
+        example = "http://127.0.0.1:4318";
+        description = ''
+          OTLP/HTTP collector base URL. When set, each delivery and the run
+          it triggers are exported as a trace, and commands receive the
+          command span as `TRACEPARENT`.
+        '';
+      };
+
+      serviceName = lib.mkOption {
+        type = lib.types.str;
+        default = "github-webhook";
+        description = "OTLP `service.name` resource attribute.";
+      };
+    };
+
+    allowedCidrs = lib.mkOption {
+      type = lib.types.listOf lib.types.str;
+      default = [ ];
+      example = [ "127.0.0.1/32" ];
+      description = ''
+        Source networks allowed to deliver webhooks, in addition to the
+        `githubMetaPath` hook ranges. Empty (with no `githubMetaPath`) allows
+        any source.
+      '';
+    };
+
+    githubMetaPath = lib.mkOption {
+      type = lib.types.nullOr lib.types.str;
+      default = null;
+      example = "/var/lib/github-webhook/meta.json";
+      description = ''
+        Local copy of https://api.github.com/meta. Its `hooks` ranges are
+        allowed to deliver webhooks; the file is re-read whenever it changes.
+      '';
+    };
+
+    trustedProxies = lib.mkOption {
+      type = lib.types.listOf lib.types.str;
+      default = [ ];
+      example = [ "127.0.0.1/32" ];
+      description = ''
+        Reverse proxy networks whose `X-Forwarded-For` header is trusted to
+        name the delivery's source address.
+      '';
+    };
+
+    maxAuthFailuresPerMinute = lib.mkOption {
+      type = lib.types.ints.unsigned;
+      default = 0;
+      description = ''
+        Answer 429 to a source address (an IPv6 /64) after this many failed
+        signature checks within a minute. 0 disables the limit. Behind a
+        reverse proxy, also set `trustedProxies`, or all deliveries share the
+        proxy's budget.
+      '';
+    };
+
+    adminTokenSecretName = lib.mkOption {
+      type = lib.types.nullOr lib.types.str;
+      default = null;
+      description = ''
+        sops secret holding the bearer token of the admin API, which sets and
+        clears deploy locks (`PUT`/`DELETE /admin/locks/OWNER/REPO`). null
+        disables the admin API.
+      '';
+    };
+
+    watchdogSec = lib.mkOption {
+      type = lib.types.ints.unsigned;
+      default = 60;
+      description = ''
+        systemd watchdog timeout. The daemon pings it while every repo's
+        debouncer loop is responsive, so a wedged loop gets the service
+        restarted. 0 disables the watchdog.
+      '';
+    };
+
+    publicUrl = lib.mkOption {
+      type = lib.types.str;
+      default = "";
+      example = "https://ci.phlip9.com";
+      description = ''
+        Externally reachable base URL of the status dashboard. Used for
+        deployment status log links. Empty omits log links.
+      '';
+    };
+
+    dashboardAddress = lib.mkOption {
+      type = lib.types.nullOr lib.types.str;
+      default = "127.0.0.1:8674";
+      description = ''
+        Separate listen address (host:port) for the unauthenticated status
+        dashboard, run logs and artifacts, and the admin API, so only
+        webhooks, /healthz and /metrics are served on `port`. null serves
+        everything on `port`, exposing run logs and artifacts to anyone who
+        can reach the webhook endpoint.
+      '';
+    };
+
+    github = {
+      apiUrl = lib.mkOption {
+        type = lib.types.str;
+        default = "https://api.github.com";
+        description = "GitHub REST API base URL.";
+      };
+
+      tokenSecretName = lib.mkOption {
+        type = lib.types.nullOr lib.types.str;
+        default = null;
+        description = ''
+          sops secret holding a GitHub API token with deployments write
+          access. Mutually exclusive with `tokenSocket`.
+        '';
+      };
+
+      tokenSocket = lib.mkOption {
+        type = lib.types.nullOr lib.types.str;
+        default = null;
+        example = "/run/github-agent-authd/socket";
+        description = ''
+          github-agent-authd socket to fetch per-repo installation tokens
+          from. The service user must be allowed to connect to it.
+        '';
+      };
+    };
+
+    githubApp = {
+      secretName = lib.mkOption {
+        type = lib.types.nullOr lib.types.str;
+        default = null;
+        description = ''
+          sops secret holding the webhook secret of a GitHub App. One app
+          webhook then covers every repo the app is installed on; repos
+          without their own `secretName` are verified with it. null disables
+          app mode.
+        '';
+      };
+
+      autoEnable = lib.mkOption {
+        type = lib.types.bool;
+        default = false;
+        description = ''
+          Enable repos added to the app installation that match a `repos`
+          pattern (e.g. "phlip9/*"), and disable them again when removed.
+          Otherwise added repos are only logged.
+        '';
+      };
+    };
+
     user = lib.mkOption {
       type = lib.types.str;
       default = "root";
@@ -66,25 +400,145 @@ in
       default = { };
       description = ''
         Repository configurations. The attribute name should be the full
-        repository name (e.g., "phlip9/dotfiles").
+        repository name (e.g., "phlip9/dotfiles"), or with `githubApp`, a
+        pattern (e.g., "phlip9/*") whose `workingDir` and `command` may use
+        {owner} and {name}.
       '';
       type = lib.types.attrsOf (
         lib.types.submodule {
           options = {
             secretName = lib.mkOption {
-              type = lib.types.str;
-              description = "SOPS secret name carrying the GitHub webhook secret.";
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = ''
+                SOPS secret name carrying the GitHub webhook secret. Required
+                unless `githubApp.secretName` is set.
+              '';
             };
 
             branches = lib.mkOption {
-              type = lib.types.listOf lib.types.str;
+              type = lib.types.listOf (
+                lib.types.either lib.types.str (
+                  lib.types.submodule {
+                    options = {
+                      name = lib.mkOption {
+                        type = lib.types.str;
+                        description = "Branch name.";
+                      };
+
+                      command = lib.mkOption {
+                        type = lib.types.listOf lib.types.str;
+                        default = [ ];
+                        description = "Overrides the repo command (or steps) for this branch.";
+                      };
+
+                      workingDir = lib.mkOption {
+                        type = lib.types.nullOr lib.types.str;
+                        default = null;
+                        description = "Overrides the repo working directory for this branch.";
+                      };
+
+                      env = lib.mkOption {
+                        type = lib.types.attrsOf lib.types.str;
+                        default = { };
+                        description = "Extra environment variables for this branch's commands.";
+                      };
+
+                      timeoutMs = lib.mkOption {
+                        type = lib.types.nullOr lib.types.ints.positive;
+                        default = null;
+                        description = "Overrides the repo command timeout for this branch.";
+                      };
+                    };
+                  }
+                )
+              );
               default = [ "master" ];
-              description = "List of branch names to track.";
+              description = ''
+                Branches to track: names, or objects with overrides merged
+                over the repo defaults. Each branch is debounced separately,
+                so pushes to different branches never coalesce.
+              '';
             };
 
             command = lib.mkOption {
               type = lib.types.listOf lib.types.str;
-              description = "Command to execute on webhook event.";
+              default = [ ];
+              description = "Command to execute on webhook event. Leave empty when using `steps`.";
+            };
+
+            steps = lib.mkOption {
+              default = [ ];
+              description = ''
+                Ordered pipeline run instead of `command`. Each step's status
+                and output are recorded separately; the first failing step
+                (without `continueOnError`) fails the run.
+              '';
+              type = lib.types.listOf (
+                lib.types.submodule {
+                  options = {
+                    name = lib.mkOption {
+                      type = lib.types.str;
+                      description = "Step name, exported as GH_STEP.";
+                    };
+
+                    command = lib.mkOption {
+                      type = lib.types.listOf lib.types.str;
+                      description = "Command to run.";
+                    };
+
+                    timeoutMs = lib.mkOption {
+                      type = lib.types.nullOr lib.types.ints.positive;
+                      default = null;
+                      description = "Step timeout in milliseconds. Defaults to the repo `timeoutMs`.";
+                    };
+
+                    continueOnError = lib.mkOption {
+                      type = lib.types.bool;
+                      default = false;
+                      description = "Record a failure of this step without failing the run.";
+                    };
+
+                    "if" = {
+                      branches = lib.mkOption {
+                        type = lib.types.listOf lib.types.str;
+                        default = [ ];
+                        description = "Only run on these branches. Empty matches any.";
+                      };
+
+                      events = lib.mkOption {
+                        type = lib.types.listOf (
+                          lib.types.enum [
+                            "push"
+                            "poll"
+                            "startup"
+                            "schedule"
+                            "release"
+                            "workflow_run"
+                          ]
+                        );
+                        default = [ ];
+                        description = "Only run for these trigger events. Empty matches any.";
+                      };
+
+                      paths = lib.mkOption {
+                        type = lib.types.listOf lib.types.str;
+                        default = [ ];
+                        example = [
+                          "flake.lock"
+                          "pkgs/**"
+                        ];
+                        description = ''
+                          Only run if a file changed since the last successful
+                          commit matches one of these globs ("dir/**" matches
+                          everything under dir). Runs if the changes are
+                          unknown. Empty matches any.
+                        '';
+                      };
+                    };
+                  };
+                }
+              );
             };
 
             workingDir = lib.mkOption {
@@ -101,7 +555,11 @@ in
             runOnStartup = lib.mkOption {
               type = lib.types.bool;
               default = false;
-              description = "Run command once on service startup.";
+              description = ''
+                Run command once on service startup. Branch heads that
+                already succeeded are skipped: resolved with `remoteUrl`, or
+                else from the commit checked out in the working dir.
+              '';
             };
 
             timeoutMs = lib.mkOption {
@@ -109,6 +567,429 @@ in
               default = 3600000; # 1 hour
               description = "Command timeout in milliseconds.";
             };
+
+            maxBodyBytes = lib.mkOption {
+              type = lib.types.nullOr lib.types.ints.positive;
+              default = null;
+              description = ''
+                Maximum delivery size in bytes; larger deliveries get 413.
+                Defaults to GitHub's 25 MB payload cap.
+              '';
+            };
+
+            priority = lib.mkOption {
+              type = lib.types.int;
+              default = 0;
+              description = ''
+                Priority when waiting for a global run slot (see
+                `maxConcurrentRuns`). Higher runs first.
+              '';
+            };
+
+            runAs = lib.mkOption {
+              type = lib.types.nullOr (
+                lib.types.submodule {
+                  options = {
+                    uid = lib.mkOption { type = lib.types.ints.unsigned; };
+                    gid = lib.mkOption { type = lib.types.ints.unsigned; };
+                  };
+                }
+              );
+              default = null;
+              description = ''
+                Run commands as this uid/gid, without supplementary groups,
+                instead of the service user. Requires the service to run as
+                root.
+              '';
+            };
+
+            passEnv = lib.mkOption {
+              type = lib.types.listOf lib.types.str;
+              default = [ ];
+              example = [
+                "PATH"
+                "XDG_RUNTIME_DIR"
+              ];
+              description = ''
+                Service environment variables passed to commands. When
+                non-empty, no other service variables are inherited. When
+                empty, commands inherit the service environment, except
+                that `isolation = "systemd-run"` units only get PATH and
+                HOME.
+              '';
+            };
+
+            cleanEnv = lib.mkOption {
+              type = lib.types.bool;
+              default = false;
+              description = ''
+                Start commands from an empty environment (plus `passEnv` and
+                the GH_* variables) instead of inheriting the service's.
+              '';
+            };
+
+            isolation = lib.mkOption {
+              type = lib.types.enum [
+                ""
+                "systemd-run"
+              ];
+              default = "";
+              description = ''
+                "systemd-run" launches each run as a hardened transient unit
+                (see `systemdRun`). Requires the service to run as root, and
+                `secretsMode = "files"` for repos with `secrets`. The unit
+                environment is visible on the systemd-run command line, so
+                only PATH, HOME and `passEnv` are passed to it.
+              '';
+            };
+
+            systemdRun = {
+              protectSystem = lib.mkOption {
+                type = lib.types.str;
+                default = "strict";
+                description = "ProtectSystem= for the transient unit.";
+              };
+
+              privateTmp = lib.mkOption {
+                type = lib.types.bool;
+                default = true;
+                description = "PrivateTmp= for the transient unit.";
+              };
+
+              memoryMax = lib.mkOption {
+                type = lib.types.str;
+                default = "";
+                example = "4G";
+                description = "MemoryMax= for the transient unit. Empty means unlimited.";
+              };
+
+              readWritePaths = lib.mkOption {
+                type = lib.types.listOf lib.types.str;
+                default = [ ];
+                description = "Extra writable paths. The working dir is always writable.";
+              };
+
+              properties = lib.mkOption {
+                type = lib.types.listOf lib.types.str;
+                default = [ ];
+                example = [ "ProtectHome=read-only" ];
+                description = "Extra unit properties passed to systemd-run.";
+              };
+            };
+
+            secrets = lib.mkOption {
+              type = lib.types.attrsOf lib.types.str;
+              default = { };
+              example = {
+                CACHE_SIGNING_KEY = "nix-cache-signing-key";
+              };
+              description = ''
+                Env var name -> SOPS secret name. Secrets are loaded as
+                systemd credentials and only exposed to this repo's commands.
+                Their values, and each line of at least 8 non-whitespace bytes
+                of multi-line ones, are redacted from logged output.
+              '';
+            };
+
+            secretsMode = lib.mkOption {
+              type = lib.types.enum [
+                "env"
+                "files"
+              ];
+              default = "env";
+              description = ''
+                "env" sets each secret as an env var. "files" writes them to a
+                private per-run directory in the service's RuntimeDirectory
+                (required, and set by this module) and sets NAME_FILE to each
+                path instead.
+              '';
+            };
+
+            environment = lib.mkOption {
+              type = lib.types.str;
+              default = "";
+              example = "production";
+              description = ''
+                Report runs as GitHub deployments to this environment.
+                Requires `services.github-webhook.github` token settings.
+              '';
+            };
+
+            forwardTo = lib.mkOption {
+              default = [ ];
+              description = ''
+                Other webhook listeners to relay verified deliveries to. Each
+                delivery is re-signed with the target's secret.
+              '';
+              type = lib.types.listOf (
+                lib.types.submodule {
+                  options = {
+                    url = lib.mkOption {
+                      type = lib.types.str;
+                      example = "http://10.0.0.2:8673/webhooks/github";
+                      description = "Target webhook endpoint.";
+                    };
+
+                    secretName = lib.mkOption {
+                      type = lib.types.str;
+                      description = "sops secret holding the target's webhook secret.";
+                    };
+                  };
+                }
+              );
+            };
+
+            remoteUrl = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              example = "https://github.com/phlip9/dotfiles.git";
+              description = "Git remote polled with `git ls-remote`.";
+            };
+
+            pollIntervalMs = lib.mkOption {
+              type = lib.types.int;
+              default = 0;
+              description = ''
+                Poll `remoteUrl` every N milliseconds and trigger a run when a
+                tracked branch head differs from the last successful commit.
+                Covers missed webhook deliveries. 0 disables polling.
+              '';
+            };
+
+            schedule = lib.mkOption {
+              default = [ ];
+              description = ''
+                Cron-triggered jobs. Runs go through the same serialized
+                per-repo queue as webhook runs, with GH_EVENT=schedule and
+                GH_JOB set to the job name.
+              '';
+              type = lib.types.listOf (
+                lib.types.submodule {
+                  options = {
+                    name = lib.mkOption {
+                      type = lib.types.str;
+                      description = "Job name, exported as GH_JOB.";
+                    };
+
+                    cron = lib.mkOption {
+                      type = lib.types.str;
+                      example = "0 4 * * *";
+                      description = "5-field cron expression or @daily etc.";
+                    };
+
+                    timezone = lib.mkOption {
+                      type = lib.types.str;
+                      default = "UTC";
+                      description = "IANA timezone the cron expression is evaluated in.";
+                    };
+
+                    command = lib.mkOption {
+                      type = lib.types.nullOr (lib.types.listOf lib.types.str);
+                      default = null;
+                      description = "Command to run. Defaults to the repo command.";
+                    };
+
+                    missed = lib.mkOption {
+                      type = lib.types.enum [
+                        "skip"
+                        "catch_up"
+                      ];
+                      default = "skip";
+                      description = ''
+                        What to do when a fire time was missed (suspend, clock
+                        jump): skip it, or run once to catch up.
+                      '';
+                    };
+
+                    environment = lib.mkOption {
+                      type = lib.types.str;
+                      default = "";
+                      description = "Report this job's runs as GitHub deployments to this environment.";
+                    };
+                  };
+                }
+              );
+            };
+
+            triggers = lib.mkOption {
+              default = [ ];
+              description = ''
+                Jobs run by `release` and `workflow_run` events, with GH_JOB
+                set to the job name. Job names are shared with `schedule`.
+              '';
+              type = lib.types.listOf (
+                lib.types.submodule {
+                  options = {
+                    name = lib.mkOption {
+                      type = lib.types.str;
+                      description = "Job name, exported as GH_JOB.";
+                    };
+
+                    event = lib.mkOption {
+                      type = lib.types.enum [
+                        "release"
+                        "workflow_run"
+                      ];
+                      description = "Event that triggers the job.";
+                    };
+
+                    command = lib.mkOption {
+                      type = lib.types.nullOr (lib.types.listOf lib.types.str);
+                      default = null;
+                      description = "Command to run. Defaults to the repo command or steps.";
+                    };
+
+                    environment = lib.mkOption {
+                      type = lib.types.str;
+                      default = "";
+                      description = "Report this job's runs as GitHub deployments to this environment.";
+                    };
+
+                    actions = lib.mkOption {
+                      type = lib.types.listOf lib.types.str;
+                      default = [ ];
+                      description = ''
+                        Matching payload actions. Empty means `published` for
+                        release and `completed` for workflow_run.
+                      '';
+                    };
+
+                    prerelease = lib.mkOption {
+                      type = lib.types.nullOr lib.types.bool;
+                      default = null;
+                      description = "Only match releases with this prerelease flag (release only).";
+                    };
+
+                    workflows = lib.mkOption {
+                      type = lib.types.listOf lib.types.str;
+                      default = [ ];
+                      description = "Matching workflow names (workflow_run only). Empty matches any.";
+                    };
+
+                    conclusions = lib.mkOption {
+                      type = lib.types.listOf lib.types.str;
+                      default = [ ];
+                      description = "Matching run conclusions (workflow_run only). Empty means `success`.";
+                    };
+
+                    branches = lib.mkOption {
+                      type = lib.types.listOf lib.types.str;
+                      default = [ ];
+                      description = "Matching head branches (workflow_run only). Empty means the repo branches.";
+                    };
+
+                    events = lib.mkOption {
+                      type = lib.types.listOf lib.types.str;
+                      default = [ ];
+                      description = "Matching run trigger events (workflow_run only). Empty means `push`. Runs from forks never match.";
+                    };
+                  };
+                }
+              );
+            };
+
+            disablePush = lib.mkOption {
+              type = lib.types.bool;
+              default = false;
+              description = ''
+                Acknowledge push events without running anything, e.g. when
+                deploys are gated on a `workflow_run` trigger.
+              '';
+            };
+
+            stdinPayload = lib.mkOption {
+              type = lib.types.bool;
+              default = false;
+              description = ''
+                Send the verified delivery's JSON payload on the command's
+                stdin. Commands may also write a JSON result to
+                `GH_RESULT_PATH` either way.
+              '';
+            };
+
+            freeze = lib.mkOption {
+              default = [ ];
+              description = ''
+                Recurring deploy freeze windows. Deliveries during a window are
+                accepted, but only the newest trigger per branch and job is
+                held, and it runs once the window ends.
+              '';
+              type = lib.types.listOf (
+                lib.types.submodule {
+                  options = {
+                    cron = lib.mkOption {
+                      type = lib.types.str;
+                      example = "0 17 * * 5";
+                      description = "Start of each window, a 5-field cron expression or @daily etc.";
+                    };
+
+                    timezone = lib.mkOption {
+                      type = lib.types.str;
+                      default = "UTC";
+                      description = "IANA timezone the cron expression is evaluated in.";
+                    };
+
+                    durationMs = lib.mkOption {
+                      type = lib.types.ints.positive;
+                      example = 230400000;
+                      description = "Length of each window.";
+                    };
+
+                    reason = lib.mkOption {
+                      type = lib.types.str;
+                      default = "";
+                      description = "Reported to senders and on the dashboard.";
+                    };
+                  };
+                }
+              );
+            };
+
+            artifacts = {
+              maxRuns = lib.mkOption {
+                type = lib.types.ints.positive;
+                default = 10;
+                description = ''
+                  Number of runs whose `GH_ARTIFACTS_DIR` files are kept and
+                  served at `/runs/{id}/artifacts/`. Oldest are deleted first.
+                '';
+              };
+
+              maxBytes = lib.mkOption {
+                type = lib.types.ints.positive;
+                default = 1073741824;
+                description = ''
+                  Total size of kept artifacts in bytes. Oldest runs are
+                  deleted first.
+                '';
+              };
+            };
+
+            retry = {
+              maxAttempts = lib.mkOption {
+                type = lib.types.ints.positive;
+                default = 1;
+                description = "Total attempts per trigger, including the first. 1 disables retries.";
+              };
+
+              initialBackoffMs = lib.mkOption {
+                type = lib.types.int;
+                default = 1000;
+                description = "Delay before the first retry; doubles on each retry.";
+              };
+
+              maxBackoffMs = lib.mkOption {
+                type = lib.types.int;
+                default = 60000;
+                description = "Upper bound on the retry delay.";
+              };
+
+              exitCodes = lib.mkOption {
+                type = lib.types.listOf lib.types.int;
+                default = [ ];
+                description = "Retryable exit codes. Empty retries any non-zero exit.";
+              };
+            };
           };
         }
       );
@@ -133,27 +1014,108 @@ in
       '';
     }) cfg.repos)
     ++ (lib.mapAttrsToList (repoId: repoCfg: {
-      assertion = lib.hasAttr repoCfg.secretName secrets;
+      assertion =
+        if repoCfg.secretName == null then
+          cfg.githubApp.secretName != null
+        else
+          lib.hasAttr repoCfg.secretName secrets;
       message = ''
-        services.github-webhook.repos.${repoId}.secretName="${repoCfg.secretName}"
-        is not defined in config.sops.secrets.
+        services.github-webhook.repos.${repoId}.secretName="${toString repoCfg.secretName}"
+        is not defined in config.sops.secrets (or null without githubApp.secretName).
       '';
-    }) cfg.repos);
+    }) cfg.repos)
+    ++ lib.concatLists (
+      lib.mapAttrsToList (
+        repoId: repoCfg:
+        lib.mapAttrsToList (envName: secretName: {
+          assertion = lib.hasAttr secretName secrets;
+          message = ''
+            services.github-webhook.repos.${repoId}.secrets.${envName}="${secretName}"
+            is not defined in config.sops.secrets.
+          '';
+        }) repoCfg.secrets
+      ) cfg.repos
+    )
+    ++ lib.concatLists (
+      lib.mapAttrsToList (
+        repoId: repoCfg:
+        map (target: {
+          assertion = lib.hasAttr target.secretName secrets;
+          message = ''
+            services.github-webhook.repos.${repoId}.forwardTo secretName="${target.secretName}"
+            is not defined in config.sops.secrets.
+          '';
+        }) repoCfg.forwardTo
+      ) cfg.repos
+    )
+    ++ [
+      {
+        assertion = cfg.github.tokenSecretName == null || cfg.github.tokenSocket == null;
+        message = ''
+          services.github-webhook.github: set only one of tokenSecretName and
+          tokenSocket.
+        '';
+      }
+      {
+        assertion = cfg.adminTokenSecretName == null || lib.hasAttr cfg.adminTokenSecretName secrets;
+        message = ''
+          services.github-webhook.adminTokenSecretName="${toString cfg.adminTokenSecretName}"
+          is not defined in config.sops.secrets.
+        '';
+      }
+      {
+        assertion = cfg.githubApp.secretName == null || lib.hasAttr cfg.githubApp.secretName secrets;
+        message = ''
+          services.github-webhook.githubApp.secretName="${toString cfg.githubApp.secretName}"
+          is not defined in config.sops.secrets.
+        '';
+      }
+      {
+        assertion =
+          cfg.github.tokenSecretName == null || lib.hasAttr cfg.github.tokenSecretName secrets;
+        message = ''
+          services.github-webhook.github.tokenSecretName="${toString cfg.github.tokenSecretName}"
+          is not defined in config.sops.secrets.
+        '';
+      }
+    ];
 
     systemd.services.github-webhook =
       let
-        # Collect all working directories for ConditionPathExists.
+        # Collect all working directories for ConditionPathExists. Pattern
+        # dirs using {owner}/{name} only exist once a matching repo is added.
         workingDirs = lib.unique (
-          lib.mapAttrsToList (_: repoCfg: repoCfg.workingDir) cfg.repos
+          builtins.filter (dir: !lib.hasInfix "{" dir) (
+            lib.concatLists (
+              lib.mapAttrsToList (
+                _: repoCfg:
+                [ repoCfg.workingDir ]
+                ++ map (branch: branch.workingDir) (
+                  builtins.filter (
+                    branch: !builtins.isString branch && branch.workingDir != null
+                  ) repoCfg.branches
+                )
+              ) cfg.repos
+            )
+          )
         );
 
         # Collect all secrets for LoadCredential. Dedup entries so multiple
         # repos can safely share one secret.
         credentialsList = lib.unique (
-          lib.mapAttrsToList (
-            _: repoCfg:
-            "${repoCfg.secretName}:${config.sops.secrets.${repoCfg.secretName}.path}"
-          ) cfg.repos
+          map (secretName: "${secretName}:${config.sops.secrets.${secretName}.path}") (
+            lib.concatLists (
+              lib.mapAttrsToList (
+                _: repoCfg:
+                lib.optional (repoCfg.secretName != null) repoCfg.secretName
+                ++ lib.attrValues repoCfg.secrets
+                ++ map (target: target.secretName) repoCfg.forwardTo
+              ) cfg.repos
+            )
+            ++ lib.optional (cfg.github.tokenSecretName != null) cfg.github.tokenSecretName
+            ++ lib.optional (cfg.adminTokenSecretName != null) cfg.adminTokenSecretName
+            ++ lib.optional (cfg.githubApp.secretName != null) cfg.githubApp.secretName
+          )
         );
       in
       {
@@ -165,7 +1127,10 @@ in
         path = [
           pkgs.gitMinimal
           pkgs.openssh
-        ];
+        ]
+        ++ lib.optional (lib.any (repoCfg: repoCfg.isolation == "systemd-run") (
+          lib.attrValues cfg.repos
+        )) config.systemd.package;
 
         unitConfig = {
           # Check that at least one working directory exists.
@@ -180,12 +1145,21 @@ in
         };
 
         serviceConfig = {
+          # Ready once the listener is bound.
+          Type = "notify";
+          WatchdogSec = cfg.watchdogSec;
           ExecStart = "${cfg.package}/bin/github-webhook";
           User = cfg.user;
           Restart = "on-failure";
           RestartSec = 5;
           RuntimeDirectory = "github-webhook";
-          RuntimeDirectoryMode = "0700";
+          # Traversable, so run_as commands reach their per-run secrets and
+          # result dirs; the entries themselves are private.
+          RuntimeDirectoryMode = "0711";
+          StateDirectory = "github-webhook";
+          # Traversable, so run_as commands reach their cache and artifacts
+          # dirs; the state file itself is private.
+          StateDirectoryMode = "0711";
 
           LoadCredential = credentialsList;
 
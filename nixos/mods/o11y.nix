@@ -114,6 +114,12 @@ in
         ++ lib.optional config.services.prometheus.exporters.postgres.enable ({
           job_name = "postgres-exporter";
           static_configs = exporterTargets config.services.prometheus.exporters.postgres;
+        })
+        ++ lib.optional config.services.github-webhook.enable ({
+          job_name = "github-webhook";
+          static_configs = [
+            { targets = [ "127.0.0.1:${toString config.services.github-webhook.port}" ]; }
+          ];
         });
       };
     };
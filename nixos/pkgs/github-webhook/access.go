@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// authFailureWindow is the window max_auth_failures_per_minute counts in.
+	authFailureWindow = time.Minute
+	// authFailureSweep is how many tracked IPs trigger pruning of expired
+	// windows.
+	authFailureSweep = 1024
+	// authFailureMaxTracked caps the tracked IPs; past it, the oldest window
+	// is evicted.
+	authFailureMaxTracked = 4 * authFailureSweep
+	// authFailureIPv6Bits is the IPv6 prefix length sharing one budget, since
+	// a single host usually controls a whole /64.
+	authFailureIPv6Bits = 64
+)
+
+// accessControl filters webhook deliveries by source IP and rate-limits IPs
+// that fail signature checks. A nil accessControl allows everything.
+type accessControl struct {
+	// allowed is the static allowed_cidrs list. The endpoint is open when both
+	// it and metaPath are empty.
+	allowed  []netip.Prefix
+	metaPath string
+	proxies  []netip.Prefix
+	// maxFailures per authFailureWindow and IP; 0 disables the limit.
+	maxFailures int
+
+	mu sync.Mutex
+	// metaHooks are the "hooks" ranges of metaPath as of metaModTime.
+	metaHooks   []netip.Prefix
+	metaModTime time.Time
+	// failures maps failureKey(client IP) -> signature failures in the
+	// current window.
+	failures map[netip.Addr]*failureWindow
+}
+
+// failureWindow counts failures since start.
+type failureWindow struct {
+	start time.Time
+	count int
+}
+
+// newAccessControl returns the access control for cfg, or nil if cfg
+// configures none. The config is validated, so prefixes parse.
+func newAccessControl(cfg *Config) *accessControl {
+	if len(cfg.AllowedCIDRs) == 0 && cfg.GitHubMetaPath == "" &&
+		len(cfg.TrustedProxies) == 0 && cfg.MaxAuthFailuresPerMinute == 0 {
+		return nil
+	}
+	allowed, _ := parsePrefixes(cfg.AllowedCIDRs)
+	proxies, _ := parsePrefixes(cfg.TrustedProxies)
+	return &accessControl{
+		allowed:     allowed,
+		metaPath:    cfg.GitHubMetaPath,
+		proxies:     proxies,
+		maxFailures: cfg.MaxAuthFailuresPerMinute,
+		failures:    make(map[netip.Addr]*failureWindow),
+	}
+}
+
+// parsePrefixes parses CIDR strings; a bare address is a single-host prefix.
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	var errs []error
+	for _, s := range cidrs {
+		if addr, err := netip.ParseAddr(s); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid CIDR %q", s))
+			continue
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, errors.Join(errs...)
+}
+
+// readGitHubMeta reads the "hooks" ranges from a GitHub /meta response.
+func readGitHubMeta(path string) ([]netip.Prefix, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var meta struct {
+		Hooks []string `json:"hooks"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(meta.Hooks) == 0 {
+		return nil, fmt.Errorf("%s: no hooks ranges", path)
+	}
+	return parsePrefixes(meta.Hooks)
+}
+
+// clientIP returns the delivery's source address. Requests from a trusted
+// proxy are attributed to the right-most X-Forwarded-For entry that is not
+// itself a trusted proxy.
+func (ac *accessControl) clientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	addr = addr.Unmap()
+	if ac == nil || !containsAddr(ac.proxies, addr) {
+		return addr
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for _, hop := range slices.Backward(hops) {
+		hopAddr, err := netip.ParseAddr(strings.TrimSpace(hop))
+		if err != nil {
+			// Unparseable: stop at the last address we could trust.
+			break
+		}
+		addr = hopAddr.Unmap()
+		if !containsAddr(ac.proxies, addr) {
+			break
+		}
+	}
+	return addr
+}
+
+// allows reports whether addr may deliver webhooks.
+func (ac *accessControl) allows(addr netip.Addr) bool {
+	if ac == nil || (len(ac.allowed) == 0 && ac.metaPath == "") {
+		return true
+	}
+	if containsAddr(ac.allowed, addr) {
+		return true
+	}
+	return containsAddr(ac.hooks(), addr)
+}
+
+// hooks returns the GitHub hook ranges from metaPath, re-reading the file
+// when it changes. On errors the last good ranges are kept.
+func (ac *accessControl) hooks() []netip.Prefix {
+	if ac.metaPath == "" {
+		return nil
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	info, err := os.Stat(ac.metaPath)
+	if err != nil {
+		slog.Warn("stat github meta failed, using last ranges", "err", err)
+		return ac.metaHooks
+	}
+	if info.ModTime().Equal(ac.metaModTime) {
+		return ac.metaHooks
+	}
+
+	hooks, err := readGitHubMeta(ac.metaPath)
+	if err != nil {
+		slog.Warn("read github meta failed, using last ranges", "err", err)
+		return ac.metaHooks
+	}
+	ac.metaHooks = hooks
+	ac.metaModTime = info.ModTime()
+	slog.Info("loaded github hook ranges", "path", ac.metaPath, "ranges", len(hooks))
+	return ac.metaHooks
+}
+
+// limited reports whether addr exceeded its signature failure budget.
+func (ac *accessControl) limited(addr netip.Addr) bool {
+	if ac == nil || ac.maxFailures == 0 {
+		return false
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	fw := ac.failures[failureKey(addr)]
+	return fw != nil && time.Since(fw.start) < authFailureWindow &&
+		fw.count >= ac.maxFailures
+}
+
+// recordFailure counts a failed signature check from addr.
+func (ac *accessControl) recordFailure(addr netip.Addr) {
+	if ac == nil || ac.maxFailures == 0 {
+		return
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	now := time.Now()
+	key := failureKey(addr)
+	if len(ac.failures) >= authFailureSweep {
+		for a, fw := range ac.failures {
+			if now.Sub(fw.start) >= authFailureWindow {
+				delete(ac.failures, a)
+			}
+		}
+	}
+	if _, ok := ac.failures[key]; !ok && len(ac.failures) >= authFailureMaxTracked {
+		var oldest netip.Addr
+		for a, fw := range ac.failures {
+			if !oldest.IsValid() || fw.start.Before(ac.failures[oldest].start) {
+				oldest = a
+			}
+		}
+		delete(ac.failures, oldest)
+	}
+
+	fw := ac.failures[key]
+	if fw == nil || now.Sub(fw.start) >= authFailureWindow {
+		fw = &failureWindow{start: now}
+		ac.failures[key] = fw
+	}
+	fw.count++
+}
+
+// failureKey returns the failure budget addr counts against: the address
+// itself for IPv4, its /64 for IPv6.
+func failureKey(addr netip.Addr) netip.Addr {
+	addr = addr.Unmap()
+	if !addr.Is6() {
+		return addr
+	}
+	prefix, _ := addr.Prefix(authFailureIPv6Bits)
+	return prefix.Addr()
+}
+
+// containsAddr reports whether any prefix contains addr.
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	return slices.ContainsFunc(prefixes, func(p netip.Prefix) bool {
+		return p.Contains(addr)
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+func TestClientIP(t *testing.T) {
+	ac := newAccessControl(&Config{TrustedProxies: []string{"10.0.0.0/8", "::1"}})
+
+	for _, tc := range []struct {
+		remote, xff, want string
+	}{
+		{"192.0.2.1:1234", "198.51.100.7", "192.0.2.1"},
+		{"10.0.0.1:1234", "198.51.100.7", "198.51.100.7"},
+		{"10.0.0.1:1234", "203.0.113.9, 198.51.100.7, 10.0.0.2", "198.51.100.7"},
+		{"[::1]:1234", "198.51.100.7", "198.51.100.7"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "garbage", "10.0.0.1"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", nil)
+		req.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if got := ac.clientIP(req).String(); got != tc.want {
+			t.Errorf("clientIP(%s, %q) = %s, want %s", tc.remote, tc.xff, got, tc.want)
+		}
+	}
+}
+
+// TestRecordFailureKeys shares one budget per IPv6 /64 and caps the tracked
+// addresses.
+func TestRecordFailureKeys(t *testing.T) {
+	ac := newAccessControl(&Config{MaxAuthFailuresPerMinute: 2})
+
+	ac.recordFailure(netip.MustParseAddr("2001:db8:1:2::1"))
+	ac.recordFailure(netip.MustParseAddr("2001:db8:1:2:ffff::9"))
+	if !ac.limited(netip.MustParseAddr("2001:db8:1:2::abcd")) {
+		t.Errorf("expected the /64 to share one exhausted budget")
+	}
+	if ac.limited(netip.MustParseAddr("2001:db8:1:3::1")) {
+		t.Errorf("expected another /64 to keep its budget")
+	}
+
+	for i := range authFailureMaxTracked + 100 {
+		ac.recordFailure(netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)}))
+	}
+	if n := len(ac.failures); n > authFailureMaxTracked {
+		t.Errorf("tracked %d addresses, want at most %d", n, authFailureMaxTracked)
+	}
+}
+
+// TestHandleWebhookAccess allows GitHub's hook ranges from a meta file,
+// picks up meta changes, and rate-limits IPs failing signature checks.
+func TestHandleWebhookAccess(t *testing.T) {
+	metaPath := filepath.Join(t.TempDir(), "meta.json")
+	writeMeta := func(hooks string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(metaPath, []byte(`{"hooks":[`+hooks+`]}`), 0o644); err != nil {
+			t.Fatalf("write meta: %v", err)
+		}
+		if err := os.Chtimes(metaPath, mtime, mtime); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+	writeMeta(`"192.30.252.0/22"`, time.Now().Add(-time.Hour))
+
+	secret := []byte("supersecret")
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo:     Repo{Branches: []Branch{{Name: "master"}}},
+		secret:   secret,
+	}
+	a := &app{
+		handlers: map[string]*repoHandler{"test/repo": handler},
+		access: newAccessControl(&Config{
+			AllowedCIDRs:             []string{"127.0.0.1"},
+			GitHubMetaPath:           metaPath,
+			MaxAuthFailuresPerMinute: 2,
+		}),
+	}
+
+	body := []byte(`{"zen":"hi","repository":{"full_name":"test/repo"}}`)
+	post := func(remote, sig string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(body))
+		req.RemoteAddr = remote + ":4321"
+		req.Header.Set("X-GitHub-Event", "ping")
+		req.Header.Set("X-Hub-Signature-256", sig)
+		rr := httptest.NewRecorder()
+		a.handleWebhook(rr, req)
+		return rr.Code
+	}
+	valid := webhook.Sign(secret, body)
+
+	for _, tc := range []struct {
+		remote string
+		want   int
+	}{
+		{"127.0.0.1", http.StatusNoContent},
+		{"192.30.253.1", http.StatusNoContent},
+		{"203.0.113.1", http.StatusForbidden},
+		{"140.82.112.1", http.StatusForbidden},
+	} {
+		if got := post(tc.remote, valid); got != tc.want {
+			t.Errorf("delivery from %s: got %d, want %d", tc.remote, got, tc.want)
+		}
+	}
+
+	// Updated meta ranges are picked up.
+	writeMeta(`"140.82.112.0/20"`, time.Now())
+	if got := post("140.82.112.1", valid); got != http.StatusNoContent {
+		t.Errorf("delivery from new hook range: got %d, want 204", got)
+	}
+	if got := post("192.30.253.1", valid); got != http.StatusForbidden {
+		t.Errorf("delivery from removed hook range: got %d, want 403", got)
+	}
+
+	// Two bad signatures exhaust the budget, even for a valid delivery.
+	for range 2 {
+		if got := post("127.0.0.1", "sha256=00"); got != http.StatusUnauthorized {
+			t.Fatalf("bad signature: got %d, want 401", got)
+		}
+	}
+	if got := post("127.0.0.1", valid); got != http.StatusTooManyRequests {
+		t.Errorf("after failures: got %d, want 429", got)
+	}
+	if got := post("140.82.112.1", valid); got != http.StatusNoContent {
+		t.Errorf("other IP: got %d, want 204", got)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultArtifactsMaxRuns is how many runs' artifacts are kept per repo.
+	defaultArtifactsMaxRuns = 10
+	// defaultArtifactsMaxBytes caps a repo's kept artifacts.
+	defaultArtifactsMaxBytes = 1 << 30
+)
+
+// runIDPattern matches run ids, so they are safe as path components.
+var runIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ArtifactsConfig limits a repo's kept run artifacts. The oldest runs'
+// artifacts are deleted first.
+type ArtifactsConfig struct {
+	// MaxRuns is how many runs' artifacts are kept (default 10).
+	MaxRuns int `json:"max_runs"`
+	// MaxBytes caps the total size of kept artifacts (default 1 GiB).
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// artifactFile is one file a run left in its artifacts dir.
+type artifactFile struct {
+	Path string // slash-separated, relative to the artifacts dir
+	Size int64
+}
+
+// cacheDir returns job's persistent cache dir, or "" without a data dir.
+func (h *repoHandler) cacheDir(job string) string {
+	if h.dataDir == "" {
+		return ""
+	}
+	name := "default"
+	if job != "" {
+		name = "job-" + url.PathEscape(job)
+	}
+	return filepath.Join(h.cacheRoot(), name)
+}
+
+// cacheRoot returns the dir holding the repo's job caches, or "".
+func (h *repoHandler) cacheRoot() string {
+	if h.dataDir == "" {
+		return ""
+	}
+	return filepath.Join(h.dataDir, "cache", h.fullName)
+}
+
+// artifactsRoot returns the dir holding the repo's run artifacts, or "".
+func (h *repoHandler) artifactsRoot() string {
+	if h.dataDir == "" {
+		return ""
+	}
+	return filepath.Join(h.dataDir, "artifacts", h.fullName)
+}
+
+// artifactsDir returns runID's artifacts dir, or "".
+func (h *repoHandler) artifactsDir(runID string) string {
+	if h.dataDir == "" {
+		return ""
+	}
+	return filepath.Join(h.artifactsRoot(), runID)
+}
+
+// prepareRunDirs creates the run's cache and artifacts dirs, handing them to
+// the run_as user.
+func (h *repoHandler) prepareRunDirs(tctx triggerContext) error {
+	if h.dataDir == "" {
+		return nil
+	}
+	for _, dir := range []string{h.cacheDir(tctx.job), h.artifactsDir(tctx.runID)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create run dir: %w", err)
+		}
+		if runAs := h.repo.RunAs; runAs != nil {
+			if err := os.Chown(dir, int(runAs.UID), int(runAs.GID)); err != nil {
+				return fmt.Errorf("chown run dir: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// listArtifacts returns the regular files under dir, sorted by path.
+// Symlinks and other special files are ignored.
+func listArtifacts(dir string) []artifactFile {
+	var files []artifactFile
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		files = append(files, artifactFile{Path: filepath.ToSlash(rel), Size: info.Size()})
+		return nil
+	})
+	return files
+}
+
+// collectArtifacts records the files the run left in its artifacts dir, then
+// applies the repo's retention limits. Runs without artifacts leave no dir.
+func (h *repoHandler) collectArtifacts(rec *runRecord, tctx triggerContext, logger *slog.Logger) {
+	dir := h.artifactsDir(tctx.runID)
+	if dir == "" {
+		return
+	}
+
+	files := listArtifacts(dir)
+	if len(files) == 0 {
+		_ = os.RemoveAll(dir)
+		return
+	}
+	h.history.setArtifacts(rec, files)
+	logger.Info("kept artifacts", "files", len(files), "dir", dir)
+	h.pruneArtifacts(logger)
+}
+
+// pruneArtifacts deletes the oldest runs' artifacts beyond the repo's
+// max_runs or max_bytes.
+func (h *repoHandler) pruneArtifacts(logger *slog.Logger) {
+	maxRuns, maxBytes := defaultArtifactsMaxRuns, int64(defaultArtifactsMaxBytes)
+	if a := h.repo.Artifacts; a != nil {
+		if a.MaxRuns > 0 {
+			maxRuns = a.MaxRuns
+		}
+		if a.MaxBytes > 0 {
+			maxBytes = a.MaxBytes
+		}
+	}
+
+	entries, err := os.ReadDir(h.artifactsRoot())
+	if err != nil {
+		logger.Warn("list artifacts failed", "err", err)
+		return
+	}
+
+	type runDir struct {
+		id      string
+		modTime time.Time
+	}
+	var runs []runDir
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		runs = append(runs, runDir{e.Name(), info.ModTime()})
+	}
+	// Newest first.
+	slices.SortFunc(runs, func(a, b runDir) int { return b.modTime.Compare(a.modTime) })
+
+	var total int64
+	for i, run := range runs {
+		dir := filepath.Join(h.artifactsRoot(), run.id)
+		for _, f := range listArtifacts(dir) {
+			total += f.Size
+		}
+		if i < maxRuns && total <= maxBytes {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Warn("prune artifacts failed", "artifacts_run_id", run.id, "err", err)
+			continue
+		}
+		logger.Info("pruned artifacts", "artifacts_run_id", run.id)
+	}
+}
+
+// handleArtifact serves a run's artifact, or lists them for an empty path.
+func (a *app) handleArtifact(w http.ResponseWriter, r *http.Request) {
+	id, name := r.PathValue("id"), r.PathValue("path")
+	if !runIDPattern.MatchString(id) || (name != "" && !fs.ValidPath(name)) {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	for _, h := range a.handlerList() {
+		repo := h.fullName
+		dir := h.artifactsDir(id)
+		if dir == "" {
+			continue
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+
+		if name == "" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			err := dashboardTmpl.ExecuteTemplate(w, "artifacts", map[string]any{
+				"Repo":  repo,
+				"RunID": id,
+				"Files": listArtifacts(dir),
+			})
+			if err != nil {
+				slog.Warn("render artifacts", "err", err)
+			}
+			return
+		}
+		serveArtifact(w, r, dir, name)
+		return
+	}
+	http.Error(w, "artifacts not found (only recent runs are kept)", http.StatusNotFound)
+}
+
+// serveArtifact serves dir/name if it is a regular file inside dir. Runs
+// control the artifacts dir, so it must not become a way to read arbitrary
+// files as the daemon: the file is opened through an os.Root, so symlinks
+// can't lead out of dir even if swapped in mid-request, and without
+// blocking, so a FIFO is refused instead of wedging the request.
+func serveArtifact(w http.ResponseWriter, r *http.Request, dir, name string) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		http.Error(w, "artifact not found", http.StatusNotFound)
+		return
+	}
+	defer root.Close()
+
+	f, err := root.OpenFile(filepath.FromSlash(name),
+		os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		http.Error(w, "artifact not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.Error(w, "artifact not found", http.StatusNotFound)
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
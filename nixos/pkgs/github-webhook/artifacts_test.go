@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunCommandArtifacts keeps the cache dir across runs, collects and
+// serves artifacts, prunes old runs and refuses symlinks out of the
+// artifacts dir.
+func TestRunCommandArtifacts(t *testing.T) {
+	dataDir := t.TempDir()
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Command: []string{"sh", "-c", `
+				echo run >> "$GH_CACHE_DIR/runs"
+				mkdir "$GH_ARTIFACTS_DIR/out"
+				cp "$GH_CACHE_DIR/runs" "$GH_ARTIFACTS_DIR/out/runs.txt"
+				ln -s /etc/passwd "$GH_ARTIFACTS_DIR/passwd"
+				mkfifo "$GH_ARTIFACTS_DIR/fifo"
+			`},
+			WorkingDir: dataDir,
+			Artifacts:  &ArtifactsConfig{MaxRuns: 2},
+		},
+		timeout: 5 * time.Second,
+		history: newRunHistory(),
+		dataDir: dataDir,
+	}
+	a := &app{handlers: map[string]*repoHandler{"test/repo": handler}}
+
+	var ids []string
+	for i := range 3 {
+		tctx := triggerContext{event: "push", branch: "master", runID: "run" + string(rune('a'+i))}
+		if err := handler.runCommand(t.Context(), tctx); err != nil {
+			t.Fatalf("runCommand: %v", err)
+		}
+		ids = append(ids, tctx.runID)
+		// Distinct mtimes order the runs for pruning.
+		old := time.Now().Add(time.Duration(i-3) * time.Minute)
+		if err := os.Chtimes(handler.artifactsDir(tctx.runID), old, old); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+
+	rec, ok := handler.history.get(ids[2])
+	if !ok {
+		t.Fatalf("run %s not recorded", ids[2])
+	}
+	if want := []artifactFile{{"out/runs.txt", 12}}; !slices.Equal(rec.Artifacts, want) {
+		t.Errorf("artifacts = %v, want %v", rec.Artifacts, want)
+	}
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /runs/{id}/artifacts/{path...}", a.handleArtifact)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		body, _ := io.ReadAll(rr.Body)
+		return rr.Code, string(body)
+	}
+	if code, body := get("/runs/" + ids[2] + "/artifacts/out/runs.txt"); code != http.StatusOK || body != "run\nrun\nrun\n" {
+		t.Errorf("artifact: got %d %q", code, body)
+	}
+	if code, body := get("/runs/" + ids[2] + "/artifacts/"); code != http.StatusOK || !strings.Contains(body, `href="out/runs.txt"`) {
+		t.Errorf("listing: got %d %q", code, body)
+	}
+	for path, want := range map[string]int{
+		"/runs/" + ids[2] + "/artifacts/passwd":       http.StatusNotFound,
+		"/runs/" + ids[2] + "/artifacts/fifo":         http.StatusNotFound,
+		"/runs/" + ids[0] + "/artifacts/out/runs.txt": http.StatusNotFound,
+		"/runs/" + ids[2] + "/artifacts/missing":      http.StatusNotFound,
+	} {
+		if code, _ := get(path); code != want {
+			t.Errorf("GET %s: got %d, want %d", path, code, want)
+		}
+	}
+}
+
+// TestRunCommandSharedCacheDir runs one job on two branches with their own
+// working dirs at once; the runs must take turns with the shared cache dir.
+func TestRunCommandSharedCacheDir(t *testing.T) {
+	dataDir := t.TempDir()
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Branches: []Branch{
+				{Name: "master", WorkingDir: t.TempDir()},
+				{Name: "staging", WorkingDir: t.TempDir()},
+			},
+			// mkdir fails if the other branch's run holds the cache.
+			Command: []string{"sh", "-c", `mkdir "$GH_CACHE_DIR/busy" && sleep 0.2 && rmdir "$GH_CACHE_DIR/busy"`},
+		},
+		timeout: 5 * time.Second,
+		history: newRunHistory(),
+		dataDir: dataDir,
+	}
+
+	errs := make(chan error, 2)
+	for i, branch := range []string{"master", "staging"} {
+		tctx := triggerContext{event: "push", branch: branch, runID: "run" + string(rune('a'+i))}
+		go func() { errs <- handler.runCommand(t.Context(), tctx) }()
+	}
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Errorf("runCommand: %v", err)
+		}
+	}
+}
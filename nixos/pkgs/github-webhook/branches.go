@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Branch is a tracked branch with optional overrides of the repo defaults.
+// In JSON it is either the branch name or an object.
+type Branch struct {
+	// Name is the short branch name, e.g. "master".
+	Name string `json:"name"`
+	// Command overrides the repo command (or steps) for this branch's runs.
+	Command []string `json:"command"`
+	// WorkingDir overrides the repo working dir.
+	WorkingDir string `json:"working_dir"`
+	// Env adds environment variables to this branch's commands, over the
+	// daemon environment but under the GH_* variables.
+	Env map[string]string `json:"env"`
+	// TimeoutMs overrides the repo timeout. 0 uses the repo's.
+	TimeoutMs int `json:"timeout_ms"`
+}
+
+// UnmarshalJSON accepts a branch name or an object. Unknown object fields
+// are rejected like everywhere else in the config.
+func (b *Branch) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*b = Branch{Name: name}
+		return nil
+	}
+
+	// plain has Branch's fields without this method.
+	type plain Branch
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var p plain
+	if err := dec.Decode(&p); err != nil {
+		return fmt.Errorf("branch must be a name or an object: %w", err)
+	}
+	*b = Branch(p)
+	return nil
+}
+
+// validate checks a branch. Errors are prefixed with the field name.
+func (b *Branch) validate() []error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if b.Name == "" || strings.HasPrefix(b.Name, "refs/") {
+		add("name: invalid branch name %q (use the short name)", b.Name)
+	}
+	if len(b.Command) > 0 && b.Command[0] == "" {
+		add("command: empty program")
+	}
+	if b.TimeoutMs < 0 {
+		add("timeout_ms: must be >= 0, got %d", b.TimeoutMs)
+	}
+	for _, name := range sortedKeys(b.Env) {
+		if name == "" || strings.Contains(name, "=") {
+			add("env: invalid variable name %q", name)
+		} else if strings.HasPrefix(name, "GH_") {
+			add("env: %s: GH_* variables are set by the daemon", name)
+		}
+	}
+	return errs
+}
+
+// branchNames returns the names of the tracked branches.
+func (repo *Repo) branchNames() []string {
+	names := make([]string, len(repo.Branches))
+	for i, b := range repo.Branches {
+		names[i] = b.Name
+	}
+	return names
+}
+
+// branch returns the tracked branch name, or nil.
+func (repo *Repo) branch(name string) *Branch {
+	for i := range repo.Branches {
+		if repo.Branches[i].Name == name {
+			return &repo.Branches[i]
+		}
+	}
+	return nil
+}
+
+// workingDirs returns the distinct working dirs of the repo and its branch
+// overrides.
+func (repo *Repo) workingDirs() []string {
+	dirs := []string{repo.WorkingDir}
+	for _, b := range repo.Branches {
+		if b.WorkingDir != "" && b.WorkingDir != repo.WorkingDir {
+			dirs = append(dirs, b.WorkingDir)
+		}
+	}
+	return dirs
+}
+
+// workingDir returns the working dir of runs on branch.
+func (h *repoHandler) workingDir(branch string) string {
+	if b := h.repo.branch(branch); b != nil && b.WorkingDir != "" {
+		return b.WorkingDir
+	}
+	return h.repo.WorkingDir
+}
+
+// timeoutFor returns the default command timeout of runs on branch.
+func (h *repoHandler) timeoutFor(branch string) time.Duration {
+	if b := h.repo.branch(branch); b != nil && b.TimeoutMs > 0 {
+		return time.Duration(b.TimeoutMs) * time.Millisecond
+	}
+	return h.timeout
+}
+
+// branchEnv returns the env overrides of runs on branch.
+func (h *repoHandler) branchEnv(branch string) []string {
+	b := h.repo.branch(branch)
+	if b == nil {
+		return nil
+	}
+	env := make([]string, 0, len(b.Env))
+	for _, name := range sortedKeys(b.Env) {
+		env = append(env, name+"="+b.Env[name])
+	}
+	return env
+}
+
+// debouncerFor returns the debouncer of runs on branch: the branch's own, so
+// pushes to different branches never coalesce, or the repo's for runs
+// without a tracked branch (schedules, releases).
+func (h *repoHandler) debouncerFor(branch string) *debouncer {
+	if d, ok := h.branchDebs[branch]; ok {
+		return d
+	}
+	return h.deb
+}
+
+// enqueue queues tctx on the debouncer of its branch.
+func (h *repoHandler) enqueue(tctx triggerContext) {
+	h.debouncerFor(tctx.branch).enqueue(tctx)
+}
+
+// debouncers returns the repo's debouncer followed by the branch ones.
+func (h *repoHandler) debouncers() []*debouncer {
+	var debs []*debouncer
+	if h.deb != nil {
+		debs = append(debs, h.deb)
+	}
+	for _, name := range sortedKeys(h.branchDebs) {
+		debs = append(debs, h.branchDebs[name])
+	}
+	return debs
+}
+
+// lockDir serializes runs sharing a dir (working dir or job cache dir),
+// which branch debouncers would otherwise run concurrently. It returns the
+// unlock func.
+func (h *repoHandler) lockDir(dir string) func() {
+	h.mu.Lock()
+	if h.dirLocks == nil {
+		h.dirLocks = make(map[string]*sync.Mutex)
+	}
+	lock, ok := h.dirLocks[dir]
+	if !ok {
+		lock = &sync.Mutex{}
+		h.dirLocks[dir] = lock
+	}
+	h.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
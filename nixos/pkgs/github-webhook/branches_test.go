@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+// TestBranchOverrides runs each branch with its own overrides, and pushes to
+// different branches within the quiet period both run.
+func TestBranchOverrides(t *testing.T) {
+	dir := t.TempDir()
+	stagingDir := filepath.Join(dir, "staging")
+	if err := os.Mkdir(stagingDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	secretPath := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretPath, []byte("supersecret"), 0o600); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+	outPath := filepath.Join(dir, "runs")
+
+	cfg, err := loadConfig(writeConfig(t, `{
+		"port": "8080",
+		"repos": {
+			"test/repo": {
+				"secret_path": "`+secretPath+`",
+				"branches": [
+					"master",
+					{
+						"name": "staging",
+						"command": ["sh", "-c", "echo staging $DEPLOY_ENV $PWD >> `+outPath+`"],
+						"working_dir": "`+stagingDir+`",
+						"env": {"DEPLOY_ENV": "stage"},
+						"timeout_ms": 1000
+					}
+				],
+				"command": ["sh", "-c", "echo master $PWD >> `+outPath+`"],
+				"working_dir": "`+dir+`",
+				"quiet_ms": 50,
+				"timeout_ms": 5000
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	a, err := newApp(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("newApp: %v", err)
+	}
+	a.start(t.Context())
+
+	handler := a.handlers["test/repo"]
+	if got := handler.timeoutFor("staging"); got != time.Second {
+		t.Errorf("staging timeout = %s, want 1s", got)
+	}
+
+	for _, branch := range []string{"master", "staging"} {
+		body := []byte(`{"ref":"refs/heads/` + branch + `","after":"abc1230000000000000000000000000000000000",` +
+			`"repository":{"full_name":"test/repo"}}`)
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", webhook.Sign([]byte("supersecret"), body))
+		rr := httptest.NewRecorder()
+		a.handleWebhook(rr, req)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("push to %s: got %d", branch, rr.Code)
+		}
+	}
+
+	var lines []string
+	for deadline := time.Now().Add(5 * time.Second); len(lines) < 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("runs = %q, want both branches", lines)
+		}
+		time.Sleep(10 * time.Millisecond)
+		data, _ := os.ReadFile(outPath)
+		lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+	slices.Sort(lines)
+	want := []string{"master " + dir, "staging stage " + stagingDir}
+	if !slices.Equal(lines, want) {
+		t.Errorf("runs = %q, want %q", lines, want)
+	}
+}
+
+func TestLoadConfigValidatesBranches(t *testing.T) {
+	for _, tc := range []struct {
+		branches, want string
+	}{
+		{`[{"name": "master", "workdir": "/srv"}]`, `unknown field "workdir"`},
+		{`["master", {"name": "master"}]`, `branches[1]: duplicate branch "master"`},
+		{`["refs/heads/master"]`, `branches[0].name: invalid branch name "refs/heads/master"`},
+		{`[{"name": "x", "env": {"GH_REPO": "y"}}]`, `branches[0].env: GH_REPO: GH_* variables are set by the daemon`},
+		{`[{"name": "x", "timeout_ms": -1}]`, `branches[0].timeout_ms: must be >= 0`},
+	} {
+		_, err := loadConfig(writeConfig(t, `{
+			"port": "8080",
+			"repos": {
+				"test/repo": {
+					"secret_path": "/tmp/secret",
+					"branches": `+tc.branches+`,
+					"command": ["true"],
+					"working_dir": "/tmp",
+					"timeout_ms": 1000
+				}
+			}
+		}`))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("branches %s: got %v, want %q", tc.branches, err, tc.want)
+		}
+	}
+}
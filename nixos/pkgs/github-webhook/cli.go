@@ -0,0 +1,348 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+// runSubcommand runs an offline CLI subcommand and returns its exit code.
+func runSubcommand(name string, args []string) int {
+	var err error
+	switch name {
+	case "simulate":
+		err = runSimulate(os.Stdout, args)
+	case "sign":
+		err = runSign(os.Stdout, args)
+	case "check-config":
+		err = runCheckConfig(os.Stdout, args)
+	case "-h", "-help", "--help", "help":
+		printUsage(os.Stdout)
+		return 0
+	default:
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "github-webhook %s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// printUsage prints top-level usage.
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, `usage:
+  github-webhook                run the daemon (config from $CONFIG_PATH)
+  github-webhook simulate --config FILE --event EVENT --payload FILE
+                  [--signature SIG] [--form] [--execute] [--force]
+  github-webhook sign (--secret-path PATH | --config FILE) [--payload FILE]
+  github-webhook check-config [--config FILE] [--static]
+`)
+}
+
+// runSimulate routes a delivery in-process (signature, repo lookup, branch
+// filter, trigger context) and prints what would run with which environment,
+// or for installation events the plan for each added or removed repo.
+// --signature replaces the signature computed with the repo's secret; --form
+// sends the payload form-encoded; --execute actually runs it, recording into
+// an in-memory copy of the state and a temporary data dir; --force runs it
+// even if the commit already succeeded or deploys are frozen.
+func runSimulate(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_PATH"), "config file")
+	event := fs.String("event", "push", "X-GitHub-Event value")
+	payloadPath := fs.String("payload", "-", "payload JSON file ('-' for stdin)")
+	signature := fs.String("signature", "",
+		"X-Hub-Signature-256 value (default: signed with the repo's secret)")
+	form := fs.Bool("form", false,
+		"send the payload form-encoded, like hooks with content type "+webhook.ContentTypeForm)
+	execute := fs.Bool("execute", false, "actually run the command")
+	force := fs.Bool("force", false, "run even if the commit already succeeded or deploys are frozen")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		return errors.New("--config is required")
+	}
+
+	body, err := readPayload(*payloadPath)
+	if err != nil {
+		return err
+	}
+	contentType := webhook.ContentTypeJSON
+	if *form {
+		contentType = webhook.ContentTypeForm
+		body = []byte(url.Values{"payload": {string(body)}}.Encode())
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	// Runs see the daemon's state but record into a copy, and get their own
+	// cache and artifacts dirs, so --execute never touches the daemon's.
+	if cfg.DataDir != "" {
+		dir, err := os.MkdirTemp("", "github-webhook-simulate-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		cfg.DataDir = dir
+	}
+
+	ctx := context.Background()
+	a, err := newApp(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	a.state.detach()
+
+	sigHeader := *signature
+	sigSource := "provided"
+	if sigHeader == "" {
+		if secret := a.secretFor(payloadRepo(contentType, body)); secret != nil {
+			sigHeader = webhook.Sign(secret, body)
+			sigSource = "computed with repo secret"
+		}
+	}
+
+	fmt.Fprintf(w, "event:       %s\n", *event)
+	fmt.Fprintf(w, "repo:        %s\n", payloadRepo(contentType, body))
+	fmt.Fprintf(w, "signature:   %s\n", sigSource)
+
+	header := http.Header{}
+	header.Set("X-GitHub-Event", *event)
+	header.Set("Content-Type", contentType)
+	header.Set("X-Hub-Signature-256", sigHeader)
+	d, err := a.route(ctx, spanContext{}, header, body)
+	if d != nil && d.handler != nil {
+		for _, target := range d.handler.forwardTargets() {
+			fmt.Fprintf(w, "forward:     %s\n", target)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(w, "result:      rejected (%v)\n", err)
+		return errors.New("delivery rejected")
+	}
+	if c := d.installation; c != nil {
+		fmt.Fprintf(w, "result:      installation %s by %s\n", c.action, c.account)
+		for _, repo := range c.added {
+			plan, _ := a.installationPlan(repo, true)
+			fmt.Fprintf(w, "added:       %s: %s\n", repo, plan)
+		}
+		for _, repo := range c.removed {
+			plan, _ := a.installationPlan(repo, false)
+			fmt.Fprintf(w, "removed:     %s: %s\n", repo, plan)
+		}
+		return nil
+	}
+	if len(d.runs) == 0 {
+		fmt.Fprintf(w, "result:      acknowledged, nothing to run\n")
+		return nil
+	}
+
+	for _, tctx := range d.runs {
+		tctx.force = *force
+		if err := simulateRun(ctx, w, d.handler, tctx, *execute); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// simulateRun prints what one triggered run would do, and runs it with
+// execute set.
+func simulateRun(
+	ctx context.Context,
+	w io.Writer,
+	h *repoHandler,
+	tctx triggerContext,
+	execute bool,
+) error {
+	tctx.previousCommit = h.previousCommit(tctx)
+	// Assigned up front so GH_ARTIFACTS_DIR shows the dir --execute uses.
+	tctx.runID = newRunID()
+	if tctx.job != "" {
+		fmt.Fprintf(w, "job:         %s\n", tctx.job)
+	}
+	if !tctx.force && tctx.commit != "" && tctx.commit == tctx.previousCommit {
+		fmt.Fprintf(w, "result:      skipped, commit already succeeded (use --force)\n")
+		return nil
+	}
+	if f, ok := h.frozen(time.Now()); ok && !tctx.force {
+		fmt.Fprintf(w, "result:      held, %s (use --force)\n", f)
+		return nil
+	}
+	fmt.Fprintf(w, "result:      would trigger run\n")
+	fmt.Fprintf(w, "branch:      %s\n", tctx.branch)
+	steps := h.stepsFor(tctx)
+	if len(steps) == 1 && steps[0].Name == "" {
+		fmt.Fprintf(w, "command:     %s\n", strings.Join(steps[0].Command, " "))
+	} else {
+		fmt.Fprintf(w, "steps:\n")
+		// Changed files are only known at run time.
+		unknown := func() ([]string, bool) { return nil, false }
+		for _, step := range steps {
+			fmt.Fprintf(w, "  %s: %s (timeout %s)", step.Name,
+				strings.Join(step.Command, " "), h.stepTimeout(step, tctx.branch))
+			switch reason := stepEnabled(step, tctx, unknown); {
+			case reason != "":
+				fmt.Fprintf(w, " skipped: %s", reason)
+			case step.If != nil && len(step.If.Paths) > 0:
+				fmt.Fprintf(w, " if.paths checked at run time")
+			}
+			if step.ContinueOnError {
+				fmt.Fprintf(w, " continue_on_error")
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	fmt.Fprintf(w, "working_dir: %s\n", h.workingDir(tctx.branch))
+	fmt.Fprintf(w, "timeout:     %s\n", h.timeoutFor(tctx.branch))
+	if h.repo.Isolation != "" {
+		fmt.Fprintf(w, "isolation:   %s\n", h.repo.Isolation)
+	}
+	if h.repo.StdinPayload {
+		fmt.Fprintf(w, "stdin:       delivery payload (%d bytes)\n", len(tctx.payload))
+	}
+	fmt.Fprintf(w, "environment:\n")
+	for _, kv := range append(h.branchEnv(tctx.branch), h.contextEnv(tctx, 1)...) {
+		fmt.Fprintf(w, "  %s\n", kv)
+	}
+	for _, name := range sortedKeys(h.secrets) {
+		fmt.Fprintf(w, "  %s=%s (secret, %s)\n", name, redactedSecret,
+			cmp.Or(h.repo.SecretsMode, secretsModeEnv))
+	}
+	switch {
+	case h.repo.CleanEnv || len(h.repo.PassEnv) > 0:
+		fmt.Fprintf(w, "  + daemon variables: %s\n", strings.Join(h.repo.PassEnv, " "))
+	case h.repo.Isolation == isolationSystemdRun:
+		fmt.Fprintf(w, "  + daemon variables: %s\n", strings.Join(systemdRunBaseEnv, " "))
+	default:
+		fmt.Fprintf(w, "  + full daemon environment\n")
+	}
+
+	if !execute {
+		return nil
+	}
+
+	fmt.Fprintf(w, "executing...\n")
+	if err := h.runCommand(ctx, tctx); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "run succeeded\n")
+	return nil
+}
+
+// runSign prints an X-Hub-Signature-256 value for a payload, for curl tests.
+// With --config, the repo's secret_path is used, or else github_app's.
+func runSign(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
+	secretPath := fs.String("secret-path", "", "secret file (supports %d/)")
+	configPath := fs.String("config", "",
+		"config file; signs with the secret of the payload's repo")
+	payloadPath := fs.String("payload", "-", "payload JSON file ('-' for stdin)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	body, err := readPayload(*payloadPath)
+	if err != nil {
+		return err
+	}
+
+	path := *secretPath
+	if path == "" {
+		if *configPath == "" {
+			return errors.New("--secret-path or --config is required")
+		}
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+		repo := payloadRepo(webhook.ContentTypeJSON, body)
+		repoCfg, ok := cfg.Repos[repo]
+		if !ok {
+			_, repoCfg = cfg.repoTemplate(repo)
+		}
+		switch {
+		case repoCfg != nil && repoCfg.SecretPath != "":
+			path = repoCfg.SecretPath
+		case cfg.GitHubApp != nil:
+			// App hook deliveries, including installation events without a
+			// repository, are signed with the app secret.
+			path = cfg.GitHubApp.SecretPath
+		default:
+			return fmt.Errorf("repository %q not configured", repo)
+		}
+	}
+
+	secret, err := readSecret(path)
+	if err != nil {
+		return fmt.Errorf("read secret: %w", err)
+	}
+
+	fmt.Fprintln(w, webhook.Sign(secret, body))
+	return nil
+}
+
+// runCheckConfig strictly parses and validates a config file, including that
+// commands, working dirs and secrets exist. --static skips checks that need
+// the runtime environment (working dirs, secrets, PATH lookups, commands
+// outside /nix/store), for use at build time.
+func runCheckConfig(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_PATH"), "config file")
+	static := fs.Bool("static", false,
+		"skip checks that need the runtime environment (working dirs, secrets, PATH)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		return errors.New("--config is required")
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if err := checkConfigFiles(&cfg, *static); err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+
+	fmt.Fprintf(w, "%s: ok (%d repos)\n", *configPath, len(cfg.Repos))
+	return nil
+}
+
+// readPayload reads a payload file, or stdin for "-".
+func readPayload(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// payloadRepo extracts repository.full_name from a delivery body, or "".
+func payloadRepo(contentType string, body []byte) string {
+	payload, err := webhook.DecodePayload(contentType, body)
+	if err != nil {
+		return ""
+	}
+	return webhook.RepositoryName(payload)
+}
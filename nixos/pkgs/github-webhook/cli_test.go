@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+// writeSimulateFixture writes a config, secret and push payload for branch.
+func writeSimulateFixture(t *testing.T, branch string) (configPath, payloadPath, work string) {
+	t.Helper()
+	dir := t.TempDir()
+	work = t.TempDir()
+
+	secretPath := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretPath, []byte("supersecret\n"), 0o600); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+
+	configPath = filepath.Join(dir, "config.json")
+	config := `{
+		"port": "0",
+		"repos": {
+			"test/repo": {
+				"secret_path": "` + secretPath + `",
+				"branches": ["master"],
+				"command": ["sh", "-c", "echo \"$GH_COMMIT\" > ran.txt"],
+				"working_dir": "` + work + `",
+				"timeout_ms": 5000
+			}
+		}
+	}`
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	payloadPath = filepath.Join(dir, "payload.json")
+	payload := `{"ref":"refs/heads/` + branch + `","after":"abc1230000000000000000000000000000000000","repository":{"full_name":"test/repo"},"sender":{"login":"alice"}}`
+	if err := os.WriteFile(payloadPath, []byte(payload), 0o644); err != nil {
+		t.Fatalf("write payload: %v", err)
+	}
+	return configPath, payloadPath, work
+}
+
+// TestSimulatePrintsRun routes a push in-process without running anything.
+func TestSimulatePrintsRun(t *testing.T) {
+	configPath, payloadPath, work := writeSimulateFixture(t, "master")
+
+	var out bytes.Buffer
+	err := runSimulate(&out, []string{
+		"--config", configPath, "--event", "push", "--payload", payloadPath,
+	})
+	if err != nil {
+		t.Fatalf("simulate: %v\n%s", err, out.String())
+	}
+
+	for _, want := range []string{
+		"result:      would trigger run",
+		"GH_BRANCH=master",
+		"GH_COMMIT=abc1230000000000000000000000000000000000",
+		"GH_SENDER=alice",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(work, "ran.txt")); !os.IsNotExist(err) {
+		t.Fatalf("simulate without --execute must not run the command")
+	}
+}
+
+// TestSimulateExecute runs the routed command with --execute.
+func TestSimulateExecute(t *testing.T) {
+	configPath, payloadPath, work := writeSimulateFixture(t, "master")
+
+	var out bytes.Buffer
+	err := runSimulate(&out, []string{
+		"--config", configPath, "--payload", payloadPath, "--execute",
+	})
+	if err != nil {
+		t.Fatalf("simulate: %v\n%s", err, out.String())
+	}
+
+	data, err := os.ReadFile(filepath.Join(work, "ran.txt"))
+	if err != nil {
+		t.Fatalf("read ran.txt: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != "abc1230000000000000000000000000000000000" {
+		t.Fatalf("unexpected GH_COMMIT: %q", data)
+	}
+}
+
+// TestSimulateExecuteKeepsDaemonState runs with --execute without writing
+// the daemon's state file or data dir.
+func TestSimulateExecuteKeepsDaemonState(t *testing.T) {
+	configPath, payloadPath, work := writeSimulateFixture(t, "master")
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	dataDir := t.TempDir()
+	config, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	config = bytes.Replace(config, []byte(`"port": "0",`), []byte(`"port": "0",
+		"state_path": "`+statePath+`",
+		"data_dir": "`+dataDir+`",`), 1)
+	if err := os.WriteFile(configPath, config, 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	var out bytes.Buffer
+	err = runSimulate(&out, []string{
+		"--config", configPath, "--payload", payloadPath, "--execute",
+	})
+	if err != nil {
+		t.Fatalf("simulate: %v\n%s", err, out.String())
+	}
+
+	if _, err := os.Stat(filepath.Join(work, "ran.txt")); err != nil {
+		t.Fatalf("command did not run: %v", err)
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("simulate wrote the daemon's state file: %v", err)
+	}
+	if entries, _ := os.ReadDir(dataDir); len(entries) != 0 {
+		t.Errorf("simulate wrote to the daemon's data dir: %v", entries)
+	}
+}
+
+// TestSimulateRejectsUntrackedBranch reports the routing rejection.
+func TestSimulateRejectsUntrackedBranch(t *testing.T) {
+	configPath, payloadPath, _ := writeSimulateFixture(t, "feature")
+
+	var out bytes.Buffer
+	err := runSimulate(&out, []string{"--config", configPath, "--payload", payloadPath})
+	if err == nil {
+		t.Fatalf("expected rejection")
+	}
+	if !strings.Contains(out.String(), "400 branch not tracked") {
+		t.Fatalf("expected branch rejection:\n%s", out.String())
+	}
+}
+
+// TestSignMatchesVerifier produces a signature the daemon accepts.
+func TestSignMatchesVerifier(t *testing.T) {
+	configPath, payloadPath, _ := writeSimulateFixture(t, "master")
+
+	var out bytes.Buffer
+	if err := runSign(&out, []string{"--config", configPath, "--payload", payloadPath}); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	body, err := os.ReadFile(payloadPath)
+	if err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+	if webhook.HMAC([]byte("supersecret")).Verify(body, strings.TrimSpace(out.String())) != nil {
+		t.Fatalf("signature %q does not verify", out.String())
+	}
+}
+
+// TestSimulateInstallationPlan prints what an installation event would do to
+// each added repo.
+func TestSimulateInstallationPlan(t *testing.T) {
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "app-secret")
+	if err := os.WriteFile(secretPath, []byte("appsecret\n"), 0o600); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+	configPath := writeConfig(t, `{
+		"port": "0",
+		"github_app": {"secret_path": "`+secretPath+`"},
+		"repos": {
+			"test/*": {
+				"branches": ["master"],
+				"command": ["true"],
+				"working_dir": "/srv/{name}",
+				"timeout_ms": 5000
+			}
+		}
+	}`)
+	payloadPath := filepath.Join(dir, "payload.json")
+	payload := `{"action":"added","installation":{"id":1,"account":{"login":"test"}},` +
+		`"repositories_added":[{"full_name":"test/site"},{"full_name":"other/repo"}]}`
+	if err := os.WriteFile(payloadPath, []byte(payload), 0o644); err != nil {
+		t.Fatalf("write payload: %v", err)
+	}
+
+	var out bytes.Buffer
+	err := runSimulate(&out, []string{
+		"--config", configPath, "--event", "installation_repositories", "--payload", payloadPath,
+	})
+	if err != nil {
+		t.Fatalf("simulate: %v\n%s", err, out.String())
+	}
+	for _, want := range []string{
+		"result:      installation added by test",
+		"added:       test/site: log only (matches test/*, auto_enable is off)",
+		"added:       other/repo: ignore (matches no repos pattern)",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
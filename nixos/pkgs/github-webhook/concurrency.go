@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+)
+
+// runSemaphore limits concurrent command runs across all repos. Waiters are
+// served by descending priority, then in arrival order.
+type runSemaphore struct {
+	mu     sync.Mutex
+	limit  int
+	active int
+	seq    uint64
+	// waiters is sorted by (priority desc, seq asc).
+	waiters []*semWaiter
+}
+
+// semWaiter is one blocked acquire call.
+type semWaiter struct {
+	priority int
+	seq      uint64
+	// ready is closed when the slot is handed to this waiter.
+	ready chan struct{}
+}
+
+// newRunSemaphore returns a semaphore with limit slots, or nil (unlimited) if
+// limit <= 0.
+func newRunSemaphore(limit int) *runSemaphore {
+	if limit <= 0 {
+		return nil
+	}
+	return &runSemaphore{limit: limit}
+}
+
+// acquire blocks until a run slot is free or ctx is done. If the caller has
+// to wait, onQueued is called with its 1-based queue position and the total
+// number of waiters.
+func (s *runSemaphore) acquire(
+	ctx context.Context,
+	priority int,
+	onQueued func(position, waiting int),
+) error {
+	s.mu.Lock()
+	if s.active < s.limit && len(s.waiters) == 0 {
+		s.active++
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.seq++
+	w := &semWaiter{priority: priority, seq: s.seq, ready: make(chan struct{})}
+	pos, _ := slices.BinarySearchFunc(s.waiters, w, compareWaiters)
+	s.waiters = slices.Insert(s.waiters, pos, w)
+	waiting := len(s.waiters)
+	s.mu.Unlock()
+
+	if onQueued != nil {
+		onQueued(pos+1, waiting)
+	}
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := slices.Index(s.waiters, w); i >= 0 {
+		s.waiters = slices.Delete(s.waiters, i, i+1)
+		return ctx.Err()
+	}
+	// The slot was handed to us concurrently with cancellation; pass it on.
+	s.releaseLocked()
+	return ctx.Err()
+}
+
+// release frees a slot, handing it to the highest-priority waiter.
+func (s *runSemaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+// releaseLocked implements release; s.mu must be held.
+func (s *runSemaphore) releaseLocked() {
+	if len(s.waiters) == 0 {
+		s.active--
+		return
+	}
+	w := s.waiters[0]
+	s.waiters = s.waiters[1:]
+	close(w.ready)
+}
+
+// stats returns the number of active runs and queued waiters.
+func (s *runSemaphore) stats() (active, waiting int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active, len(s.waiters)
+}
+
+// compareWaiters orders waiters by priority desc, then arrival order.
+func compareWaiters(a, b *semWaiter) int {
+	return cmp.Or(cmp.Compare(b.priority, a.priority), cmp.Compare(a.seq, b.seq))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRunSemaphorePriority serves queued waiters by priority, then FIFO.
+func TestRunSemaphorePriority(t *testing.T) {
+	sem := newRunSemaphore(1)
+	ctx := t.Context()
+
+	if err := sem.acquire(ctx, 0, nil); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	queued := make(chan struct{})
+
+	waiter := func(name string, priority int) {
+		defer wg.Done()
+		err := sem.acquire(ctx, priority, func(int, int) { queued <- struct{}{} })
+		if err != nil {
+			t.Errorf("acquire %s: %v", name, err)
+			return
+		}
+		mu.Lock()
+		order = append(order, name)
+		mu.Unlock()
+		sem.release()
+	}
+
+	// Enqueue one at a time so arrival order is deterministic.
+	for _, w := range []struct {
+		name     string
+		priority int
+	}{{"docs-1", 0}, {"host", 10}, {"docs-2", 0}} {
+		wg.Add(1)
+		go waiter(w.name, w.priority)
+		<-queued
+	}
+
+	if active, waiting := sem.stats(); active != 1 || waiting != 3 {
+		t.Fatalf("expected 1 active/3 waiting, got %d/%d", active, waiting)
+	}
+
+	sem.release()
+	wg.Wait()
+
+	if got := strings.Join(order, ","); got != "host,docs-1,docs-2" {
+		t.Fatalf("unexpected order: %s", got)
+	}
+	if active, waiting := sem.stats(); active != 0 || waiting != 0 {
+		t.Fatalf("expected idle semaphore, got %d/%d", active, waiting)
+	}
+}
+
+// TestRunSemaphoreCancel removes a cancelled waiter from the queue.
+func TestRunSemaphoreCancel(t *testing.T) {
+	sem := newRunSemaphore(1)
+	if err := sem.acquire(t.Context(), 0, nil); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if err := sem.acquire(ctx, 0, nil); err == nil {
+		t.Fatalf("expected cancelled acquire to fail")
+	}
+
+	if _, waiting := sem.stats(); waiting != 0 {
+		t.Fatalf("expected no waiters, got %d", waiting)
+	}
+
+	sem.release()
+	if err := sem.acquire(t.Context(), 0, nil); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+}
+
+// TestRunCommandWaitsForRunSlot blocks a run until the global slot is freed
+// and exports queue metrics.
+func TestRunCommandWaitsForRunSlot(t *testing.T) {
+	// metrics is process-wide, so compare against the counts before the run
+	// (go test -count=N reruns this test in the same process).
+	metrics.mu.Lock()
+	runsBefore := metrics.runs[[2]string{"test/slot", "success"}]
+	waitsBefore := metrics.queueWaitCount["test/slot"]
+	metrics.mu.Unlock()
+
+	sem := newRunSemaphore(1)
+	if err := sem.acquire(t.Context(), 0, nil); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	handler := &repoHandler{
+		fullName: "test/slot",
+		repo: Repo{
+			Command:    []string{"true"},
+			WorkingDir: t.TempDir(),
+			Priority:   5,
+		},
+		timeout: 5 * time.Second,
+		sem:     sem,
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- handler.runCommand(t.Context(), triggerContext{}) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("run finished before slot was free: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sem.release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("runCommand: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("run did not start after slot was freed")
+	}
+
+	a := &app{sem: sem}
+	rr := httptest.NewRecorder()
+	a.handleMetrics(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rr.Body.String()
+	for _, want := range []string{
+		fmt.Sprintf(`github_webhook_runs_total{repo="test/slot",result="success"} %d`, runsBefore+1),
+		fmt.Sprintf(`github_webhook_run_queue_wait_seconds_count{repo="test/slot"} %d`, waitsBefore+1),
+		`github_webhook_run_slots 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// validate checks every config field that can be checked without touching
+// the filesystem. All problems are reported at once.
+func (cfg *Config) validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 0 || port > 65535 {
+		add("port: invalid port %q", cfg.Port)
+	}
+	if cfg.DashboardAddr != "" {
+		_, port, err := net.SplitHostPort(cfg.DashboardAddr)
+		if n, perr := strconv.Atoi(port); err != nil || perr != nil || n < 0 || n > 65535 {
+			add("dashboard_addr: invalid address %q", cfg.DashboardAddr)
+		}
+	}
+	if cfg.MaxConcurrentRuns < 0 {
+		add("max_concurrent_runs: must be >= 0, got %d", cfg.MaxConcurrentRuns)
+	}
+	if cfg.Tracing != nil {
+		if err := cfg.Tracing.validate(); err != nil {
+			add("tracing.%w", err)
+		}
+	}
+	if cfg.GitHub != nil {
+		if err := cfg.GitHub.validate(); err != nil {
+			add("github.%w", err)
+		}
+	}
+	if cfg.GitHubApp != nil {
+		if err := cfg.GitHubApp.validate(); err != nil {
+			add("github_app.%w", err)
+		}
+	}
+	if cfg.PublicURL != "" {
+		u, err := url.Parse(cfg.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("public_url: must be an http(s) URL, got %q", cfg.PublicURL)
+		}
+	}
+	if cfg.StatePath != "" && !filepath.IsAbs(cfg.StatePath) {
+		add("state_path: must be an absolute path, got %q", cfg.StatePath)
+	}
+	if _, err := parsePrefixes(cfg.AllowedCIDRs); err != nil {
+		add("allowed_cidrs: %w", err)
+	}
+	if _, err := parsePrefixes(cfg.TrustedProxies); err != nil {
+		add("trusted_proxies: %w", err)
+	}
+	if cfg.GitHubMetaPath != "" && !filepath.IsAbs(cfg.GitHubMetaPath) {
+		add("github_meta_path: must be an absolute path, got %q", cfg.GitHubMetaPath)
+	}
+	if cfg.MaxAuthFailuresPerMinute < 0 {
+		add("max_auth_failures_per_minute: must be >= 0, got %d", cfg.MaxAuthFailuresPerMinute)
+	}
+	if cfg.DataDir != "" && !filepath.IsAbs(cfg.DataDir) {
+		add("data_dir: must be an absolute path, got %q", cfg.DataDir)
+	}
+	switch cfg.LogFormat {
+	case "", logFormatText, logFormatJSON:
+	default:
+		add("log_format: must be %q or %q, got %q",
+			logFormatText, logFormatJSON, cfg.LogFormat)
+	}
+	if len(cfg.Repos) == 0 {
+		add("repos: no repositories configured")
+	}
+
+	for _, name := range sortedKeys(cfg.Repos) {
+		repo := cfg.Repos[name]
+		if repo == nil {
+			add("repos.%s: must be an object", name)
+			continue
+		}
+		for _, err := range repo.validate() {
+			add("repos.%s.%w", name, err)
+		}
+		if repo.SecretPath == "" && cfg.GitHubApp == nil {
+			add("repos.%s.secret_path: required without github_app", name)
+		}
+		if isRepoPattern(name) {
+			if _, err := path.Match(name, ""); err != nil {
+				add("repos.%s: invalid pattern: %w", name, err)
+			}
+			if cfg.GitHubApp == nil {
+				add("repos.%s: patterns require github_app", name)
+			}
+		}
+		if cfg.GitHub == nil && repo.usesDeployments() {
+			add("repos.%s: environment requires the github config", name)
+		}
+		if cfg.DataDir == "" && repo.Artifacts != nil {
+			add("repos.%s: artifacts requires data_dir", name)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validate checks one repo's fields. Errors are prefixed with the field name.
+func (repo *Repo) validate() []error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(repo.Branches) == 0 {
+		add("branches: at least one branch is required")
+	}
+	branches := make(map[string]bool)
+	for i := range repo.Branches {
+		b := &repo.Branches[i]
+		if branches[b.Name] {
+			add("branches[%d]: duplicate branch %q", i, b.Name)
+		}
+		branches[b.Name] = true
+		for _, err := range b.validate() {
+			add("branches[%d].%w", i, err)
+		}
+	}
+	switch {
+	case len(repo.Steps) > 0 && len(repo.Command) > 0:
+		add("command: cannot be combined with steps")
+	case len(repo.Steps) == 0 && (len(repo.Command) == 0 || repo.Command[0] == ""):
+		add("command: required")
+	}
+	steps := make(map[string]bool)
+	for i, step := range repo.Steps {
+		if step == nil {
+			add("steps[%d]: must be an object", i)
+			continue
+		}
+		if steps[step.Name] {
+			add("steps[%d]: duplicate name %q", i, step.Name)
+		}
+		steps[step.Name] = true
+		for _, err := range step.validate() {
+			add("steps[%d].%w", i, err)
+		}
+	}
+	if repo.WorkingDir == "" {
+		add("working_dir: required")
+	}
+	if repo.QuietMs < 0 {
+		add("quiet_ms: must be >= 0, got %d", repo.QuietMs)
+	}
+	if repo.TimeoutMs <= 0 {
+		add("timeout_ms: must be > 0, got %d", repo.TimeoutMs)
+	}
+	if repo.MaxBodyBytes < 0 {
+		add("max_body_bytes: must be >= 0, got %d", repo.MaxBodyBytes)
+	}
+	if a := repo.Artifacts; a != nil {
+		if a.MaxRuns < 0 {
+			add("artifacts.max_runs: must be >= 0, got %d", a.MaxRuns)
+		}
+		if a.MaxBytes < 0 {
+			add("artifacts.max_bytes: must be >= 0, got %d", a.MaxBytes)
+		}
+	}
+	if repo.PollIntervalMs < 0 {
+		add("poll_interval_ms: must be >= 0, got %d", repo.PollIntervalMs)
+	}
+	if repo.PollIntervalMs > 0 && repo.RemoteURL == "" {
+		add("remote_url: required when poll_interval_ms is set")
+	}
+
+	names := make(map[string]bool)
+	for i, sched := range repo.Schedule {
+		if sched == nil {
+			add("schedule[%d]: must be an object", i)
+			continue
+		}
+		if names[sched.Name] {
+			add("schedule[%d]: duplicate name %q", i, sched.Name)
+		}
+		names[sched.Name] = true
+		if _, err := parseSchedule(sched); err != nil {
+			add("schedule[%d]: %w", i, err)
+		}
+	}
+	for i, et := range repo.Triggers {
+		if et == nil {
+			add("triggers[%d]: must be an object", i)
+			continue
+		}
+		if names[et.Name] {
+			add("triggers[%d]: duplicate job name %q", i, et.Name)
+		}
+		names[et.Name] = true
+		for _, err := range et.validate() {
+			add("triggers[%d].%w", i, err)
+		}
+	}
+
+	if r := repo.Retry; r != nil {
+		if r.MaxAttempts < 0 {
+			add("retry.max_attempts: must be >= 0, got %d", r.MaxAttempts)
+		}
+		if r.InitialBackoffMs < 0 || r.MaxBackoffMs < 0 {
+			add("retry: backoff durations must be >= 0")
+		}
+		for _, code := range r.ExitCodes {
+			if code <= 0 || code > 255 {
+				add("retry.exit_codes: invalid exit code %d", code)
+			}
+		}
+	}
+
+	for i, w := range repo.Freeze {
+		if w == nil {
+			add("freeze[%d]: must be an object", i)
+			continue
+		}
+		if _, err := parseFreezeWindow(w); err != nil {
+			add("freeze[%d].%w", i, err)
+		}
+	}
+
+	for i, target := range repo.ForwardTo {
+		if target == nil {
+			add("forward_to[%d]: must be an object", i)
+			continue
+		}
+		for _, err := range target.validate() {
+			add("forward_to[%d].%w", i, err)
+		}
+	}
+
+	if err := validateSandbox(repo); err != nil {
+		add("%w", err)
+	}
+	if err := validateSecrets(repo); err != nil {
+		add("%w", err)
+	}
+
+	return errs
+}
+
+// usesDeployments reports whether any of the repo's jobs has an environment.
+func (repo *Repo) usesDeployments() bool {
+	return repo.Environment != "" ||
+		slices.ContainsFunc(repo.Schedule,
+			func(s *Schedule) bool { return s != nil && s.Environment != "" }) ||
+		slices.ContainsFunc(repo.Triggers,
+			func(et *EventTrigger) bool { return et != nil && et.Environment != "" })
+}
+
+// checkConfigFiles checks that paths referenced by cfg exist. With static
+// set, only absolute command paths are checked, since working dirs, secrets
+// and PATH lookups only exist in the runtime environment.
+func checkConfigFiles(cfg *Config, static bool) error {
+	var errs []error
+	if !static && cfg.GitHubMetaPath != "" {
+		if _, err := readGitHubMeta(cfg.GitHubMetaPath); err != nil {
+			errs = append(errs, fmt.Errorf("github_meta_path: %w", err))
+		}
+	}
+	if !static && cfg.GitHubApp != nil {
+		if _, err := readSecret(cfg.GitHubApp.SecretPath); err != nil {
+			errs = append(errs, fmt.Errorf("github_app.secret_path: %w", err))
+		}
+	}
+	if !static && cfg.AdminTokenPath != "" {
+		if _, err := readSecret(cfg.AdminTokenPath); err != nil {
+			errs = append(errs, fmt.Errorf("admin_token_path: %w", err))
+		}
+	}
+	for _, name := range sortedKeys(cfg.Repos) {
+		repo := cfg.Repos[name]
+
+		commands := [][]string{repo.Command}
+		for _, sched := range repo.Schedule {
+			commands = append(commands, sched.Command)
+		}
+		for _, step := range repo.Steps {
+			commands = append(commands, step.Command)
+		}
+		for _, et := range repo.Triggers {
+			commands = append(commands, et.Command)
+		}
+		for _, b := range repo.Branches {
+			commands = append(commands, b.Command)
+		}
+		for _, command := range commands {
+			if len(command) == 0 ||
+				(isRepoPattern(name) && strings.ContainsRune(command[0], '{')) {
+				continue
+			}
+			if err := checkCommand(command[0], static); err != nil {
+				errs = append(errs, fmt.Errorf("repos.%s.command: %w", name, err))
+			}
+		}
+
+		if static {
+			continue
+		}
+
+		// A pattern's working dirs may use {owner} and {name}, and only
+		// exist once a matching repo is enabled.
+		dirs := repo.workingDirs()
+		if isRepoPattern(name) {
+			dirs = nil
+		}
+		for _, dir := range dirs {
+			if info, err := os.Stat(dir); err != nil {
+				errs = append(errs, fmt.Errorf("repos.%s.working_dir: %w", name, err))
+			} else if !info.IsDir() {
+				errs = append(errs, fmt.Errorf(
+					"repos.%s.working_dir: %s is not a directory", name, dir))
+			}
+		}
+
+		if repo.SecretPath != "" {
+			if _, err := readSecret(repo.SecretPath); err != nil {
+				errs = append(errs, fmt.Errorf("repos.%s.secret_path: %w", name, err))
+			}
+		}
+		if err := checkSecretsDir(repo); err != nil {
+			errs = append(errs, fmt.Errorf("repos.%s: %w", name, err))
+		}
+		for _, env := range sortedKeys(repo.Secrets) {
+			if _, err := readSecret(repo.Secrets[env]); err != nil {
+				errs = append(errs, fmt.Errorf("repos.%s.secrets.%s: %w", name, env, err))
+			}
+		}
+		for i, target := range repo.ForwardTo {
+			if _, err := readSecret(target.SecretPath); err != nil {
+				errs = append(errs, fmt.Errorf("repos.%s.forward_to[%d].secret_path: %w", name, i, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkCommand checks that a command exists and is executable. With static,
+// only /nix/store paths are checked.
+func checkCommand(command string, static bool) error {
+	if !filepath.IsAbs(command) {
+		if static {
+			return nil
+		}
+		_, err := exec.LookPath(command)
+		return err
+	}
+	// At build time only store paths exist; others (e.g. /run/wrappers/bin)
+	// are runtime paths.
+	if static && !strings.HasPrefix(command, "/nix/store/") {
+		return nil
+	}
+
+	info, err := os.Stat(command)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() || info.Mode()&0o111 == 0 {
+		return fmt.Errorf("%s is not executable", command)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file and returns its path.
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+// TestLoadConfigRejectsUnknownFields catches typos like "quietms".
+func TestLoadConfigRejectsUnknownFields(t *testing.T) {
+	path := writeConfig(t, `{
+		"port": "8080",
+		"repos": {
+			"owner/repo": {
+				"secret_path": "/tmp/secret",
+				"branches": ["main"],
+				"command": ["true"],
+				"working_dir": "/tmp",
+				"quietms": 1000,
+				"timeout_ms": 60000
+			}
+		}
+	}`)
+
+	_, err := loadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), `unknown field "quietms"`) {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
+
+// TestLoadConfigValidatesFields reports every invalid field at once.
+func TestLoadConfigValidatesFields(t *testing.T) {
+	path := writeConfig(t, `{
+		"port": "http",
+		"dashboard_addr": "localhost",
+		"state_path": "state.json",
+		"allowed_cidrs": ["192.30.252.0/33"],
+		"repos": {
+			"owner/repo": {
+				"secret_path": "/tmp/secret",
+				"branches": [],
+				"working_dir": "/tmp",
+				"quiet_ms": -1,
+				"timeout_ms": 0,
+				"poll_interval_ms": 1000,
+				"schedule": [{"name": "gc", "cron": "@never"}],
+				"triggers": [{"name": "gc", "event": "deployment"}],
+				"environment": "production",
+				"artifacts": {"max_runs": -1},
+				"freeze": [{"cron": "0 17 * * 5"}, {"cron": "0 25 * * *", "duration_ms": 1000}]
+			}
+		}
+	}`)
+
+	_, err := loadConfig(path)
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+	for _, want := range []string{
+		`port: invalid port "http"`,
+		`dashboard_addr: invalid address "localhost"`,
+		`state_path: must be an absolute path, got "state.json"`,
+		`allowed_cidrs: invalid CIDR "192.30.252.0/33"`,
+		"repos.owner/repo.branches: at least one branch is required",
+		"repos.owner/repo.command: required",
+		"repos.owner/repo.quiet_ms: must be >= 0",
+		"repos.owner/repo.timeout_ms: must be > 0",
+		"repos.owner/repo.remote_url: required when poll_interval_ms is set",
+		"repos.owner/repo.schedule[0]",
+		`repos.owner/repo.triggers[0]: duplicate job name "gc"`,
+		`repos.owner/repo.triggers[0].event: must be "release" or "workflow_run", got "deployment"`,
+		"repos.owner/repo: environment requires the github config",
+		"repos.owner/repo.artifacts.max_runs: must be >= 0",
+		"repos.owner/repo: artifacts requires data_dir",
+		"repos.owner/repo.freeze[0].duration_ms: must be > 0, got 0",
+		"repos.owner/repo.freeze[1].cron: ",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q:\n%v", want, err)
+		}
+	}
+}
+
+// TestLoadConfigValidatesPatterns only allows secret-less repos and repo
+// patterns with github_app.
+func TestLoadConfigValidatesPatterns(t *testing.T) {
+	repos := `"repos": {
+		"owner/*": {"branches": ["master"], "command": ["true"], "working_dir": "/srv/{name}", "timeout_ms": 1000},
+		"owner/[": {"secret_path": "/tmp/secret", "branches": ["master"], "command": ["true"], "working_dir": "/tmp", "timeout_ms": 1000}
+	}`
+
+	_, err := loadConfig(writeConfig(t, `{"port": "8673", `+repos+`}`))
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+	for _, want := range []string{
+		"repos.owner/*.secret_path: required without github_app",
+		"repos.owner/*: patterns require github_app",
+		"repos.owner/[: invalid pattern",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q:\n%v", want, err)
+		}
+	}
+
+	_, err = loadConfig(writeConfig(t, `{"port": "8673", "github_app": {}, `+repos+`}`))
+	if err == nil || !strings.Contains(err.Error(), "github_app.secret_path: required") {
+		t.Fatalf("expected github_app error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "owner/*") {
+		t.Errorf("unexpected pattern error with github_app:\n%v", err)
+	}
+}
+
+// TestCheckConfigFiles checks runtime paths unless --static is set.
+func TestCheckConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretPath, []byte("s"), 0o600); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+	script := filepath.Join(dir, "deploy.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+
+	path := writeConfig(t, `{
+		"port": "8673",
+		"repos": {
+			"owner/repo": {
+				"secret_path": "`+secretPath+`",
+				"branches": ["master"],
+				"command": ["`+script+`"],
+				"working_dir": "`+filepath.Join(dir, "missing")+`",
+				"timeout_ms": 1000,
+				"secrets": {"TOKEN": "`+filepath.Join(dir, "missing-token")+`"}
+			}
+		}
+	}`)
+
+	var out bytes.Buffer
+	err := runCheckConfig(&out, []string{"--config", path})
+	if err == nil {
+		t.Fatalf("expected missing paths to fail")
+	}
+	for _, want := range []string{"working_dir", "secrets.TOKEN"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q: %v", want, err)
+		}
+	}
+
+	out.Reset()
+	if err := runCheckConfig(&out, []string{"--config", path, "--static"}); err != nil {
+		t.Fatalf("static check-config: %v", err)
+	}
+	if !strings.Contains(out.String(), "ok (1 repos)") {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+
+	// A non-executable command fails, but static mode only checks store
+	// paths: others (e.g. /run/wrappers/bin) only exist at runtime.
+	if err := os.Chmod(script, 0o644); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if err := runCheckConfig(&out, []string{"--config", path}); err == nil ||
+		!strings.Contains(err.Error(), "is not executable") {
+		t.Fatalf("expected non-executable command to fail, got %v", err)
+	}
+	if err := runCheckConfig(&out, []string{"--config", path, "--static"}); err != nil {
+		t.Fatalf("static check-config with a runtime command path: %v", err)
+	}
+	if err := checkCommand("/nix/store/missing-github-webhook/bin/deploy", true); err == nil {
+		t.Fatalf("expected missing store path to fail in static mode")
+	}
+}
+
+// TestLoadConfigRejectsTrailingData rejects anything after the config object.
+func TestLoadConfigRejectsTrailingData(t *testing.T) {
+	for _, trailing := range []string{"}", "]", "{}"} {
+		path := writeConfig(t, `{"port": "8673", "repos": {}}`+trailing)
+		_, err := loadConfig(path)
+		if err == nil || !strings.Contains(err.Error(), "trailing data") {
+			t.Errorf("trailing %q: expected trailing data error, got %v", trailing, err)
+		}
+	}
+}
+
+// TestLoadConfigValidatesSteps rejects malformed pipelines.
+func TestLoadConfigValidatesSteps(t *testing.T) {
+	path := writeConfig(t, `{
+		"port": "8673",
+		"repos": {
+			"owner/repo": {
+				"secret_path": "/tmp/secret",
+				"branches": ["master"],
+				"command": ["true"],
+				"working_dir": "/tmp",
+				"timeout_ms": 1000,
+				"steps": [
+					{"name": "build", "command": ["true"], "timeout_ms": -1},
+					{"name": "build", "command": []},
+					{"name": "", "command": ["true"], "if": {"events": ["pull_request"], "paths": ["["]}}
+				]
+			}
+		}
+	}`)
+
+	_, err := loadConfig(path)
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+	for _, want := range []string{
+		"repos.owner/repo.command: cannot be combined with steps",
+		"repos.owner/repo.steps[0].timeout_ms: must be >= 0",
+		`repos.owner/repo.steps[1]: duplicate name "build"`,
+		"repos.owner/repo.steps[1].command: required",
+		"repos.owner/repo.steps[2].name: required",
+		`repos.owner/repo.steps[2].if.events: unknown event "pull_request"`,
+		`repos.owner/repo.steps[2].if.paths: invalid pattern "["`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q:\n%v", want, err)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	repoStateIdle       = "idle"
+	repoStateDebouncing = "debouncing"
+	repoStateRunning    = "running"
+	repoStatePaused     = "paused"
+	repoStateFrozen     = "frozen"
+)
+
+// repoStatus is a repo's row on the dashboard.
+type repoStatus struct {
+	Name        string
+	Branches    []string
+	State       string
+	LastTrigger *triggerStatus // nil: never triggered
+	LastRun     *runRecord     // nil: never ran
+	Runs        []runRecord    // newest first
+	Frozen      *freezeStatus  // nil: not frozen
+	Held        int            // triggers held by the freeze
+}
+
+// triggerStatus describes the most recent trigger of a repo.
+type triggerStatus struct {
+	Event  string
+	Job    string
+	Branch string
+	Commit string
+	Sender string
+	At     time.Time
+}
+
+// status snapshots the handler for the dashboard.
+func (h *repoHandler) status() repoStatus {
+	st := repoStatus{
+		Name:     h.fullName,
+		Branches: h.repo.branchNames(),
+		Runs:     h.history.snapshot(),
+	}
+
+	// The most recent trigger across the repo and branch debouncers.
+	var last triggerContext
+	for _, deb := range h.debouncers() {
+		if l, _ := deb.Status(); l.enqueuedAt.After(last.enqueuedAt) {
+			last = l
+		}
+	}
+	if !last.enqueuedAt.IsZero() {
+		st.LastTrigger = &triggerStatus{
+			Event:  last.event,
+			Job:    last.job,
+			Branch: last.branch,
+			Commit: last.commit,
+			Sender: last.sender,
+			At:     last.enqueuedAt,
+		}
+	}
+
+	st.State = h.runState()
+	if f, ok := h.frozen(time.Now()); ok {
+		st.Frozen = &f
+	}
+	st.Held = h.heldCount()
+
+	for i := range st.Runs {
+		if st.Runs[i].Status != runStatusRunning {
+			st.LastRun = &st.Runs[i]
+			break
+		}
+	}
+	return st
+}
+
+// runState returns whether the repo is idle, debouncing, running, paused
+// (a run waits for its working dir, a run slot or a retry backoff) or frozen.
+func (h *repoHandler) runState() string {
+	var pending int
+	for _, deb := range h.debouncers() {
+		_, n := deb.Status()
+		pending += n
+	}
+	switch {
+	case h.pausedCount() > 0:
+		return repoStatePaused
+	case h.history.running():
+		return repoStateRunning
+	case pending > 0:
+		return repoStateDebouncing
+	case h.heldCount() > 0:
+		return repoStateFrozen
+	}
+	if _, ok := h.frozen(time.Now()); ok {
+		return repoStateFrozen
+	}
+	return repoStateIdle
+}
+
+// pause marks a run of the repo as paused until the returned func is called.
+func (h *repoHandler) pause() func() {
+	h.mu.Lock()
+	h.paused++
+	h.mu.Unlock()
+	return func() {
+		h.mu.Lock()
+		h.paused--
+		h.mu.Unlock()
+	}
+}
+
+// pausedCount returns the number of paused runs.
+func (h *repoHandler) pausedCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.paused
+}
+
+// handleDashboard serves the read-only HTML status page: each repo's state
+// (idle, debouncing, running, paused, frozen) and recent runs. A run is
+// paused while it waits for its working dir, a global run slot or a retry
+// backoff.
+func (a *app) handleDashboard(w http.ResponseWriter, _ *http.Request) {
+	var repos []repoStatus
+	for _, h := range a.handlerList() {
+		repos = append(repos, h.status())
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTmpl.ExecuteTemplate(w, "dashboard", map[string]any{
+		"Now":   time.Now(),
+		"Repos": repos,
+	})
+	if err != nil {
+		slog.Warn("render dashboard", "err", err)
+	}
+}
+
+// handleRunLog serves one run's details and output.
+func (a *app) handleRunLog(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	for _, h := range a.handlerList() {
+		rec, ok := h.history.get(id)
+		if !ok {
+			continue
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := dashboardTmpl.ExecuteTemplate(w, "run", map[string]any{
+			"Repo": h.fullName,
+			"Run":  rec,
+		})
+		if err != nil {
+			slog.Warn("render run log", "err", err)
+		}
+		return
+	}
+	http.Error(w, "run not found (only recent runs are kept)", http.StatusNotFound)
+}
+
+// dashboardTmpl renders the dashboard, run and artifacts pages. Everything is inline so
+// the page works offline, e.g. over an SSH tunnel.
+var dashboardTmpl = template.Must(template.New("").Funcs(template.FuncMap{
+	"short": func(sha string) string {
+		if len(sha) > 12 {
+			return sha[:12]
+		}
+		return sha
+	},
+	"ts": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05 MST")
+	},
+	"join": strings.Join,
+}).Parse(`
+{{define "head"}}<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<style>
+body { font: 14px/1.4 system-ui, sans-serif; margin: 1.5em; color: #222; }
+h1 { font-size: 1.3em; } h2 { font-size: 1.1em; margin-top: 2em; }
+table { border-collapse: collapse; margin-top: .5em; }
+th, td { text-align: left; padding: .2em .8em .2em 0; border-bottom: 1px solid #ddd; }
+code, pre { font-family: ui-monospace, monospace; }
+pre { background: #f6f6f6; padding: 1em; overflow-x: auto; }
+.muted { color: #777; }
+.state, .status { padding: 0 .4em; border-radius: 3px; }
+.idle, .success { background: #dfd; }
+.debouncing, .running, .paused { background: #ffd; }
+.frozen { background: #ddf; }
+.failure { background: #fdd; }
+.skipped { background: #eee; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "dashboard"}}{{template "head" "github-webhook"}}
+<h1>github-webhook</h1>
+<p class="muted">as of {{ts .Now}}</p>
+{{range .Repos}}
+<h2>{{.Name}} <span class="state {{.State}}">{{.State}}</span></h2>
+<p>
+branches: <code>{{join .Branches ", "}}</code><br>
+{{if .Frozen}}frozen: {{if .Frozen.Lock}}locked{{else}}freeze window{{end}}
+until {{ts .Frozen.Until}}{{if .Frozen.Reason}} ({{.Frozen.Reason}}){{end}}, {{.Held}} held<br>
+{{else if .Held}}frozen: {{.Held}} held, releasing<br>
+{{end}}last trigger:
+{{with .LastTrigger}}{{.Event}}{{if .Job}} ({{.Job}}){{end}}
+{{if .Branch}}on <code>{{.Branch}}</code>{{end}}
+{{if .Commit}}at <code>{{short .Commit}}</code>{{end}}
+{{if .Sender}}by {{.Sender}}{{end}}, {{ts .At}}
+{{else}}<span class="muted">none</span>{{end}}<br>
+last run:
+{{with .LastRun}}<span class="status {{.Status}}">{{.Status}}</span>
+{{if .Commit}}at <code>{{short .Commit}}</code>{{end}}, {{ts .End}}
+{{with .Result}}{{with .Summary}}&mdash; {{.}}{{end}}{{end}}
+{{else}}<span class="muted">none</span>{{end}}
+</p>
+{{if .Runs}}
+<table>
+<tr><th>run</th><th>started</th><th>trigger</th><th>branch</th><th>commit</th><th>status</th><th>attempts</th><th>duration</th></tr>
+{{range .Runs}}
+<tr>
+<td><a href="runs/{{.ID}}"><code>{{.ID}}</code></a></td>
+<td>{{ts .Start}}</td>
+<td>{{.Event}}{{if .Job}} ({{.Job}}){{end}}</td>
+<td><code>{{.Branch}}</code></td>
+<td><code>{{short .Commit}}</code></td>
+<td><span class="status {{.Status}}">{{.Status}}</span></td>
+<td>{{.Attempts}}</td>
+<td>{{.Duration}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+{{else}}
+<p class="muted">no repos configured</p>
+{{end}}
+</body>
+</html>
+{{end}}
+
+{{define "run"}}{{template "head" (printf "run %s" .Run.ID)}}
+<p><a href="../">&larr; dashboard</a></p>
+{{with .Run}}
+<h1>{{$.Repo}} run <code>{{.ID}}</code> <span class="status {{.Status}}">{{.Status}}</span></h1>
+<table>
+<tr><th>trigger</th><td>{{.Event}}{{if .Job}} ({{.Job}}){{end}}</td></tr>
+{{if .DeliveryID}}<tr><th>delivery</th><td><code>{{.DeliveryID}}</code></td></tr>{{end}}
+<tr><th>branch</th><td><code>{{.Branch}}</code></td></tr>
+<tr><th>commit</th><td><code>{{.Commit}}</code></td></tr>
+{{if .Sender}}<tr><th>sender</th><td>{{.Sender}}</td></tr>{{end}}
+<tr><th>started</th><td>{{ts .Start}}</td></tr>
+<tr><th>duration</th><td>{{.Duration}}</td></tr>
+<tr><th>attempts</th><td>{{.Attempts}}</td></tr>
+{{if .Err}}<tr><th>error</th><td><code>{{.Err}}</code> (exit code {{.ExitCode}})</td></tr>{{end}}
+{{with .Result}}
+{{if .Summary}}<tr><th>summary</th><td>{{.Summary}}</td></tr>{{end}}
+{{range .URLs}}<tr><th>{{.Name}}</th><td><a href="{{.URL}}">{{.URL}}</a></td></tr>{{end}}
+{{end}}
+</table>
+{{with .Result}}{{if .Outputs}}
+<h2>outputs</h2>
+<table>
+{{range $k, $v := .Outputs}}<tr><th><code>{{$k}}</code></th><td><code>{{$v}}</code></td></tr>
+{{end}}
+</table>
+{{end}}{{end}}
+{{if .Steps}}
+<h2>steps</h2>
+<table>
+<tr><th>attempt</th><th>step</th><th>status</th><th>exit code</th><th>duration</th></tr>
+{{range .Steps}}
+<tr>
+<td>{{.Attempt}}</td>
+<td>{{.Name}}</td>
+<td><span class="status {{.Status}}">{{.Status}}</span></td>
+<td>{{if eq .Status "success" "failure"}}{{.ExitCode}}{{end}}</td>
+<td>{{if ne .Status "skipped"}}{{.Duration}}{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+{{if .Artifacts}}
+<h2>artifacts</h2>
+<table>
+<tr><th>file</th><th>size</th></tr>
+{{range .Artifacts}}
+<tr><td><a href="{{$.Run.ID}}/artifacts/{{.Path}}"><code>{{.Path}}</code></a></td><td>{{.Size}}</td></tr>
+{{end}}
+</table>
+{{end}}
+<h2>output</h2>
+{{if .Dropped}}<p class="muted">{{.Dropped}} earlier lines dropped</p>{{end}}
+<pre>{{range .Output}}{{.}}
+{{end}}</pre>
+{{end}}
+</body>
+</html>
+{{end}}
+
+{{define "artifacts"}}{{template "head" (printf "run %s artifacts" .RunID)}}
+<p><a href="../../{{.RunID}}">&larr; run</a></p>
+<h1>{{.Repo}} run <code>{{.RunID}}</code> artifacts</h1>
+{{if .Files}}
+<table>
+<tr><th>file</th><th>size</th></tr>
+{{range .Files}}
+<tr><td><a href="{{.Path}}"><code>{{.Path}}</code></a></td><td>{{.Size}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p class="muted">no artifacts</p>
+{{end}}
+</body>
+</html>
+{{end}}
+`))
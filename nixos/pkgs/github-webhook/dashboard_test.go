@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDashboard lists repos with their state and recent runs, and links to
+// each run's escaped output.
+func TestDashboard(t *testing.T) {
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Branches:   []Branch{{Name: "master"}},
+			Command:    []string{"sh", "-c", "echo '<b>deployed</b>'"},
+			WorkingDir: t.TempDir(),
+		},
+		timeout: 5 * time.Second,
+		history: newRunHistory(),
+	}
+	handler.deb = newDebouncer(time.Hour, func(triggerContext) error { return nil })
+
+	tctx := triggerContext{event: "push", branch: "master", commit: "0123456789abcdef"}
+	if err := handler.runCommand(t.Context(), tctx); err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+	// A pending trigger that has not run yet.
+	handler.deb.enqueue(tctx)
+
+	a := &app{handlers: map[string]*repoHandler{"test/repo": handler}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", a.handleDashboard)
+	mux.HandleFunc("GET /runs/{id}", a.handleRunLog)
+
+	get := func(path string) (int, string) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr.Code, rr.Body.String()
+	}
+
+	code, body := get("/")
+	if code != http.StatusOK {
+		t.Fatalf("dashboard: expected 200, got %d", code)
+	}
+	runs := handler.history.snapshot()
+	if len(runs) != 1 {
+		t.Fatalf("expected 1 recorded run, got %d", len(runs))
+	}
+	for _, want := range []string{
+		"test/repo",
+		`<span class="state debouncing">debouncing</span>`,
+		"<code>master</code>",
+		"<code>0123456789ab</code>",
+		`<a href="runs/` + runs[0].ID + `">`,
+		`<span class="status success">success</span>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("dashboard missing %q:\n%s", want, body)
+		}
+	}
+
+	code, body = get("/runs/" + runs[0].ID)
+	if code != http.StatusOK {
+		t.Fatalf("run log: expected 200, got %d", code)
+	}
+	if !strings.Contains(body, "&lt;b&gt;deployed&lt;/b&gt;") {
+		t.Errorf("run log missing escaped output:\n%s", body)
+	}
+
+	if code, _ := get("/runs/missing"); code != http.StatusNotFound {
+		t.Errorf("unknown run: expected 404, got %d", code)
+	}
+}
+
+// TestRunStatePaused reports a run waiting for its working dir as paused.
+func TestRunStatePaused(t *testing.T) {
+	work := t.TempDir()
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Command:    []string{"true"},
+			WorkingDir: work,
+		},
+		timeout: 5 * time.Second,
+		history: newRunHistory(),
+	}
+
+	// Another branch's run holds the working dir.
+	unlock := handler.lockDir(work)
+	done := make(chan error, 1)
+	go func() { done <- handler.runCommand(t.Context(), triggerContext{}) }()
+
+	for deadline := time.Now().Add(5 * time.Second); handler.runState() != repoStatePaused; {
+		if time.Now().After(deadline) {
+			t.Fatalf("state = %q, want %q", handler.runState(), repoStatePaused)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+	if got := handler.runState(); got != repoStateIdle {
+		t.Errorf("state after run = %q, want %q", got, repoStateIdle)
+	}
+}
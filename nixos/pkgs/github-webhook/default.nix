@@ -11,9 +11,61 @@ buildGoModule {
   src = lib.fileset.toSource {
     root = ./.;
     fileset = lib.fileset.unions [
+      ./access.go
+      ./access_test.go
+      ./artifacts.go
+      ./artifacts_test.go
+      ./branches.go
+      ./branches_test.go
+      ./cli.go
+      ./cli_test.go
+      ./concurrency.go
+      ./concurrency_test.go
+      ./config.go
+      ./config_test.go
+      ./dashboard.go
+      ./dashboard_test.go
+      ./deployments.go
+      ./deployments_test.go
+      ./forward.go
+      ./forward_test.go
+      ./freeze.go
+      ./freeze_test.go
+      ./github.go
       ./go.mod
+      ./history.go
+      ./installations.go
+      ./installations_test.go
+      ./logging.go
+      ./logging_test.go
       ./main.go
       ./main_test.go
+      ./metrics.go
+      ./notify.go
+      ./notify_test.go
+      ./payload.go
+      ./payload_test.go
+      ./poll.go
+      ./poll_test.go
+      ./result.go
+      ./result_test.go
+      ./retry.go
+      ./retry_test.go
+      ./sandbox.go
+      ./sandbox_test.go
+      ./schedule.go
+      ./schedule_test.go
+      ./secrets.go
+      ./secrets_test.go
+      ./state.go
+      ./state_test.go
+      ./steps.go
+      ./steps_test.go
+      ./tracing.go
+      ./tracing_test.go
+      ./triggers.go
+      ./triggers_test.go
+      ./webhook
     ];
   };
   vendorHash = null;
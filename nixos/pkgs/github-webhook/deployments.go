@@ -0,0 +1,202 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// deploymentsInactivateScan is how many recent deployments of an
+	// environment are checked when marking older ones inactive.
+	deploymentsInactivateScan = 10
+)
+
+// deployment is the GitHub deployment created for one run.
+type deployment struct {
+	id          int64
+	environment string
+	logURL      string
+
+	// description and environmentURL come from the run's result, if any.
+	description    string
+	environmentURL string
+}
+
+// environmentFor returns the deployment environment of job, or "".
+func (h *repoHandler) environmentFor(job string) string {
+	if job == "" {
+		return h.repo.Environment
+	}
+	for _, s := range h.repo.Schedule {
+		if s.Name == job {
+			return s.Environment
+		}
+	}
+	if et := h.eventTrigger(job); et != nil {
+		return et.Environment
+	}
+	return ""
+}
+
+// logURL returns the public dashboard URL of a run, or "".
+func (h *repoHandler) logURL(runID string) string {
+	if h.publicURL == "" {
+		return ""
+	}
+	return h.publicURL + "/runs/" + runID
+}
+
+// startDeployment creates a GitHub deployment for the run's commit and marks
+// it in_progress. It returns nil if the job has no environment or the API
+// call fails; deployment errors are logged and never fail the run.
+func (h *repoHandler) startDeployment(
+	ctx context.Context,
+	tctx triggerContext,
+	logger *slog.Logger,
+) *deployment {
+	env := h.environmentFor(tctx.job)
+	if env == "" || h.github == nil {
+		return nil
+	}
+
+	// Releases deploy their tag; scheduled runs have no commit, so they
+	// deploy the tracked branch head.
+	ref := cmp.Or(tctx.commit, tctx.releaseTag, tctx.branch)
+	if ref == "" && len(h.repo.Branches) > 0 {
+		ref = h.repo.Branches[0].Name
+	}
+
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	err := h.github.api(ctx, h.fullName, http.MethodPost, "/deployments", map[string]any{
+		"ref":               ref,
+		"environment":       env,
+		"auto_merge":        false,
+		"required_contexts": []string{},
+		"description":       "github-webhook " + cmp.Or(tctx.job, tctx.event) + " run",
+		"payload": map[string]string{
+			"run_id": tctx.runID,
+			"event":  tctx.event,
+			"job":    tctx.job,
+		},
+	}, &created)
+	if err != nil {
+		logger.Warn("create deployment failed", "environment", env, "err", err)
+		return nil
+	}
+
+	dep := &deployment{
+		id:          created.ID,
+		environment: env,
+		logURL:      h.logURL(tctx.runID),
+	}
+	logger.Info("created deployment", "environment", env, "deployment_id", dep.id)
+	h.postDeploymentStatus(ctx, dep, dep.id, "in_progress", logger)
+	return dep
+}
+
+// finishDeployment posts the run's final deployment status, described by
+// the run's result if it reported one. After a successful deploy, older
+// deployments of the environment are marked inactive.
+func (h *repoHandler) finishDeployment(
+	ctx context.Context,
+	dep *deployment,
+	runErr error,
+	res *runResult,
+	logger *slog.Logger,
+) {
+	if dep == nil {
+		return
+	}
+	dep.description = res.description()
+	dep.environmentURL = res.environmentURL()
+
+	// Report the outcome even when the run was cancelled by shutdown.
+	ctx = context.WithoutCancel(ctx)
+
+	if runErr != nil {
+		h.postDeploymentStatus(ctx, dep, dep.id, "failure", logger)
+		return
+	}
+	h.postDeploymentStatus(ctx, dep, dep.id, "success", logger)
+	h.inactivateOlderDeployments(ctx, dep, logger)
+}
+
+// postDeploymentStatus posts state for deployment id.
+func (h *repoHandler) postDeploymentStatus(
+	ctx context.Context,
+	dep *deployment,
+	id int64,
+	state string,
+	logger *slog.Logger,
+) {
+	status := map[string]any{
+		"state":       state,
+		"environment": dep.environment,
+		// Older deployments are inactivated explicitly, since GitHub only
+		// does it automatically for non-production environments.
+		"auto_inactive": false,
+	}
+	if id == dep.id {
+		if dep.logURL != "" {
+			status["log_url"] = dep.logURL
+		}
+		if dep.description != "" {
+			status["description"] = dep.description
+		}
+		if dep.environmentURL != "" {
+			status["environment_url"] = dep.environmentURL
+		}
+	}
+
+	path := "/deployments/" + strconv.FormatInt(id, 10) + "/statuses"
+	if err := h.github.api(ctx, h.fullName, http.MethodPost, path, status, nil); err != nil {
+		logger.Warn("post deployment status failed",
+			"deployment_id", id, "state", state, "err", err)
+	}
+}
+
+// inactivateOlderDeployments marks recent deployments of dep's environment
+// whose latest status is success as inactive.
+func (h *repoHandler) inactivateOlderDeployments(
+	ctx context.Context,
+	dep *deployment,
+	logger *slog.Logger,
+) {
+	var deps []struct {
+		ID int64 `json:"id"`
+	}
+	query := url.Values{
+		"environment": {dep.environment},
+		"per_page":    {strconv.Itoa(deploymentsInactivateScan)},
+	}
+	if err := h.github.api(ctx, h.fullName, http.MethodGet,
+		"/deployments?"+query.Encode(), nil, &deps); err != nil {
+		logger.Warn("list deployments failed", "environment", dep.environment, "err", err)
+		return
+	}
+
+	for _, d := range deps {
+		if d.ID == dep.id {
+			continue
+		}
+
+		var statuses []struct {
+			State string `json:"state"`
+		}
+		path := "/deployments/" + strconv.FormatInt(d.ID, 10) + "/statuses?per_page=1"
+		if err := h.github.api(ctx, h.fullName, http.MethodGet, path, nil, &statuses); err != nil {
+			logger.Warn("get deployment status failed", "deployment_id", d.ID, "err", err)
+			continue
+		}
+		if len(statuses) == 0 || statuses[0].State != "success" {
+			continue
+		}
+		h.postDeploymentStatus(ctx, dep, d.ID, "inactive", logger)
+	}
+}
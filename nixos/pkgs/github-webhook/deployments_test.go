@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGitHub is a local fake of the deployments API.
+type fakeGitHub struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	requests []string         // "METHOD path state"
+	created  []map[string]any // deployment create bodies
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+		f.t.Errorf("unexpected Authorization %q", got)
+	}
+
+	var body map[string]any
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			f.t.Errorf("decode body: %v", err)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, _ := body["state"].(string)
+	f.requests = append(f.requests, strings.TrimSpace(r.Method+" "+r.URL.Path+" "+state))
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/test/repo/deployments":
+		f.created = append(f.created, body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id": 2}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/test/repo/deployments":
+		if env := r.URL.Query().Get("environment"); env != "production" {
+			f.t.Errorf("listed deployments of environment %q", env)
+		}
+		_, _ = w.Write([]byte(`[{"id": 2}, {"id": 1}, {"id": 0}]`))
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/test/repo/deployments/1/statuses":
+		_, _ = w.Write([]byte(`[{"state": "success"}]`))
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/test/repo/deployments/0/statuses":
+		_, _ = w.Write([]byte(`[{"state": "inactive"}]`))
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/statuses"):
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// TestRunCommandDeployment reports a run with an environment as a GitHub
+// deployment and inactivates the previously active one.
+func TestRunCommandDeployment(t *testing.T) {
+	fake := &fakeGitHub{t: t}
+	api := httptest.NewServer(fake)
+	defer api.Close()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("test-token\n"), 0o600); err != nil {
+		t.Fatalf("write token: %v", err)
+	}
+
+	work := t.TempDir()
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Command:     []string{"sh", "-c", "env > env.txt"},
+			WorkingDir:  work,
+			Environment: "production",
+		},
+		timeout:   5 * time.Second,
+		github:    newGitHubClient(&GitHubConfig{APIURL: api.URL, TokenPath: tokenPath}),
+		publicURL: "https://ci.example.com",
+	}
+
+	tctx := triggerContext{event: "push", branch: "master", commit: "abc123", runID: "run1"}
+	if err := handler.runCommand(t.Context(), tctx); err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	want := []string{
+		"POST /repos/test/repo/deployments",
+		"POST /repos/test/repo/deployments/2/statuses in_progress",
+		"POST /repos/test/repo/deployments/2/statuses success",
+		"GET /repos/test/repo/deployments",
+		"GET /repos/test/repo/deployments/1/statuses",
+		"POST /repos/test/repo/deployments/1/statuses inactive",
+		"GET /repos/test/repo/deployments/0/statuses",
+	}
+	if got := strings.Join(fake.requests, "\n"); got != strings.Join(want, "\n") {
+		t.Fatalf("unexpected API calls:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+	if ref := fake.created[0]["ref"]; ref != "abc123" {
+		t.Errorf("deployment ref = %v, want commit", ref)
+	}
+
+	env := readEnvFile(t, filepath.Join(work, "env.txt"))
+	for _, kv := range []string{"GH_ENVIRONMENT=production", "GH_DEPLOYMENT_ID=2"} {
+		if !strings.Contains(strings.Join(env, "\n"), kv) {
+			t.Errorf("command env missing %s", kv)
+		}
+	}
+}
+
+// TestGitHubClientTokenSocket fetches per-repo tokens from a
+// github-agent-authd socket.
+func TestGitHubClientTokenSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gh-webhook")
+	if err != nil {
+		t.Fatalf("mkdtemp: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "socket")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/test/repo/token" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"token": "socket-token"}`))
+	})}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	c := newGitHubClient(&GitHubConfig{TokenSocket: socket})
+	token, err := c.token(t.Context(), "test/repo")
+	if err != nil {
+		t.Fatalf("token: %v", err)
+	}
+	if token != "socket-token" {
+		t.Fatalf("expected socket token, got %q", token)
+	}
+
+	if _, err := c.token(t.Context(), "other/repo"); err == nil {
+		t.Fatalf("expected error for repo without token")
+	}
+}
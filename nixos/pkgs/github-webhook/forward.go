@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+const (
+	// forwardQueueSize bounds deliveries waiting to be forwarded per target.
+	forwardQueueSize = 100
+	// forwardDedupTTL is how long a forwarded delivery id is remembered.
+	forwardDedupTTL = time.Hour
+	// forwardTimeout bounds one forwarding request.
+	forwardTimeout = 30 * time.Second
+)
+
+// forwardRetry is the retry policy for forwarding requests.
+var forwardRetry = RetryConfig{
+	MaxAttempts:      6,
+	InitialBackoffMs: 1000,
+	MaxBackoffMs:     60000,
+}
+
+// ForwardTarget is another webhook listener that verified deliveries are
+// relayed to.
+type ForwardTarget struct {
+	// URL is the target's webhook endpoint, e.g.
+	// "http://10.0.0.2:8673/webhooks/github".
+	URL string `json:"url"`
+	// SecretPath is the target's webhook secret (supports "%d/"). Forwarded
+	// deliveries are re-signed with it.
+	SecretPath string `json:"secret_path"`
+}
+
+// validate checks a forward target. Errors are prefixed with the field name.
+func (ft *ForwardTarget) validate() []error {
+	var errs []error
+	u, err := url.Parse(ft.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("url: must be an http(s) URL, got %q", ft.URL))
+	}
+	if ft.SecretPath == "" {
+		errs = append(errs, errors.New("secret_path: required"))
+	}
+	return errs
+}
+
+// forwarder relays deliveries to one target through a bounded queue,
+// retrying failures with backoff. Each delivery id is forwarded at most once
+// per forwardDedupTTL, which also breaks forwarding loops between hosts.
+type forwarder struct {
+	repo   string
+	url    string
+	secret []byte
+	client *http.Client
+	queue  chan forwardJob
+	policy RetryConfig
+
+	mu sync.Mutex
+	// seen maps delivery id -> when it was queued.
+	seen map[string]time.Time
+}
+
+// forwardJob is one delivery waiting to be forwarded.
+type forwardJob struct {
+	deliveryID string
+	header     http.Header
+	body       []byte
+}
+
+// newForwarder reads the target's secret and returns its forwarder.
+func newForwarder(repo string, target *ForwardTarget) (*forwarder, error) {
+	secret, err := readSecret(target.SecretPath)
+	if err != nil {
+		return nil, fmt.Errorf("read forward secret for %s: %w", target.URL, err)
+	}
+	return &forwarder{
+		repo:   repo,
+		url:    target.URL,
+		secret: secret,
+		client: &http.Client{Timeout: forwardTimeout},
+		queue:  make(chan forwardJob, forwardQueueSize),
+		policy: forwardRetry,
+		seen:   make(map[string]time.Time),
+	}, nil
+}
+
+// forwardHeaders lists the original request headers copied to forwarded
+// requests. X-Hub-Signature-256 is recomputed; the legacy SHA-1 signature is
+// dropped.
+var forwardHeaders = []string{
+	"Content-Type",
+	"User-Agent",
+	"X-GitHub-Delivery",
+	"X-GitHub-Event",
+	"X-GitHub-Hook-ID",
+	"X-GitHub-Hook-Installation-Target-ID",
+	"X-GitHub-Hook-Installation-Target-Type",
+}
+
+// enqueue queues a verified delivery for forwarding. It reports false if the
+// delivery was already forwarded or the queue is full.
+func (f *forwarder) enqueue(header http.Header, body []byte) bool {
+	job := forwardJob{
+		deliveryID: header.Get("X-GitHub-Delivery"),
+		header:     make(http.Header),
+		body:       body,
+	}
+	for _, name := range forwardHeaders {
+		if v := header.Values(name); len(v) > 0 {
+			job.header[name] = v
+		}
+	}
+	job.header.Set("X-Hub-Signature-256", webhook.Sign(f.secret, body))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if job.deliveryID != "" {
+		now := time.Now()
+		for id, at := range f.seen {
+			if now.Sub(at) > forwardDedupTTL {
+				delete(f.seen, id)
+			}
+		}
+		if _, dup := f.seen[job.deliveryID]; dup {
+			return false
+		}
+		f.seen[job.deliveryID] = now
+	}
+
+	select {
+	case f.queue <- job:
+		return true
+	default:
+		// Allow a later redelivery to be forwarded.
+		delete(f.seen, job.deliveryID)
+		return false
+	}
+}
+
+// run forwards queued deliveries in order until ctx is done.
+func (f *forwarder) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-f.queue:
+			f.forward(ctx, job)
+		}
+	}
+}
+
+// forward sends job, retrying with backoff. Failures after the last attempt
+// are logged and the delivery dropped.
+func (f *forwarder) forward(ctx context.Context, job forwardJob) {
+	logger := slog.Default().With(
+		"repo", f.repo,
+		"delivery_id", job.deliveryID,
+		"target", f.url)
+
+	for attempt := 1; ; attempt++ {
+		err := f.send(ctx, job)
+		if err == nil {
+			logger.Info("forwarded delivery", "attempt", attempt)
+			return
+		}
+		if ctx.Err() != nil {
+			// Shutting down.
+			return
+		}
+		var rejected *forwardRejectedError
+		if errors.As(err, &rejected) {
+			logger.Warn("forward target rejected delivery", "status", rejected.status)
+			return
+		}
+		if attempt >= f.policy.MaxAttempts {
+			logger.Error("forward failed, dropping delivery", "attempts", attempt, "err", err)
+			return
+		}
+
+		backoff := f.policy.backoff(attempt)
+		logger.Warn("forward failed, retrying",
+			"attempt", attempt,
+			"backoff_ms", backoff.Milliseconds(),
+			"err", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// forwardRejectedError is a 4xx response other than 429: the target's
+// verdict on the delivery (e.g. untracked branch), not worth retrying.
+type forwardRejectedError struct {
+	status int
+}
+
+func (e *forwardRejectedError) Error() string {
+	return fmt.Sprintf("target rejected delivery: %d", e.status)
+}
+
+// send POSTs job to the target once.
+func (f *forwarder) send(ctx context.Context, job forwardJob) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url, bytes.NewReader(job.body))
+	if err != nil {
+		return err
+	}
+	req.Header = job.header.Clone()
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests:
+		return &forwardRejectedError{resp.StatusCode}
+	default:
+		return fmt.Errorf("target returned %s", resp.Status)
+	}
+}
+
+// forward queues a verified delivery to all of the repo's forward targets.
+func (h *repoHandler) forward(header http.Header, body []byte, logger *slog.Logger) {
+	for _, f := range h.forwarders {
+		if !f.enqueue(header, body) {
+			logger.Info("not forwarding delivery (duplicate or queue full)",
+				"target", f.url)
+		}
+	}
+}
+
+// forwardTargets returns the repo's forward target URLs.
+func (h *repoHandler) forwardTargets() []string {
+	urls := make([]string, len(h.forwarders))
+	for i, f := range h.forwarders {
+		urls[i] = f.url
+	}
+	return urls
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+// forwardTarget is a fake downstream listener that checks signatures against
+// its own secret.
+type forwardTarget struct {
+	t      *testing.T
+	secret []byte
+	// fail is the number of initial requests answered with 503.
+	fail int
+
+	mu       sync.Mutex
+	received []http.Header
+	got      chan struct{}
+}
+
+func newForwardTarget(t *testing.T, fail int) *forwardTarget {
+	return &forwardTarget{
+		t:      t,
+		secret: []byte("downstream-secret"),
+		fail:   fail,
+		got:    make(chan struct{}, 10),
+	}
+}
+
+func (ft *forwardTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if webhook.HMAC(ft.secret).Verify(body, r.Header.Get("X-Hub-Signature-256")) != nil {
+		ft.t.Errorf("forwarded delivery not re-signed with the target secret")
+	}
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.fail > 0 {
+		ft.fail--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	ft.received = append(ft.received, r.Header.Clone())
+	w.WriteHeader(http.StatusAccepted)
+	ft.got <- struct{}{}
+}
+
+// newTestForwarder returns a forwarder to target's server with fast retries.
+func newTestForwarder(t *testing.T, target *forwardTarget, url string) *forwarder {
+	t.Helper()
+	secretPath := filepath.Join(t.TempDir(), "forward-secret")
+	if err := os.WriteFile(secretPath, target.secret, 0o600); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+	f, err := newForwarder("test/repo", &ForwardTarget{URL: url, SecretPath: secretPath})
+	if err != nil {
+		t.Fatalf("newForwarder: %v", err)
+	}
+	f.policy = RetryConfig{MaxAttempts: 3, InitialBackoffMs: 1, MaxBackoffMs: 1}
+	go f.run(t.Context())
+	return f
+}
+
+// TestForwardVerifiedDeliveries relays verified deliveries, including ones
+// for branches this host does not track, once per delivery id.
+func TestForwardVerifiedDeliveries(t *testing.T) {
+	target := newForwardTarget(t, 0)
+	srv := httptest.NewServer(target)
+	defer srv.Close()
+
+	secret := []byte("supersecret")
+	handler := &repoHandler{
+		fullName:   "test/repo",
+		repo:       Repo{Branches: []Branch{{Name: "master"}}},
+		secret:     secret,
+		forwarders: []*forwarder{newTestForwarder(t, target, srv.URL)},
+	}
+	a := &app{handlers: map[string]*repoHandler{"test/repo": handler}}
+
+	send := func(sig string) int {
+		body := []byte(`{"ref":"refs/heads/feature","repository":{"full_name":"test/repo"}}`)
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-GitHub-Delivery", "delivery-1")
+		req.Header.Set("X-Hub-Signature-256", cmp.Or(sig, webhook.Sign(secret, body)))
+		rr := httptest.NewRecorder()
+		a.handleWebhook(rr, req)
+		return rr.Code
+	}
+
+	if code := send("sha256=bad"); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for bad signature, got %d", code)
+	}
+	if code := send(""); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for untracked branch, got %d", code)
+	}
+	// A redelivery of the same delivery id is not forwarded again.
+	send("")
+
+	select {
+	case <-target.got:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("delivery was not forwarded")
+	}
+	select {
+	case <-target.got:
+		t.Fatalf("duplicate delivery was forwarded")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	h := target.received[0]
+	if h.Get("X-GitHub-Event") != "push" || h.Get("X-GitHub-Delivery") != "delivery-1" {
+		t.Errorf("original headers not forwarded: %v", h)
+	}
+}
+
+// TestForwardRetries retries failed forwards with backoff.
+func TestForwardRetries(t *testing.T) {
+	target := newForwardTarget(t, 2)
+	srv := httptest.NewServer(target)
+	defer srv.Close()
+
+	f := newTestForwarder(t, target, srv.URL)
+	header := http.Header{"X-Github-Delivery": {"delivery-2"}}
+	if !f.enqueue(header, []byte(`{}`)) {
+		t.Fatalf("enqueue failed")
+	}
+
+	select {
+	case <-target.got:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("delivery was not forwarded after retries")
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// freezeCheckInterval is how often held runs are checked for release once a
+// freeze window ends or an admin lock expires.
+const freezeCheckInterval = 15 * time.Second
+
+// FreezeWindow is a recurring period during which a repo's runs are held.
+type FreezeWindow struct {
+	// Cron is when each window starts: a 5-field cron expression or
+	// @hourly/@daily/@weekly/@monthly/@yearly.
+	Cron string `json:"cron"`
+	// Timezone is an IANA zone name; defaults to UTC.
+	Timezone string `json:"timezone"`
+	// DurationMs is how long each window lasts.
+	DurationMs int `json:"duration_ms"`
+	// Reason is reported to senders and on the dashboard.
+	Reason string `json:"reason"`
+}
+
+// deployLock is an admin-set freeze, persisted in the state file.
+type deployLock struct {
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+	SetAt  time.Time `json:"set_at"`
+}
+
+// freezeStatus describes an active freeze.
+type freezeStatus struct {
+	Reason string
+	Until  time.Time
+	// Lock is true for an admin lock, false for a freeze window.
+	Lock bool
+}
+
+// String formats the freeze for webhook responses and logs.
+func (f freezeStatus) String() string {
+	kind := "freeze window"
+	if f.Lock {
+		kind = "locked"
+	}
+	s := fmt.Sprintf("deploys frozen (%s) until %s", kind, f.Until.Format(time.RFC3339))
+	if f.Reason != "" {
+		s += ": " + f.Reason
+	}
+	return s
+}
+
+// parseFreezeWindow validates a freeze window and parses its cron expression.
+func parseFreezeWindow(w *FreezeWindow) (*cronSpec, error) {
+	if w.DurationMs <= 0 {
+		return nil, fmt.Errorf("duration_ms: must be > 0, got %d", w.DurationMs)
+	}
+	spec, err := parseCron(w.Cron, w.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("cron: %w", err)
+	}
+	return spec, nil
+}
+
+// activeUntil returns when the window that contains now ends, or false if now
+// is outside every window. Overlapping windows extend each other.
+func (w *FreezeWindow) activeUntil(spec *cronSpec, now time.Time) (time.Time, bool) {
+	duration := time.Duration(w.DurationMs) * time.Millisecond
+	var until time.Time
+	for start := spec.next(now.Add(-duration)); !start.IsZero() && !start.After(now); start = spec.next(start) {
+		until = start.Add(duration)
+	}
+	return until, until.After(now)
+}
+
+// frozen returns the active freeze of the repo at now: the admin lock, or
+// else the configured window that lasts longest.
+func (h *repoHandler) frozen(now time.Time) (freezeStatus, bool) {
+	if lock, ok := h.state.lock(h.fullName); ok && lock.Until.After(now) {
+		return freezeStatus{Reason: lock.Reason, Until: lock.Until, Lock: true}, true
+	}
+
+	var f freezeStatus
+	for i, w := range h.repo.Freeze {
+		if until, ok := w.activeUntil(h.freezeSpecs[i], now); ok && until.After(f.Until) {
+			f = freezeStatus{Reason: w.Reason, Until: until}
+		}
+	}
+	return f, !f.Until.IsZero()
+}
+
+// heldTrigger is the persisted form of a held triggerContext, so a restart
+// during a freeze still runs it once the freeze lifts.
+type heldTrigger struct {
+	Event         string          `json:"event"`
+	Ref           string          `json:"ref,omitempty"`
+	Commit        string          `json:"commit,omitempty"`
+	Sender        string          `json:"sender,omitempty"`
+	DeliveryID    string          `json:"delivery_id,omitempty"`
+	ReleaseTag    string          `json:"release_tag,omitempty"`
+	WorkflowRunID int64           `json:"workflow_run_id,omitempty"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+}
+
+// heldKey returns the key of the trigger held for job on branch.
+func heldKey(branch, job string) string {
+	return branch + "\x00" + job
+}
+
+// hold parks tctx until the freeze lifts. Only the newest trigger per branch
+// and job is kept, so a burst of pushes during a freeze runs once, with the
+// newest commit. Held triggers are persisted next to the admin lock.
+func (h *repoHandler) hold(tctx triggerContext) {
+	h.mu.Lock()
+	if h.held == nil {
+		h.held = make(map[string]triggerContext)
+	}
+	h.held[heldKey(tctx.branch, tctx.job)] = tctx
+	h.mu.Unlock()
+	h.persistHeld()
+
+	// The freeze may have lifted since the caller checked, after releaseHeld
+	// last ran; release right away instead of at the next check.
+	if _, ok := h.frozen(time.Now()); !ok {
+		h.signalUnlocked()
+	}
+}
+
+// persistHeld writes the held triggers to the state file. Writes are
+// serialized and each saves the triggers held when it starts, so the file
+// ends up matching h.held.
+func (h *repoHandler) persistHeld() {
+	h.heldSaveMu.Lock()
+	defer h.heldSaveMu.Unlock()
+
+	h.mu.Lock()
+	held := make(map[string]map[string]heldTrigger)
+	for _, tctx := range h.held {
+		if held[tctx.job] == nil {
+			held[tctx.job] = make(map[string]heldTrigger)
+		}
+		held[tctx.job][tctx.branch] = heldTrigger{
+			Event:         tctx.event,
+			Ref:           tctx.ref,
+			Commit:        tctx.commit,
+			Sender:        tctx.sender,
+			DeliveryID:    tctx.deliveryID,
+			ReleaseTag:    tctx.releaseTag,
+			WorkflowRunID: tctx.workflowRunID,
+			Payload:       tctx.payload,
+		}
+	}
+	h.mu.Unlock()
+
+	if err := h.state.setHeld(h.fullName, held); err != nil {
+		h.logger().Warn("persist held runs failed", "err", err)
+	}
+}
+
+// signalUnlocked wakes freezeLoop to release held triggers now.
+func (h *repoHandler) signalUnlocked() {
+	select {
+	case h.unlocked <- struct{}{}:
+	default:
+	}
+}
+
+// restoreHeld loads the triggers held before a restart.
+func (h *repoHandler) restoreHeld() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for job, branches := range h.state.held(h.fullName) {
+		for branch, ht := range branches {
+			if h.held == nil {
+				h.held = make(map[string]triggerContext)
+			}
+			h.held[heldKey(branch, job)] = triggerContext{
+				event:         ht.Event,
+				ref:           ht.Ref,
+				branch:        branch,
+				commit:        ht.Commit,
+				sender:        ht.Sender,
+				job:           job,
+				deliveryID:    ht.DeliveryID,
+				releaseTag:    ht.ReleaseTag,
+				workflowRunID: ht.WorkflowRunID,
+				payload:       ht.Payload,
+			}
+		}
+	}
+}
+
+// heldCount returns the number of held triggers.
+func (h *repoHandler) heldCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.held)
+}
+
+// releaseHeld re-queues the held triggers if the repo is no longer frozen.
+func (h *repoHandler) releaseHeld() {
+	if _, ok := h.frozen(time.Now()); ok {
+		return
+	}
+
+	h.mu.Lock()
+	held := h.held
+	h.held = nil
+	h.mu.Unlock()
+	if len(held) == 0 {
+		return
+	}
+	h.persistHeld()
+
+	h.logger().Info("freeze lifted, releasing held runs", "runs", len(held))
+	for _, key := range sortedKeys(held) {
+		h.enqueue(held[key])
+	}
+}
+
+// freezeLoop releases held triggers once the freeze lifts, checking at
+// start (for triggers held before a restart), every freezeCheckInterval and
+// whenever the admin lock is cleared, until ctx is done.
+func (h *repoHandler) freezeLoop(ctx context.Context) {
+	ticker := time.NewTicker(freezeCheckInterval)
+	defer ticker.Stop()
+	h.releaseHeld()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-h.unlocked:
+		}
+		h.releaseHeld()
+	}
+}
+
+// lockRequest is the body of PUT /admin/locks/{owner}/{repo}. The expiry is
+// given by exactly one of duration_ms and until.
+type lockRequest struct {
+	Reason     string    `json:"reason"`
+	DurationMs int64     `json:"duration_ms"`
+	Until      time.Time `json:"until"`
+}
+
+// handleLock sets (PUT) or clears (DELETE) a repo's admin lock. Requests
+// must carry the admin token as a bearer token.
+func (a *app) handleLock(w http.ResponseWriter, r *http.Request) {
+	if len(a.adminToken) == 0 {
+		http.Error(w, "admin API disabled", http.StatusNotFound)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), a.adminToken) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name := r.PathValue("owner") + "/" + r.PathValue("repo")
+	h := a.handler(name)
+	if h == nil {
+		http.Error(w, "repository not configured", http.StatusNotFound)
+		return
+	}
+	logger := h.logger().With("remote_ip", a.access.clientIP(r).String())
+
+	if r.Method == http.MethodDelete {
+		if err := a.state.setLock(name, nil); err != nil {
+			logger.Error("clear deploy lock", "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("deploy lock cleared")
+		h.signalUnlocked()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var req lockRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	lock, err := req.lock(now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := a.state.setLock(name, &lock); err != nil {
+		logger.Error("set deploy lock", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("deploy lock set",
+		"reason", lock.Reason,
+		"until", lock.Until.Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(lock)
+}
+
+// lock validates the request and returns the lock it sets at now.
+func (req *lockRequest) lock(now time.Time) (deployLock, error) {
+	if strings.TrimSpace(req.Reason) == "" {
+		return deployLock{}, errors.New("reason is required")
+	}
+	until := req.Until
+	switch {
+	case req.DurationMs != 0 && !until.IsZero():
+		return deployLock{}, errors.New("duration_ms and until are exclusive")
+	case req.DurationMs < 0:
+		return deployLock{}, fmt.Errorf("duration_ms: must be > 0, got %d", req.DurationMs)
+	case req.DurationMs > 0:
+		until = now.Add(time.Duration(req.DurationMs) * time.Millisecond)
+	case until.IsZero():
+		return deployLock{}, errors.New("duration_ms or until is required")
+	}
+	if !until.After(now) {
+		return deployLock{}, errors.New("until must be in the future")
+	}
+	return deployLock{Reason: req.Reason, Until: until, SetAt: now}, nil
+}
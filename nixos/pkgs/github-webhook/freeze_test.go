@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+func TestFreezeWindowActiveUntil(t *testing.T) {
+	w := &FreezeWindow{Cron: "0 17 * * 5", DurationMs: int(64 * time.Hour / time.Millisecond)}
+	spec, err := parseFreezeWindow(w)
+	if err != nil {
+		t.Fatalf("parseFreezeWindow: %v", err)
+	}
+
+	// Friday 2026-10-16 17:00 UTC until Monday 09:00.
+	monday := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		now    time.Time
+		frozen bool
+	}{
+		{time.Date(2026, 10, 16, 16, 59, 0, 0, time.UTC), false},
+		{time.Date(2026, 10, 16, 17, 0, 0, 0, time.UTC), true},
+		{time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC), true},
+		{monday.Add(-time.Second), true},
+		{monday, false},
+	} {
+		until, ok := w.activeUntil(spec, tc.now)
+		if ok != tc.frozen {
+			t.Errorf("%s: frozen = %v, want %v", tc.now, ok, tc.frozen)
+		}
+		if ok && !until.Equal(monday) {
+			t.Errorf("%s: until = %s, want %s", tc.now, until, monday)
+		}
+	}
+}
+
+// TestDeployLockHoldsNewestPush accepts pushes while locked, and runs only
+// the newest one once the lock is cleared.
+func TestDeployLockHoldsNewestPush(t *testing.T) {
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretPath, []byte("supersecret"), 0o600); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+	tokenPath := filepath.Join(dir, "admin-token")
+	if err := os.WriteFile(tokenPath, []byte("admintoken\n"), 0o600); err != nil {
+		t.Fatalf("write token: %v", err)
+	}
+	outPath := filepath.Join(dir, "runs")
+
+	cfg, err := loadConfig(writeConfig(t, `{
+		"port": "8080",
+		"state_path": "`+filepath.Join(dir, "state.json")+`",
+		"admin_token_path": "`+tokenPath+`",
+		"repos": {
+			"test/repo": {
+				"secret_path": "`+secretPath+`",
+				"branches": ["master"],
+				"command": ["sh", "-c", "echo $GH_COMMIT >> `+outPath+`"],
+				"working_dir": "`+dir+`",
+				"quiet_ms": 10,
+				"timeout_ms": 5000
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	a, err := newApp(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("newApp: %v", err)
+	}
+	a.start(t.Context())
+
+	admin := func(method, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/locks/test/repo", strings.NewReader(body))
+		req.SetPathValue("owner", "test")
+		req.SetPathValue("repo", "repo")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		a.handleLock(rr, req)
+		return rr
+	}
+	push := func(commit string) *httptest.ResponseRecorder {
+		body := []byte(`{"ref":"refs/heads/master","after":"` + commit + `",` +
+			`"repository":{"full_name":"test/repo"}}`)
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", webhook.Sign([]byte("supersecret"), body))
+		rr := httptest.NewRecorder()
+		a.handleWebhook(rr, req)
+		return rr
+	}
+	runs := func() string {
+		data, _ := os.ReadFile(outPath)
+		return strings.TrimSpace(string(data))
+	}
+
+	if rr := admin(http.MethodPut, "wrong", `{"reason": "demo", "duration_ms": 60000}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("PUT with wrong token: got %d", rr.Code)
+	}
+	if rr := admin(http.MethodPut, "admintoken", `{"reason": "demo"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("PUT without expiry: got %d", rr.Code)
+	}
+	if rr := admin(http.MethodPut, "admintoken", `{"reason": "demo", "duration_ms": 60000}`); rr.Code != http.StatusOK {
+		t.Fatalf("PUT lock: got %d: %s", rr.Code, rr.Body)
+	}
+
+	handler := a.handlers["test/repo"]
+	commitA, commitB := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	for _, commit := range []string{commitA, commitB} {
+		rr := push(commit)
+		if rr.Code != http.StatusAccepted || !strings.Contains(rr.Body.String(), "frozen (locked)") {
+			t.Fatalf("push %s: got %d %q, want 202 frozen", commit, rr.Code, rr.Body)
+		}
+		// Let the debouncer hand each push to runCommand separately.
+		held := func() string {
+			handler.mu.Lock()
+			defer handler.mu.Unlock()
+			return handler.held["master\x00"].commit
+		}
+		for deadline := time.Now().Add(5 * time.Second); held() != commit; {
+			if time.Now().After(deadline) {
+				t.Fatalf("push %s was not held", commit)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if n := handler.heldCount(); n != 1 {
+		t.Errorf("held = %d, want 1", n)
+	}
+	if got := handler.runState(); got != repoStateFrozen {
+		t.Errorf("state = %q, want %q", got, repoStateFrozen)
+	}
+	if got := runs(); got != "" {
+		t.Fatalf("runs while frozen = %q, want none", got)
+	}
+
+	// A restart during the freeze still holds the newest push.
+	restarted, err := newApp(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("newApp after restart: %v", err)
+	}
+	if tctx := restarted.handler("test/repo").held["master\x00"]; tctx.commit != commitB || len(tctx.payload) == 0 {
+		t.Errorf("held after restart = %+v, want commit %s with its payload", tctx, commitB)
+	}
+
+	if rr := admin(http.MethodDelete, "admintoken", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("DELETE lock: got %d", rr.Code)
+	}
+	for deadline := time.Now().Add(5 * time.Second); runs() == ""; {
+		if time.Now().After(deadline) {
+			t.Fatalf("held run did not run after unlock")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := runs(); got != commitB {
+		t.Errorf("runs = %q, want only the newest commit", got)
+	}
+	if held := a.state.held("test/repo"); len(held) != 0 {
+		t.Errorf("state still holds %v after release", held)
+	}
+}
+
+// TestHoldAfterFreezeLifted releases a trigger parked just after the freeze
+// lifted right away, rather than at the next freeze check.
+func TestHoldAfterFreezeLifted(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	state, err := openStateStore(statePath)
+	if err != nil {
+		t.Fatalf("open state: %v", err)
+	}
+	handler := &repoHandler{
+		fullName: "test/repo",
+		state:    state,
+		unlocked: make(chan struct{}, 1),
+	}
+	triggers := make(chan triggerContext, 1)
+	handler.deb = newDebouncer(time.Millisecond, func(tctx triggerContext) error {
+		triggers <- tctx
+		return nil
+	})
+	go handler.deb.Run(t.Context())
+	go handler.freezeLoop(t.Context())
+
+	commit := strings.Repeat("c", 40)
+	handler.hold(triggerContext{event: "push", branch: "master", commit: commit})
+	select {
+	case tctx := <-triggers:
+		if tctx.commit != commit {
+			t.Fatalf("released %+v, want commit %s", tctx, commit)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("held trigger was not released after the freeze lifted")
+	}
+
+	reopened, err := openStateStore(statePath)
+	if err != nil {
+		t.Fatalf("reopen state: %v", err)
+	}
+	if held := reopened.held("test/repo"); len(held) != 0 {
+		t.Errorf("state still holds %v after release", held)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultGitHubAPIURL is the public GitHub REST API.
+	defaultGitHubAPIURL = "https://api.github.com"
+	// githubRequestTimeout bounds one GitHub API (or token broker) request.
+	githubRequestTimeout = 15 * time.Second
+	// githubErrorBodyLimit caps error bodies included in errors.
+	githubErrorBodyLimit = 4096
+)
+
+// GitHubConfig configures GitHub API access, used for deployments.
+type GitHubConfig struct {
+	// APIURL is the REST API base URL (default "https://api.github.com").
+	APIURL string `json:"api_url"`
+
+	// TokenPath is a file holding an API token (supports "%d/"). It is
+	// re-read for every request so rotated tokens are picked up.
+	TokenPath string `json:"token_path"`
+
+	// TokenSocket is a github-agent-authd Unix socket serving per-repo
+	// installation tokens at GET /repos/{owner}/{repo}/token.
+	TokenSocket string `json:"token_socket"`
+}
+
+// validate checks the GitHub config. Errors are prefixed with the field
+// name.
+func (gc *GitHubConfig) validate() error {
+	var errs []error
+	if gc.APIURL != "" {
+		u, err := url.Parse(gc.APIURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("api_url: must be an http(s) URL, got %q", gc.APIURL))
+		}
+	}
+	if (gc.TokenPath == "") == (gc.TokenSocket == "") {
+		errs = append(errs, errors.New("exactly one of token_path or token_socket is required"))
+	}
+	return errors.Join(errs...)
+}
+
+// githubClient is a minimal GitHub REST client.
+type githubClient struct {
+	apiURL      string
+	tokenPath   string
+	tokenSocket string
+	client      *http.Client
+	// socketClient talks HTTP over tokenSocket.
+	socketClient *http.Client
+}
+
+// newGitHubClient returns a client for cfg, or nil if cfg is nil.
+func newGitHubClient(cfg *GitHubConfig) *githubClient {
+	if cfg == nil {
+		return nil
+	}
+
+	c := &githubClient{
+		apiURL:      strings.TrimSuffix(cfg.APIURL, "/"),
+		tokenPath:   cfg.TokenPath,
+		tokenSocket: cfg.TokenSocket,
+		client:      &http.Client{Timeout: githubRequestTimeout},
+	}
+	if c.apiURL == "" {
+		c.apiURL = defaultGitHubAPIURL
+	}
+	if c.tokenSocket != "" {
+		c.socketClient = &http.Client{
+			Timeout: githubRequestTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", c.tokenSocket)
+				},
+			},
+		}
+	}
+	return c
+}
+
+// token returns an API token valid for repo.
+func (c *githubClient) token(ctx context.Context, repo string) (string, error) {
+	if c.tokenPath != "" {
+		token, err := readSecret(c.tokenPath)
+		if err != nil {
+			return "", fmt.Errorf("read token: %w", err)
+		}
+		return string(token), nil
+	}
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := c.do(ctx, c.socketClient, "", http.MethodGet,
+		"http://github-agent-authd/repos/"+repo+"/token", nil, &resp); err != nil {
+		return "", fmt.Errorf("token from %s: %w", c.tokenSocket, err)
+	}
+	if resp.Token == "" {
+		return "", fmt.Errorf("token from %s: empty token", c.tokenSocket)
+	}
+	return resp.Token, nil
+}
+
+// api calls the GitHub REST API for repo. path is relative to
+// /repos/{repo}. out may be nil.
+func (c *githubClient) api(
+	ctx context.Context,
+	repo, method, path string,
+	in, out any,
+) error {
+	token, err := c.token(ctx, repo)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, c.client, token, method, c.apiURL+"/repos/"+repo+path, in, out)
+}
+
+// do sends a JSON request and decodes a JSON response into out.
+func (c *githubClient) do(
+	ctx context.Context,
+	client *http.Client,
+	token, method, target string,
+	in, out any,
+) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, githubErrorBodyLimit))
+		return fmt.Errorf("%s %s: %s: %s", method, req.URL.Path, resp.Status,
+			strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
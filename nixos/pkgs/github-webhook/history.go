@@ -0,0 +1,255 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// runHistoryLimit is how many recent runs are kept per repo.
+	runHistoryLimit = 20
+	// runOutputLimit is how many output lines are kept per run; older lines
+	// are dropped first.
+	runOutputLimit = 2000
+
+	runStatusRunning = "running"
+	runStatusSuccess = "success"
+	runStatusFailure = "failure"
+	runStatusSkipped = "skipped"
+)
+
+// runRecord is one run as shown on the dashboard.
+type runRecord struct {
+	ID         string
+	Event      string
+	Job        string
+	Branch     string
+	Commit     string
+	Sender     string
+	DeliveryID string
+	Start      time.Time
+	End        time.Time // zero while running
+	Status     string
+	ExitCode   int
+	Attempts   int
+	Err        string
+
+	// Steps records the pipeline steps of every attempt, in order. Empty for
+	// runs of a plain command.
+	Steps []stepRecord
+
+	// Artifacts lists the files the run left in its artifacts dir. They may
+	// have been pruned since.
+	Artifacts []artifactFile
+
+	// Result is what the command wrote to GH_RESULT_PATH, if anything.
+	Result *runResult
+
+	// Output holds the last runOutputLimit redacted output lines.
+	Output []string
+	// Dropped counts output lines dropped from the front of Output.
+	Dropped int
+}
+
+// Duration returns the run's duration so far.
+func (r runRecord) Duration() time.Duration {
+	if r.End.IsZero() {
+		return time.Since(r.Start).Round(time.Second)
+	}
+	return r.End.Sub(r.Start).Round(time.Millisecond)
+}
+
+// stepRecord is one pipeline step of a run attempt.
+type stepRecord struct {
+	Attempt  int
+	Name     string
+	Status   string // running, success, failure or skipped
+	ExitCode int
+	Start    time.Time
+	End      time.Time // zero while running
+}
+
+// Duration returns the step's duration so far.
+func (s stepRecord) Duration() time.Duration {
+	if s.End.IsZero() {
+		return time.Since(s.Start).Round(time.Second)
+	}
+	return s.End.Sub(s.Start).Round(time.Millisecond)
+}
+
+// runHistory keeps a repo's recent runs in memory. A nil runHistory is valid
+// and records nothing.
+type runHistory struct {
+	mu   sync.Mutex
+	runs []*runRecord // oldest first
+}
+
+// newRunHistory returns an empty history.
+func newRunHistory() *runHistory {
+	return &runHistory{}
+}
+
+// begin records the start of a run for tctx.
+func (hist *runHistory) begin(tctx triggerContext) *runRecord {
+	if hist == nil {
+		return nil
+	}
+
+	rec := &runRecord{
+		ID:         tctx.runID,
+		Event:      tctx.event,
+		Job:        tctx.job,
+		Branch:     tctx.branch,
+		Commit:     tctx.commit,
+		Sender:     tctx.sender,
+		DeliveryID: tctx.deliveryID,
+		Start:      time.Now(),
+		Status:     runStatusRunning,
+	}
+
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+	hist.runs = append(hist.runs, rec)
+	if len(hist.runs) > runHistoryLimit {
+		hist.runs = slices.Delete(hist.runs, 0, len(hist.runs)-runHistoryLimit)
+	}
+	return rec
+}
+
+// appendOutput adds a line to rec's output.
+func (hist *runHistory) appendOutput(rec *runRecord, line string) {
+	if hist == nil || rec == nil {
+		return
+	}
+
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+	rec.Output = append(rec.Output, line)
+	if len(rec.Output) > runOutputLimit {
+		rec.Output = slices.Delete(rec.Output, 0, len(rec.Output)-runOutputLimit)
+		rec.Dropped++
+	}
+}
+
+// addStep appends a step to rec and returns its index, or -1.
+func (hist *runHistory) addStep(rec *runRecord, step stepRecord) int {
+	if hist == nil || rec == nil {
+		return -1
+	}
+
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+	rec.Steps = append(rec.Steps, step)
+	return len(rec.Steps) - 1
+}
+
+// finishStep records the outcome of rec's step i.
+func (hist *runHistory) finishStep(rec *runRecord, i int, err error) {
+	if hist == nil || rec == nil || i < 0 {
+		return
+	}
+
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+	step := &rec.Steps[i]
+	step.End = time.Now()
+	step.ExitCode = exitCode(err)
+	step.Status = runStatusSuccess
+	if err != nil {
+		step.Status = runStatusFailure
+	}
+}
+
+// setArtifacts records the artifacts rec's run left.
+func (hist *runHistory) setArtifacts(rec *runRecord, files []artifactFile) {
+	if hist == nil || rec == nil {
+		return
+	}
+
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+	rec.Artifacts = files
+}
+
+// setResult records the result rec's command reported.
+func (hist *runHistory) setResult(rec *runRecord, res *runResult) {
+	if hist == nil || rec == nil {
+		return
+	}
+
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+	rec.Result = res
+}
+
+// finish records the outcome of rec after attempts attempts.
+func (hist *runHistory) finish(rec *runRecord, attempts int, err error) {
+	if hist == nil || rec == nil {
+		return
+	}
+
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+	rec.End = time.Now()
+	rec.Attempts = attempts
+	rec.ExitCode = exitCode(err)
+	rec.Status = runStatusSuccess
+	if err != nil {
+		rec.Status = runStatusFailure
+		rec.Err = err.Error()
+	}
+}
+
+// snapshot returns copies of the recorded runs, newest first, without their
+// output.
+func (hist *runHistory) snapshot() []runRecord {
+	if hist == nil {
+		return nil
+	}
+
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+	runs := make([]runRecord, 0, len(hist.runs))
+	for _, rec := range slices.Backward(hist.runs) {
+		r := *rec
+		r.Steps = nil
+		r.Artifacts = nil
+		r.Output = nil
+		runs = append(runs, r)
+	}
+	return runs
+}
+
+// get returns a copy of the run with id, including its output.
+func (hist *runHistory) get(id string) (runRecord, bool) {
+	if hist == nil {
+		return runRecord{}, false
+	}
+
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+	for _, rec := range hist.runs {
+		if rec.ID == id {
+			r := *rec
+			r.Steps = slices.Clone(rec.Steps)
+			r.Artifacts = slices.Clone(rec.Artifacts)
+			r.Output = slices.Clone(rec.Output)
+			return r, true
+		}
+	}
+	return runRecord{}, false
+}
+
+// running reports whether any recorded run is still in progress.
+func (hist *runHistory) running() bool {
+	if hist == nil {
+		return false
+	}
+
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+	return slices.ContainsFunc(hist.runs, func(rec *runRecord) bool {
+		return rec.Status == runStatusRunning
+	})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+const (
+	eventInstallation             = "installation"
+	eventInstallationRepositories = "installation_repositories"
+)
+
+// GitHubAppConfig configures a GitHub App webhook, whose single hook delivers
+// the events of every repo the app is installed on.
+type GitHubAppConfig struct {
+	// SecretPath is the app's webhook secret (supports "%d/"). Repos without
+	// their own secret_path are verified with it.
+	SecretPath string `json:"secret_path"`
+	// AutoEnable enables repos added to an installation that match a repos
+	// pattern right away, remembers them in the state file across restarts,
+	// and disables them again when removed from the installation. Otherwise
+	// they are only logged, with what would happen to them.
+	AutoEnable bool `json:"auto_enable"`
+}
+
+// validate checks the app config. Errors are prefixed with the field name.
+func (ac *GitHubAppConfig) validate() error {
+	if ac.SecretPath == "" {
+		return errors.New("secret_path: required")
+	}
+	return nil
+}
+
+// isRepoPattern reports whether a repos key is a pattern (path.Match syntax,
+// e.g. "phlip9/*") rather than a repo name.
+func isRepoPattern(name string) bool {
+	return strings.ContainsAny(name, `*?[\`)
+}
+
+// repoTemplate returns the longest repos pattern matching repo and its
+// config with {owner} and {name} expanded, or a nil Repo if none matches.
+func (cfg *Config) repoTemplate(repo string) (string, *Repo) {
+	var pattern string
+	for _, p := range sortedKeys(cfg.Repos) {
+		if !isRepoPattern(p) || len(p) <= len(pattern) {
+			continue
+		}
+		if ok, _ := path.Match(p, repo); ok {
+			pattern = p
+		}
+	}
+	if pattern == "" {
+		return "", nil
+	}
+	return pattern, expandRepoTemplate(cfg.Repos[pattern], repo)
+}
+
+// expandRepoTemplate copies a pattern's repo config for repo, replacing
+// {owner} and {name} in working_dir, command and remote_url.
+func expandRepoTemplate(tmpl *Repo, repo string) *Repo {
+	owner, name, _ := strings.Cut(repo, "/")
+	expand := strings.NewReplacer("{owner}", owner, "{name}", name).Replace
+
+	r := *tmpl
+	r.WorkingDir = expand(r.WorkingDir)
+	r.RemoteURL = expand(r.RemoteURL)
+	r.Command = slices.Clone(r.Command)
+	for i := range r.Command {
+		r.Command[i] = expand(r.Command[i])
+	}
+	return &r
+}
+
+// installationChange is the effect of an installation event: repos added to
+// or removed from the app's installation.
+type installationChange struct {
+	action  string
+	account string
+	added   []string
+	removed []string
+}
+
+// routeInstallation returns the repos an app-level installation or
+// installation_repositories delivery, verified with the app secret, adds
+// and removes.
+func routeInstallation(dl *webhook.Delivery) *delivery {
+	names := func(repos []webhook.InstallationRepository) []string {
+		var out []string
+		for _, r := range repos {
+			out = append(out, r.FullName)
+		}
+		return out
+	}
+
+	change := &installationChange{}
+	switch p := dl.Parsed.(type) {
+	case *webhook.InstallationEvent:
+		change.action = p.Action
+		change.account = p.Installation.Account.Login
+		switch p.Action {
+		case "created":
+			change.added = names(p.Repositories)
+		case "deleted":
+			change.removed = names(p.Repositories)
+		}
+	case *webhook.InstallationRepositoriesEvent:
+		change.action = p.Action
+		change.account = p.Installation.Account.Login
+		change.added = names(p.RepositoriesAdded)
+		change.removed = names(p.RepositoriesRemoved)
+	}
+	return &delivery{event: dl.Event, installation: change}
+}
+
+// installationPlan describes what applying an installation change does to
+// one repo, for logs and `simulate`. apply is true if the repo is enabled
+// (added) or disabled (removed).
+func (a *app) installationPlan(repo string, added bool) (plan string, apply bool) {
+	h := a.handler(repo)
+	switch {
+	case !added && h != nil && h.autoEnabled:
+		return "disable (auto-enabled)", true
+	case !added && h != nil:
+		return "keep (configured)", false
+	case !added:
+		return "ignore (not enabled)", false
+	case h != nil:
+		return "already enabled", false
+	}
+	pattern, tmpl := a.cfg.repoTemplate(repo)
+	switch {
+	case tmpl == nil:
+		return "ignore (matches no repos pattern)", false
+	case a.cfg.GitHubApp.AutoEnable:
+		return "enable (matches " + pattern + ")", true
+	default:
+		return "log only (matches " + pattern + ", auto_enable is off)", false
+	}
+}
+
+// applyInstallation enables added repos that match a repos pattern (with
+// auto_enable) and disables removed auto-enabled repos. It returns how many
+// repos it enabled or disabled.
+func (a *app) applyInstallation(change *installationChange, logger *slog.Logger) int {
+	logger = logger.With("action", change.action, "account", change.account)
+	logger.Info("installation changed",
+		"added", len(change.added), "removed", len(change.removed))
+
+	changed := 0
+	for _, repo := range change.added {
+		plan, apply := a.installationPlan(repo, true)
+		logger.Info("repo added to installation", "added_repo", repo, "plan", plan)
+		if !apply {
+			continue
+		}
+		if err := a.enableRepo(repo); err != nil {
+			logger.Error("enable repo failed", "added_repo", repo, "err", err)
+			continue
+		}
+		changed++
+	}
+	for _, repo := range change.removed {
+		plan, apply := a.installationPlan(repo, false)
+		logger.Info("repo removed from installation", "removed_repo", repo, "plan", plan)
+		if !apply {
+			continue
+		}
+		if err := a.disableRepo(repo); err != nil {
+			logger.Error("disable repo failed", "removed_repo", repo, "err", err)
+			continue
+		}
+		changed++
+	}
+	return changed
+}
+
+// enableRepo creates and starts a handler for repo from its repos pattern,
+// and remembers it in the state file so it is enabled after a restart.
+func (a *app) enableRepo(repo string) error {
+	pattern, tmpl := a.cfg.repoTemplate(repo)
+	if tmpl == nil {
+		return errors.New("matches no repos pattern")
+	}
+	h, err := a.newHandler(repo, tmpl)
+	if err != nil {
+		return err
+	}
+	h.autoEnabled = true
+	ctx, cancel := context.WithCancel(a.ctx)
+	h.stop = cancel
+
+	a.mu.Lock()
+	if a.handlers[repo] != nil {
+		a.mu.Unlock()
+		cancel()
+		return nil
+	}
+	// Started before it is published, so deliveries and health checks never
+	// see its loops not running yet. start only spawns goroutines.
+	h.start(ctx)
+	a.handlers[repo] = h
+	a.mu.Unlock()
+
+	if err := a.state.setAutoEnabled(repo, true); err != nil {
+		h.logger().Warn("remember auto-enabled repo failed", "err", err)
+	}
+	h.logger().Info("repo enabled", "pattern", pattern)
+	return nil
+}
+
+// disableRepo stops routing deliveries to an auto-enabled repo and stops its
+// loops. A run in progress finishes.
+func (a *app) disableRepo(repo string) error {
+	a.mu.Lock()
+	h := a.handlers[repo]
+	if h == nil || !h.autoEnabled {
+		a.mu.Unlock()
+		return nil
+	}
+	delete(a.handlers, repo)
+	a.mu.Unlock()
+
+	if h.stop != nil {
+		h.stop()
+	}
+	h.logger().Info("repo disabled")
+	return a.state.setAutoEnabled(repo, false)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+func TestRepoTemplate(t *testing.T) {
+	cfg := &Config{Repos: map[string]*Repo{
+		"phlip9/*": {
+			WorkingDir: "/srv/{owner}/{name}",
+			Command:    []string{"deploy", "{name}"},
+		},
+		"phlip9/site-*":   {WorkingDir: "/srv/sites/{name}"},
+		"phlip9/dotfiles": {WorkingDir: "/home/phlip9/dev/dotfiles"},
+	}}
+
+	for _, tc := range []struct {
+		repo, pattern, workingDir string
+	}{
+		{"phlip9/notes", "phlip9/*", "/srv/phlip9/notes"},
+		{"phlip9/site-blog", "phlip9/site-*", "/srv/sites/site-blog"},
+		{"other/notes", "", ""},
+	} {
+		pattern, repo := cfg.repoTemplate(tc.repo)
+		if pattern != tc.pattern {
+			t.Errorf("%s: pattern = %q, want %q", tc.repo, pattern, tc.pattern)
+		}
+		var got string
+		if repo != nil {
+			got = repo.WorkingDir
+		}
+		if got != tc.workingDir {
+			t.Errorf("%s: working_dir = %q, want %q", tc.repo, got, tc.workingDir)
+		}
+	}
+
+	_, repo := cfg.repoTemplate("phlip9/notes")
+	if want := []string{"deploy", "notes"}; !slices.Equal(repo.Command, want) {
+		t.Errorf("command = %q, want %q", repo.Command, want)
+	}
+	if got := cfg.Repos["phlip9/*"].Command[1]; got != "{name}" {
+		t.Errorf("expanding modified the pattern's command: %q", got)
+	}
+}
+
+// TestInstallationAutoEnable enables a repo added to the app installation
+// from its pattern, keeps it across a restart, and disables it once removed.
+func TestInstallationAutoEnable(t *testing.T) {
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "app-secret")
+	if err := os.WriteFile(secretPath, []byte("appsecret"), 0o600); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+	outPath := filepath.Join(dir, "runs")
+
+	cfg, err := loadConfig(writeConfig(t, `{
+		"port": "8080",
+		"state_path": "`+filepath.Join(dir, "state.json")+`",
+		"github_app": {"secret_path": "`+secretPath+`", "auto_enable": true},
+		"repos": {
+			"test/*": {
+				"branches": ["master"],
+				"command": ["sh", "-c", "echo {name} $GH_COMMIT >> `+outPath+`"],
+				"working_dir": "`+dir+`",
+				"quiet_ms": 10,
+				"timeout_ms": 5000
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	a, err := newApp(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("newApp: %v", err)
+	}
+	a.start(t.Context())
+
+	deliver := func(event, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader([]byte(body)))
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-Hub-Signature-256", webhook.Sign([]byte("appsecret"), []byte(body)))
+		rr := httptest.NewRecorder()
+		a.handleWebhook(rr, req)
+		return rr
+	}
+	installation := func(action, key string) string {
+		return `{"action":"` + action + `","installation":{"id":1,"account":{"login":"test"}},` +
+			`"` + key + `":[{"full_name":"test/site","private":false}]}`
+	}
+	commit := strings.Repeat("a", 40)
+	push := `{"ref":"refs/heads/master","after":"` + commit + `","repository":{"full_name":"test/site"}}`
+
+	if rr := deliver("push", push); rr.Code != http.StatusNotFound {
+		t.Fatalf("push before install: got %d, want 404", rr.Code)
+	}
+	if rr := deliver(eventInstallationRepositories, installation("added", "repositories_added")); rr.Code != http.StatusAccepted {
+		t.Fatalf("repositories added: got %d: %s", rr.Code, rr.Body)
+	}
+	if h := a.handler("test/site"); h == nil || !h.autoEnabled {
+		t.Fatalf("test/site not auto-enabled: %+v", h)
+	}
+	if rr := deliver("push", push); rr.Code != http.StatusAccepted {
+		t.Fatalf("push after install: got %d: %s", rr.Code, rr.Body)
+	}
+	// Wait for the whole run, including recording its success, so nothing
+	// outlives the test's temp dir.
+	site := a.handler("test/site")
+	idle := func() bool {
+		for _, deb := range site.debouncers() {
+			if !deb.Idle() {
+				return false
+			}
+		}
+		return true
+	}
+	for deadline := time.Now().Add(5 * time.Second); !idle(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("run did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if data, _ := os.ReadFile(outPath); strings.TrimSpace(string(data)) != "site "+commit {
+		t.Fatalf("runs = %q, want %q", data, "site "+commit)
+	}
+
+	// Installation changes are only taken from the app hook, never from a
+	// repo's.
+	spoofed := strings.Replace(installation("removed", "repositories_removed"),
+		`{"action"`, `{"repository":{"full_name":"test/site"},"action"`, 1)
+	if rr := deliver(eventInstallationRepositories, spoofed); rr.Code != http.StatusBadRequest {
+		t.Fatalf("installation change with a repository: got %d, want 400", rr.Code)
+	}
+	if a.handler("test/site") == nil {
+		t.Fatalf("test/site disabled by a repo-signed installation change")
+	}
+
+	// A restart restores the repo from the state file.
+	restarted, err := newApp(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("newApp after restart: %v", err)
+	}
+	if h := restarted.handler("test/site"); h == nil || !h.autoEnabled {
+		t.Fatalf("test/site not restored after restart")
+	}
+
+	if rr := deliver(eventInstallationRepositories, installation("removed", "repositories_removed")); rr.Code != http.StatusAccepted {
+		t.Fatalf("repositories removed: got %d: %s", rr.Code, rr.Body)
+	}
+	if a.handler("test/site") != nil {
+		t.Fatalf("test/site still enabled after removal")
+	}
+	if rr := deliver("push", push); rr.Code != http.StatusNotFound {
+		t.Fatalf("push after removal: got %d, want 404", rr.Code)
+	}
+	if got := a.state.autoEnabled(); len(got) != 0 {
+		t.Errorf("state still auto-enables %v", got)
+	}
+
+	// Repos matching no pattern are only logged.
+	rr := deliver(eventInstallation, `{"action":"created","installation":{"id":1,"account":{"login":"other"}},`+
+		`"repositories":[{"full_name":"other/repo"}]}`)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("installation created: got %d, want 204", rr.Code)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// logFormatText is the default human-oriented `[repo] message` format.
+	logFormatText = "text"
+	// logFormatJSON emits one JSON object per record.
+	logFormatJSON = "json"
+)
+
+// textPrefixKeys are rendered in the `[...]` prefix of text records.
+var textPrefixKeys = []string{"repo", "run_id"}
+
+// textHiddenKeys are only useful for machine consumers and omitted in text.
+var textHiddenKeys = []string{"stream"}
+
+// setupLogging installs the process-wide slog logger for format.
+func setupLogging(format string, w io.Writer) error {
+	var h slog.Handler
+	switch format {
+	case "", logFormatText:
+		h = newTextHandler(w)
+	case logFormatJSON:
+		h = slog.NewJSONHandler(w, nil)
+	default:
+		return fmt.Errorf("log_format must be %q or %q, got %q",
+			logFormatText, logFormatJSON, format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// textHandler renders records as `[repo run=ID] message key=value ...`,
+// matching the daemon's historical log lines. No timestamps: journald adds
+// them.
+type textHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	attrs []slog.Attr
+}
+
+// newTextHandler returns a textHandler writing to w.
+func newTextHandler(w io.Writer) *textHandler {
+	return &textHandler{mu: &sync.Mutex{}, w: w}
+}
+
+// Enabled implements slog.Handler.
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+// WithAttrs implements slog.Handler.
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &textHandler{
+		mu:    h.mu,
+		w:     h.w,
+		attrs: append(slices.Clip(h.attrs), attrs...),
+	}
+}
+
+// WithGroup implements slog.Handler. Groups are flattened.
+func (h *textHandler) WithGroup(_ string) slog.Handler {
+	return h
+}
+
+// Handle implements slog.Handler.
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := slices.Clone(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	var prefix []string
+	var rest []slog.Attr
+	for _, a := range attrs {
+		switch {
+		case a.Key == "repo":
+			prefix = append([]string{a.Value.String()}, prefix...)
+		case a.Key == "run_id":
+			prefix = append(prefix, "run="+a.Value.String())
+		case slices.Contains(textHiddenKeys, a.Key):
+		default:
+			rest = append(rest, a)
+		}
+	}
+
+	var buf bytes.Buffer
+	if len(prefix) > 0 {
+		buf.WriteString("[" + strings.Join(prefix, " ") + "] ")
+	}
+	if r.Level >= slog.LevelWarn {
+		buf.WriteString(strings.ToLower(r.Level.String()) + ": ")
+	}
+	buf.WriteString(r.Message)
+	for _, a := range rest {
+		buf.WriteString(" " + a.Key + "=" + formatTextValue(a.Value))
+	}
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+// formatTextValue quotes values that contain spaces or quotes.
+func formatTextValue(v slog.Value) string {
+	s := v.Resolve().String()
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// newRunID returns a random identifier correlating all records of one run.
+func newRunID() string {
+	var b [6]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// lineWriter splits written bytes into lines and emits each one separately,
+// so multi-line command output becomes one log record per line.
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  []byte
+	emit func(line string)
+}
+
+// Write implements io.Writer.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush emits any trailing partial line.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.emit(string(w.buf))
+		w.buf = nil
+	}
+}
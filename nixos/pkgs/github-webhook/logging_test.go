@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunCommandJSONLogs emits one JSON record per output line, all tagged
+// with the run's id and delivery id.
+func TestRunCommandJSONLogs(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	if err := setupLogging(logFormatJSON, &logs); err != nil {
+		t.Fatalf("setupLogging: %v", err)
+	}
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Command:    []string{"sh", "-c", "echo one; echo two >&2; exit 3"},
+			WorkingDir: t.TempDir(),
+		},
+		timeout: 5 * time.Second,
+	}
+
+	tctx := triggerContext{event: "push", commit: "abc123", deliveryID: "d-1"}
+	if err := handler.runCommand(t.Context(), tctx); err == nil {
+		t.Fatalf("expected command to fail")
+	}
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("record is not JSON: %q: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+
+	runID, _ := records[0]["run_id"].(string)
+	if runID == "" {
+		t.Fatalf("first record has no run_id: %v", records[0])
+	}
+
+	var output []string
+	var finished map[string]any
+	for _, rec := range records {
+		if rec["run_id"] != runID || rec["repo"] != "test/repo" {
+			t.Errorf("record not correlated: %v", rec)
+		}
+		if rec["stream"] == "output" {
+			output = append(output, rec["msg"].(string))
+		}
+		if rec["msg"] == "run finished" {
+			finished = rec
+		}
+	}
+
+	if got := strings.Join(output, ","); got != "one,two" {
+		t.Errorf("expected one record per output line, got %q", got)
+	}
+	if finished == nil {
+		t.Fatalf("no run finished record: %v", records)
+	}
+	if finished["status"] != "failure" || finished["exit_code"] != float64(3) {
+		t.Errorf("unexpected run finished record: %v", finished)
+	}
+	if records[0]["delivery_id"] != "d-1" || records[0]["commit"] != "abc123" {
+		t.Errorf("run started missing trigger fields: %v", records[0])
+	}
+}
+
+// TestTextHandler renders the repo and run id as a prefix and hides
+// machine-only keys.
+func TestTextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newTextHandler(&buf)).With("repo", "o/r")
+
+	logger.With("run_id", "abc").Info("hello world", "stream", "output")
+	logger.Warn("poll failed", "err", "exit status 128")
+
+	want := "[o/r run=abc] hello world\n" +
+		"[o/r] warn: poll failed err=\"exit status 128\"\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected text output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// captureLogs routes slog records to a buffer in text format for the
+// duration of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(newTextHandler(&buf)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
@@ -5,92 +5,358 @@
 //
 //   - single HTTP endpoint for all webhooks: POST /webhooks/github
 //   - JSON-based configuration loaded at startup
-//   - per-repo HMAC verification (each repo can have different secret)
+//   - per-repo HMAC verification (each repo can have different secret), or
+//     one GitHub App webhook secret covering every installed repo
 //   - per-repo command execution with standard environment variables
-//   - per-repo debouncing to avoid overlapping commands (serial execution)
+//   - optional multi-step pipelines with per-step timeouts and conditions
+//   - per-branch debouncing to avoid overlapping commands (serial execution
+//     per branch and working dir)
+//   - optional per-branch overrides of command, working dir, env and timeout
+//   - optional global run limit across repos, served by per-repo priority
 //   - optional run-on-startup for initial sync
+//   - optional repo patterns, auto-enabled for repos added to the GitHub App
+//     installation
+//   - last successful commit per repo, job and branch persisted in a state
+//     file, so already-deployed commits are not re-run after a restart
+//   - optional `git ls-remote` polling as a fallback for missed webhooks
+//   - optional cron schedules that run through the same serialized queue
+//   - optional deploy freeze windows and admin locks that hold runs until
+//     they lift
+//   - optional release and workflow_run triggered jobs, e.g. to deploy only
+//     once CI is green
+//   - optional retries, run_as, systemd-run isolation, per-repo secrets,
+//     GitHub deployments, delivery forwarding and managed cache and
+//     artifacts dirs
 //   - generous 1-hour command timeout
-//   - logs to stderr for journald
+//   - logs to stderr for journald, as text or JSON records, and optional
+//     OTLP traces
+//   - sd_notify readiness, status and watchdog when run as a Type=notify unit
+//   - read-only HTML status page at GET / and run logs at GET /runs/{id},
+//     optionally on a separate dashboard_addr listener
+//   - signature verification, typed payloads, the debouncer and a generic
+//     event Dispatcher live in the importable package
+//     github.com/phlip9/github-webhook/webhook, for embedding in other tools;
+//     the daemon routes its own deliveries through that Dispatcher
 //
-// config file structure (JSON):
+// config file structure (JSON, unknown fields are rejected; Config, Repo
+// and the types they use document every field):
 //
 // ```json
 //
 //	{
 //	  "port": "8673",
+//	  "dashboard_addr": "127.0.0.1:8674",
+//	  "state_path": "/var/lib/github-webhook/state.json",
+//	  "data_dir": "/var/lib/github-webhook",
 //	  "repos": {
 //	    "phlip9/dotfiles": {
-//	      "secret_path": "/run/credentials/github-webhook/dotfiles-secret",
-//	      "branches": ["master"],
-//	      "command": ["/path/to/script.sh"],
+//	      "secret_path": "%d/dotfiles-secret",
+//	      "branches": ["master", {"name": "staging", "working_dir": "/srv/staging"}],
+//	      "steps": [
+//	        {"name": "fetch", "command": ["git", "pull", "--ff-only"]},
+//	        {"name": "build", "command": ["nix", "build"], "if": {"paths": ["pkgs/**"]}}
+//	      ],
 //	      "working_dir": "/home/phlip9/dev/dotfiles",
 //	      "quiet_ms": 500,
 //	      "run_on_startup": true,
-//	      "timeout_ms": 3600000
+//	      "timeout_ms": 3600000,
+//	      "run_as": {"uid": 1000, "gid": 100},
+//	      "secrets": {"CACHE_SIGNING_KEY": "%d/cache-signing-key"},
+//	      "secrets_mode": "files",
+//	      "remote_url": "https://github.com/phlip9/dotfiles.git",
+//	      "poll_interval_ms": 300000,
+//	      "schedule": [{"name": "flake-update", "cron": "0 4 * * *", "command": ["/path/to/flake-update.sh"]}]
 //	    }
 //	  }
 //	}
 //
 // ```
 //
-//   - secret_path supports "%d/" prefix, which expands to
-//     `$CREDENTIALS_DIRECTORY/` (systemd credentials).
-//
 // environment variables passed to commands:
 //
-//   - GH_EVENT: event type (e.g., "push", "ping")
+//   - GH_EVENT: event type (e.g., "push", "poll", "startup", "release")
 //   - GH_REPO: repository full name (e.g., "phlip9/dotfiles")
 //   - GH_REF: git ref (e.g., "refs/heads/master")
 //   - GH_BRANCH: branch name (e.g., "master")
-//   - GH_COMMIT: commit SHA
+//   - GH_COMMIT: commit SHA (the head SHA for workflow_run)
+//   - GH_PREVIOUS_COMMIT: last commit the job ran successfully on the branch,
+//     empty if unknown, e.g. for `git diff $GH_PREVIOUS_COMMIT $GH_COMMIT`
 //   - GH_SENDER: GitHub username who triggered the event
+//   - GH_JOB: schedule or trigger name, empty for push/poll/startup runs
+//   - GH_ATTEMPT: 1-based attempt number
+//   - GH_STEP: pipeline step name, when the repo uses steps
+//   - GH_SECRETS_DIR: per-run secrets directory (secrets_mode="files")
+//   - GH_CACHE_DIR: persistent per-job cache directory (with data_dir)
+//   - GH_ARTIFACTS_DIR: per-run artifacts directory (with data_dir)
+//   - GH_RESULT_PATH: file the command may write its JSON result to
+//   - GH_ENVIRONMENT: deployment environment of the job, if any
+//   - GH_DEPLOYMENT_ID: GitHub deployment id, if one was created
+//   - GH_RELEASE_TAG: release tag name, for release runs
+//   - GH_WORKFLOW_RUN_ID: workflow run id, for workflow_run runs
+//   - TRACEPARENT: W3C trace context of the command span, when tracing is
+//     enabled, so scripts can add child spans
+//
+// subcommands (offline, for testing configs):
+//
+//   - simulate: routes a delivery in-process and prints what would run
+//   - check-config: strictly parses and validates the config
+//   - sign: prints an X-Hub-Signature-256 header value for curl tests
 //
 // envs:
 //
-// - CONFIG_PATH: path to JSON configuration file
-// - CREDENTIALS_DIRECTORY: used when secret_path begins with "%d/"
+//   - CONFIG_PATH: path to JSON configuration file
+//   - CREDENTIALS_DIRECTORY: used when a secret path begins with "%d/"
+//   - RUNTIME_DIRECTORY: parent of per-run secrets and result dirs
+//   - NOTIFY_SOCKET, WATCHDOG_USEC: systemd readiness, status and watchdog
 package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/phlip9/github-webhook/webhook"
 )
 
 // Config is the top-level configuration structure.
 type Config struct {
-	Port  string           `json:"port"`
+	// Port serves POST /webhooks/github, /healthz and /metrics. Deliveries
+	// may use either hook content type: application/json, or
+	// application/x-www-form-urlencoded with the JSON in the "payload" field
+	// (the signature covers the raw body).
+	Port string `json:"port"`
+	// Repos maps repo full names to their config. Keys may also be patterns
+	// (path.Match syntax, e.g. "phlip9/*"; the longest match wins) whose
+	// working_dir, command and remote_url may use {owner} and {name}; they
+	// are enabled through GitHubApp.
 	Repos map[string]*Repo `json:"repos"`
+
+	// MaxConcurrentRuns limits command attempts running at once across all
+	// repos. 0 means unlimited. Waiting attempts are served by descending
+	// Repo.Priority, then arrival order; queue position and wait time are
+	// logged and exported on GET /metrics.
+	MaxConcurrentRuns int `json:"max_concurrent_runs"`
+
+	// LogFormat is "text" (default, `[repo run=ID] message key=value`) or
+	// "json" (one object per record). Every run gets a run_id that tags all
+	// its records, including one per line of command output; webhook runs
+	// also carry the X-GitHub-Delivery id as delivery_id.
+	LogFormat string `json:"log_format"`
+
+	// Tracing enables OTLP trace export. nil disables tracing.
+	Tracing *TracingConfig `json:"tracing"`
+
+	// GitHub configures API access for deployments.
+	GitHub *GitHubConfig `json:"github"`
+
+	// PublicURL is the externally reachable base URL of the dashboard, used
+	// for run log links.
+	PublicURL string `json:"public_url"`
+	// DashboardAddr is a separate host:port listener for the dashboard, run
+	// logs, artifacts and admin API. Empty serves them on Port, next to the
+	// webhook endpoint: the pages are unauthenticated, so anyone who can
+	// deliver webhooks can then read run output and artifacts (secrets are
+	// redacted, other output is not), and the admin API is only guarded by
+	// admin_token_path.
+	DashboardAddr string `json:"dashboard_addr"`
+
+	// StatePath is the state file remembering the last commit each job ran
+	// successfully on each branch, written atomically after every success,
+	// plus admin locks, held triggers, schedule checks and auto-enabled
+	// repos. A push, poll or startup trigger for a commit that already
+	// succeeded is skipped unless forced. Empty keeps them in memory only.
+	StatePath string `json:"state_path"`
+
+	// AllowedCIDRs lists source networks allowed to deliver webhooks (403
+	// otherwise). Empty, with no GitHubMetaPath, allows any.
+	AllowedCIDRs []string `json:"allowed_cidrs"`
+	// GitHubMetaPath is a local copy of GitHub's /meta response whose
+	// "hooks" ranges are allowed as well. Re-read when it changes.
+	GitHubMetaPath string `json:"github_meta_path"`
+	// TrustedProxies lists proxy networks whose X-Forwarded-For is trusted:
+	// the source is then the right-most address that is not a trusted proxy.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// MaxAuthFailuresPerMinute rejects IPs (IPv6 by /64) with 429 after this
+	// many failed signature checks in a minute. 0 disables the limit. Behind
+	// a proxy, set TrustedProxies too, or every delivery shares the proxy's
+	// budget.
+	MaxAuthFailuresPerMinute int `json:"max_auth_failures_per_minute"`
+
+	// DataDir holds the managed run dirs: GH_CACHE_DIR persists per repo and
+	// job across runs (DataDir/cache/OWNER/REPO/JOB), while GH_ARTIFACTS_DIR
+	// is empty at the start of each run and what a run leaves there is
+	// served at GET /runs/{id}/artifacts/PATH (an empty PATH lists them).
+	// Both are owned by run_as and writable under systemd-run isolation. A
+	// job's runs on different branches share its cache dir, so they take
+	// turns. Empty disables them.
+	DataDir string `json:"data_dir"`
+
+	// GitHubApp enables a GitHub App webhook covering every installed repo.
+	GitHubApp *GitHubAppConfig `json:"github_app"`
+
+	// AdminTokenPath is a file holding the bearer token of the admin API
+	// (supports "%d/"). Empty disables the admin API. `PUT
+	// /admin/locks/OWNER/REPO` with `Authorization: Bearer TOKEN` and
+	// {"reason": "...", "duration_ms": N} (or "until": RFC 3339 time) sets a
+	// lock that holds the repo's runs like a freeze window until it expires;
+	// DELETE clears it.
+	AdminTokenPath string `json:"admin_token_path"`
 }
 
 // Repo represents a repository configuration.
 type Repo struct {
-	SecretPath   string   `json:"secret_path"`
-	Branches     []string `json:"branches"`
-	Command      []string `json:"command"`
-	WorkingDir   string   `json:"working_dir"`
-	QuietMs      int      `json:"quiet_ms"`
-	RunOnStartup bool     `json:"run_on_startup"`
-	TimeoutMs    int      `json:"timeout_ms"`
+	// SecretPath is the repo's webhook secret. It supports a "%d/" prefix,
+	// which expands to `$CREDENTIALS_DIRECTORY/` (systemd credentials).
+	SecretPath string `json:"secret_path"`
+	// Branches lists the tracked branches. Each has its own debouncer, so a
+	// push to one branch never coalesces with, or drops, a push to another;
+	// runs of different branches may overlap unless they share a working
+	// dir. Runs without a branch (schedules, releases) use the repo's.
+	Branches   []Branch `json:"branches"`
+	Command    []string `json:"command"`
+	WorkingDir string   `json:"working_dir"`
+	QuietMs    int      `json:"quiet_ms"`
+	// RunOnStartup queues a run when the daemon starts, on the debouncers
+	// like pushes, so it never delays readiness. With RemoteURL, the tracked
+	// branch heads are resolved first, so a restart only runs branches that
+	// moved while the daemon was down. Without it, the run is skipped while
+	// every working dir's checkout (git rev-parse HEAD, run as run_as) is at
+	// a commit that already succeeded on a branch using it.
+	RunOnStartup bool `json:"run_on_startup"`
+	TimeoutMs    int  `json:"timeout_ms"`
+
+	// Steps replaces Command with an ordered pipeline. The first failing
+	// step without continue_on_error fails the attempt ("step build
+	// failed"). Each step's status and output are recorded separately on
+	// the dashboard and in logs (step=).
+	Steps []*Step `json:"steps"`
+
+	// RemoteURL is the git remote polled by `git ls-remote`.
+	RemoteURL string `json:"remote_url"`
+	// PollIntervalMs enables polling RemoteURL when > 0. A tracked branch
+	// head that differs from the last commit that ran successfully triggers
+	// a synthetic "poll" event.
+	PollIntervalMs int `json:"poll_interval_ms"`
+
+	// Schedule lists cron-triggered jobs for this repo. They run with
+	// GH_EVENT=schedule; per-job triggers are coalesced but never dropped by
+	// webhook triggers.
+	Schedule []*Schedule `json:"schedule"`
+
+	// Triggers lists jobs run by release and workflow_run events.
+	Triggers []*EventTrigger `json:"triggers"`
+	// DisablePush acknowledges push events without running anything, e.g.
+	// when deploys are gated on a workflow_run trigger instead.
+	DisablePush bool `json:"disable_push"`
+
+	// Retry configures automatic retries of failed commands.
+	Retry *RetryConfig `json:"retry"`
+
+	// Priority orders this repo's runs when waiting for a global run slot.
+	// Higher runs first.
+	Priority int `json:"priority"`
+
+	// RunAs runs commands with this uid/gid instead of the daemon's, with no
+	// supplementary groups (needs CAP_SETUID, e.g. a root daemon).
+	RunAs *RunAs `json:"run_as"`
+	// PassEnv lists daemon environment variables passed to commands. When
+	// set (or CleanEnv is true), nothing else is inherited. Without either,
+	// commands inherit the whole daemon environment, or only PATH and HOME
+	// under isolation="systemd-run".
+	PassEnv []string `json:"pass_env"`
+	// CleanEnv starts commands from an empty environment plus PassEnv.
+	CleanEnv bool `json:"clean_env"`
+	// Isolation is "" (plain child process) or "systemd-run", which launches
+	// each attempt as a transient unit with ProtectSystem=strict,
+	// PrivateTmp=yes, NoNewPrivileges=yes and the working dir writable. Its
+	// environment is passed on the systemd-run command line, so secrets need
+	// secrets_mode="files".
+	Isolation string `json:"isolation"`
+	// SystemdRun configures the transient unit for isolation=systemd-run.
+	SystemdRun *SystemdRunConfig `json:"systemd_run"`
+
+	// Secrets maps env var name -> secret path (supports "%d/"). They are
+	// only visible to this repo's commands. Secret values, and each line of
+	// at least 8 non-whitespace bytes of multi-line ones, are redacted from
+	// logged command output.
+	Secrets map[string]string `json:"secrets"`
+	// SecretsMode is "env" (default) or "files", which sets NAME_FILE to
+	// paths in a private per-run dir under $RUNTIME_DIRECTORY instead. Files
+	// mode fails without one rather than leaving secrets on a disk-backed
+	// temp dir.
+	SecretsMode string `json:"secrets_mode"`
+
+	// Environment reports webhook/poll/startup runs as GitHub deployments to
+	// the named environment: a deployment is created for the commit (or the
+	// branch for scheduled runs), marked in_progress, then success or
+	// failure with a log_url of public_url + "/runs/{id}". After a success,
+	// older successful deployments of the environment are marked inactive.
+	// API errors are logged and never fail the run.
+	Environment string `json:"environment"`
+
+	// ForwardTo relays every verified delivery (also pings and untracked
+	// branches) to other webhook listeners, so one host reachable from
+	// GitHub can drive the rest. Each delivery is re-signed, re-POSTed with
+	// the original X-GitHub-* headers through a per-target queue, retried
+	// with backoff on network errors, 429 and 5xx, and forwarded at most
+	// once per X-GitHub-Delivery id.
+	ForwardTo []*ForwardTarget `json:"forward_to"`
+
+	// MaxBodyBytes limits delivery size; larger ones get 413. 0 uses
+	// GitHub's 25 MB cap.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+
+	// StdinPayload sends the delivery's JSON payload (decoded from form
+	// bodies) on commands' stdin. Runs without a delivery (poll, schedule,
+	// startup) get an empty stdin.
+	StdinPayload bool `json:"stdin_payload"`
+
+	// Artifacts limits the kept run artifacts (needs Config.DataDir).
+	Artifacts *ArtifactsConfig `json:"artifacts"`
+
+	// Freeze lists recurring windows during which runs are held. Deliveries
+	// during a freeze (or an admin lock) are still accepted (202, with the
+	// freeze in the body); only the newest trigger per branch and job is
+	// held, persisted in the state file, and runs once the freeze lifts.
+	// Forced runs (simulate --force) ignore freezes.
+	Freeze []*FreezeWindow `json:"freeze"`
 }
 
 // app holds the HTTP server and repository handlers.
 type app struct {
-	cfg      Config
+	// ctx bounds command runs, also of repos enabled at runtime.
+	ctx context.Context
+
+	cfg Config
+
+	// mu guards handlers, which installation events add to and remove from.
+	mu       sync.RWMutex
 	handlers map[string]*repoHandler // key: repo full_name
+	sem      *runSemaphore           // nil: unlimited
+	tracer   *tracer                 // nil: tracing disabled
+	github   *githubClient           // nil: no GitHub API access
+	state    *stateStore             // last successful commits
+	access   *accessControl          // nil: open endpoint
+
+	// adminToken authenticates the admin API; empty disables it.
+	adminToken []byte
+	// appSecret verifies GitHub App hook deliveries; nil without an app.
+	appSecret []byte
 }
 
 // repoHandler manages command execution for a single repository.
@@ -98,33 +364,57 @@ type repoHandler struct {
 	fullName string
 	repo     Repo
 	secret   []byte
-	deb      *debouncer
+	deb      *debouncer // runs without a tracked branch
 	timeout  time.Duration
+	sem      *runSemaphore     // global run limit; nil: unlimited
+	secrets  map[string][]byte // key: env var name
+	specs    []*cronSpec       // parsed repo.Schedule entries
+	// freezeSpecs are the parsed repo.Freeze cron expressions.
+	freezeSpecs []*cronSpec
+	tracer      *tracer       // nil: tracing disabled
+	history     *runHistory   // recent runs; nil: not recorded
+	github      *githubClient // nil: no GitHub API access
+	state       *stateStore   // last successful commits; nil: not recorded
+	dataDir     string        // Config.DataDir; "": no cache/artifacts dirs
+	// forwarders relay verified deliveries, one per ForwardTo target.
+	forwarders []*forwarder
+
+	// publicURL is Config.PublicURL without a trailing slash.
+	publicURL string
+
+	// branchDebs holds one debouncer per tracked branch.
+	branchDebs map[string]*debouncer
+
+	// autoEnabled is set for repos enabled from an installation event.
+	autoEnabled bool
+	// stop cancels the loops of a repo enabled at runtime; nil otherwise.
+	stop context.CancelFunc
+
+	mu sync.Mutex
+	// lastPolled maps branch -> last commit a poll triggered a run for.
+	lastPolled map[string]string
+	// dirLocks serializes runs per working dir.
+	dirLocks map[string]*sync.Mutex
+	// held maps branch and job -> newest trigger held by a freeze.
+	held map[string]triggerContext
+	// heldSaveMu serializes writing held to the state file.
+	heldSaveMu sync.Mutex
+	// paused counts runs waiting for their working dir, a global run slot
+	// or a retry backoff.
+	paused int
+	// unlocked is signalled when the admin lock is cleared, to release held
+	// triggers right away.
+	unlocked chan struct{}
 }
 
-// pushEvent models GitHub push webhook payload (minimal fields).
-type pushEvent struct {
-	Ref        string `json:"ref"`
-	After      string `json:"after"`
-	Repository struct {
-		FullName string `json:"full_name"`
-	} `json:"repository"`
-	Sender struct {
-		Login string `json:"login"`
-	} `json:"sender"`
-}
-
-// pingEvent models GitHub ping webhook payload (minimal fields).
-type pingEvent struct {
-	Repository struct {
-		FullName string `json:"full_name"`
-	} `json:"repository"`
-}
-
-// main loads config, initializes handlers, runs startup commands, starts server.
+// main dispatches subcommands, or runs the webhook daemon by default.
 func main() {
 	log.SetFlags(0)
 
+	if len(os.Args) > 1 {
+		os.Exit(runSubcommand(os.Args[1], os.Args[2:]))
+	}
+
 	configPath := os.Getenv("CONFIG_PATH")
 	if configPath == "" {
 		log.Fatal("CONFIG_PATH is required")
@@ -134,70 +424,296 @@ func main() {
 	if err != nil {
 		log.Fatalf("config: %v", err)
 	}
+	if err := setupLogging(cfg.LogFormat, os.Stderr); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, err := newApp(ctx, cfg)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	a.start(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhooks/github", a.handleWebhook)
+	mux.HandleFunc("/healthz", handleHealth)
+	mux.HandleFunc("/metrics", a.handleMetrics)
+
+	// The dashboard and run logs are unauthenticated; dashboard_addr keeps
+	// them (and the admin API) off the webhook port.
+	dashMux := mux
+	if cfg.DashboardAddr != "" {
+		dashMux = http.NewServeMux()
+		dashMux.HandleFunc("/healthz", handleHealth)
+	}
+	dashMux.HandleFunc("GET /{$}", a.handleDashboard)
+	dashMux.HandleFunc("GET /runs/{id}", a.handleRunLog)
+	dashMux.HandleFunc("GET /runs/{id}/artifacts/{path...}", a.handleArtifact)
+	dashMux.HandleFunc("PUT /admin/locks/{owner}/{repo}", a.handleLock)
+	dashMux.HandleFunc("DELETE /admin/locks/{owner}/{repo}", a.handleLock)
+
+	addr := ":" + cfg.Port
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	notify, err := newNotifier()
+	if err != nil {
+		log.Fatalf("systemd: %v", err)
+	}
+
+	// Bind before reporting readiness, so dependent units only start once
+	// deliveries are accepted.
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("http server: %v", err)
+	}
+	slog.Info("listening", "addr", addr)
+	if cfg.DashboardAddr != "" {
+		dashLn, err := net.Listen("tcp", cfg.DashboardAddr)
+		if err != nil {
+			log.Fatalf("dashboard server: %v", err)
+		}
+		slog.Info("dashboard listening", "addr", cfg.DashboardAddr)
+		dashServer := &http.Server{Addr: cfg.DashboardAddr, Handler: dashMux}
+		go func() {
+			if err := dashServer.Serve(dashLn); err != nil &&
+				!errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("dashboard server: %v", err)
+			}
+		}()
+	}
+	notify.send("READY=1")
+	go a.notifyLoop(ctx, notify)
+
+	if err := server.Serve(ln); err != nil &&
+		!errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("http server: %v", err)
+	}
+}
+
+// newApp initializes a handler for each repo, and for each repo a GitHub App
+// installation enabled earlier. Background loops are not started until start
+// is called; ctx bounds command runs.
+func newApp(ctx context.Context, cfg Config) (*app, error) {
+	state, err := openStateStore(cfg.StatePath)
+	if err != nil {
+		return nil, err
+	}
 
 	a := &app{
+		ctx:      ctx,
 		cfg:      cfg,
 		handlers: make(map[string]*repoHandler),
+		sem:      newRunSemaphore(cfg.MaxConcurrentRuns),
+		tracer:   newTracer(cfg.Tracing),
+		github:   newGitHubClient(cfg.GitHub),
+		state:    state,
+		access:   newAccessControl(&cfg),
+	}
+	if cfg.AdminTokenPath != "" {
+		if a.adminToken, err = readSecret(cfg.AdminTokenPath); err != nil {
+			return nil, fmt.Errorf("read admin token: %w", err)
+		}
+	}
+	if cfg.GitHubApp != nil {
+		if a.appSecret, err = readSecret(cfg.GitHubApp.SecretPath); err != nil {
+			return nil, fmt.Errorf("read github_app secret: %w", err)
+		}
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Initialize handlers for each repo.
 	for repoFullName, repo := range cfg.Repos {
-		secret, err := readSecret(repo.SecretPath)
+		if isRepoPattern(repoFullName) {
+			continue
+		}
+		handler, err := a.newHandler(repoFullName, repo)
 		if err != nil {
-			log.Fatalf("read secret for repo %s: %v", repoFullName, err)
+			return nil, err
 		}
+		a.handlers[repoFullName] = handler
+	}
 
-		timeout := time.Duration(repo.TimeoutMs) * time.Millisecond
-		quiet := time.Duration(repo.QuietMs) * time.Millisecond
+	// Repos enabled from installation events survive restarts.
+	for _, repoFullName := range state.autoEnabled() {
+		if a.handlers[repoFullName] != nil {
+			continue
+		}
+		pattern, repo := cfg.repoTemplate(repoFullName)
+		if repo == nil {
+			slog.Warn("auto-enabled repo matches no pattern, skipping", "repo", repoFullName)
+			continue
+		}
+		handler, err := a.newHandler(repoFullName, repo)
+		if err != nil {
+			return nil, fmt.Errorf("%w (from %s)", err, pattern)
+		}
+		handler.autoEnabled = true
+		a.handlers[repoFullName] = handler
+	}
+
+	return a, nil
+}
 
-		handler := &repoHandler{
-			fullName: repoFullName,
-			repo:     *repo,
-			secret:   secret,
-			timeout:  timeout,
+// newHandler initializes the handler of one repo. Its command runs are
+// bounded by a.ctx.
+func (a *app) newHandler(repoFullName string, repo *Repo) (*repoHandler, error) {
+	if err := checkSecretsDir(repo); err != nil {
+		return nil, fmt.Errorf("repo %s: %w", repoFullName, err)
+	}
+	runSecrets, err := loadSecrets(repo)
+	if err != nil {
+		return nil, fmt.Errorf("repo %s: %w", repoFullName, err)
+	}
+
+	// Repos without their own hook secret are delivered by the app hook.
+	secret := a.appSecret
+	if repo.SecretPath != "" {
+		if secret, err = readSecret(repo.SecretPath); err != nil {
+			return nil, fmt.Errorf("read secret for repo %s: %w", repoFullName, err)
 		}
+	}
 
-		handler.deb = newDebouncer(quiet, func(tctx triggerContext) error {
-			return handler.runCommand(ctx, tctx)
-		})
+	specs := make([]*cronSpec, len(repo.Schedule))
+	for i, sched := range repo.Schedule {
+		if specs[i], err = parseSchedule(sched); err != nil {
+			return nil, fmt.Errorf("repo %s: %w", repoFullName, err)
+		}
+	}
 
-		a.handlers[repoFullName] = handler
+	freezeSpecs := make([]*cronSpec, len(repo.Freeze))
+	for i, w := range repo.Freeze {
+		if freezeSpecs[i], err = parseFreezeWindow(w); err != nil {
+			return nil, fmt.Errorf("repo %s: freeze[%d].%w", repoFullName, i, err)
+		}
+	}
 
-		// Start debouncer goroutine.
-		go handler.deb.run(ctx)
+	var forwarders []*forwarder
+	for _, target := range repo.ForwardTo {
+		f, err := newForwarder(repoFullName, target)
+		if err != nil {
+			return nil, fmt.Errorf("repo %s: %w", repoFullName, err)
+		}
+		forwarders = append(forwarders, f)
+	}
 
-		// Run startup command if configured.
-		if repo.RunOnStartup {
-			log.Printf("[%s] running startup command", repoFullName)
-			tctx := triggerContext{event: "startup"}
-			if err := handler.runCommand(ctx, tctx); err != nil {
-				log.Printf("[%s] startup command failed: %v", repoFullName, err)
-			}
+	timeout := time.Duration(repo.TimeoutMs) * time.Millisecond
+	quiet := time.Duration(repo.QuietMs) * time.Millisecond
+
+	handler := &repoHandler{
+		fullName: repoFullName,
+		repo:     *repo,
+		secret:   secret,
+		timeout:  timeout,
+		sem:      a.sem,
+		secrets:  runSecrets,
+		specs:    specs,
+		tracer:   a.tracer,
+		history:  newRunHistory(),
+		github:   a.github,
+		state:    a.state,
+		dataDir:  a.cfg.DataDir,
+
+		forwarders:  forwarders,
+		publicURL:   strings.TrimSuffix(a.cfg.PublicURL, "/"),
+		freezeSpecs: freezeSpecs,
+		unlocked:    make(chan struct{}, 1),
+	}
+
+	runFn := func(tctx triggerContext) error {
+		return handler.runCommand(a.ctx, tctx)
+	}
+	handler.deb = newDebouncer(quiet, runFn)
+	handler.branchDebs = make(map[string]*debouncer, len(repo.Branches))
+	for _, b := range repo.Branches {
+		handler.branchDebs[b.Name] = newDebouncer(quiet, runFn)
+	}
+	handler.restoreHeld()
+	return handler, nil
+}
+
+// start launches debouncers, pollers and schedules, and queues startup
+// runs.
+func (a *app) start(ctx context.Context) {
+	go a.tracer.run(ctx)
+
+	for _, handler := range a.handlerList() {
+		hctx := ctx
+		if handler.autoEnabled {
+			// Stopped if the repo is removed from the installation.
+			hctx, handler.stop = context.WithCancel(ctx)
 		}
+		handler.start(hctx)
 	}
+}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/webhooks/github", a.handleWebhook)
-	mux.HandleFunc("/healthz", handleHealth)
+// start launches the handler's background loops until ctx is done, and
+// queues its startup runs without waiting for them.
+func (h *repoHandler) start(ctx context.Context) {
+	// Start debouncer goroutines.
+	for _, deb := range h.debouncers() {
+		go deb.Run(ctx)
+	}
 
-	addr := ":" + cfg.Port
-	server := &http.Server{
-		Addr:    addr,
-		Handler: mux,
+	for _, f := range h.forwarders {
+		go f.run(ctx)
 	}
 
-	log.Printf("listening on %s", addr)
+	// Release runs held by a freeze once it lifts.
+	go h.freezeLoop(ctx)
 
-	if err := server.ListenAndServe(); err != nil &&
-		!errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("http server: %v", err)
+	// Start git poller if configured.
+	if h.repo.PollIntervalMs > 0 {
+		interval := time.Duration(h.repo.PollIntervalMs) * time.Millisecond
+		go h.pollLoop(ctx, interval)
+	}
+
+	// Start cron schedules.
+	for i, sched := range h.repo.Schedule {
+		go h.scheduleLoop(ctx, sched, h.specs[i])
+	}
+
+	// Queue startup runs if configured. Resolving branch heads may take a
+	// while, and the runs themselves go through the debouncers, so neither
+	// delays binding the listener and READY=1.
+	if h.repo.RunOnStartup {
+		go h.runStartup(ctx)
+	}
+}
+
+// handler returns the handler of repo, or nil.
+func (a *app) handler(repo string) *repoHandler {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.handlers[repo]
+}
+
+// handlerList returns the handlers sorted by repo name.
+func (a *app) handlerList() []*repoHandler {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	list := make([]*repoHandler, 0, len(a.handlers))
+	for _, name := range sortedKeys(a.handlers) {
+		list = append(list, a.handlers[name])
+	}
+	return list
+}
+
+// secretFor returns the secret deliveries for repo are signed with: its
+// handler's, or else the GitHub App's (nil without an app).
+func (a *app) secretFor(repo string) []byte {
+	if h := a.handler(repo); h != nil {
+		return h.secret
 	}
+	return a.appSecret
 }
 
-// loadConfig reads and parses the JSON configuration file.
+// loadConfig reads, strictly parses and validates the JSON configuration
+// file. Unknown fields are errors, so typos don't silently fall back to
+// defaults.
 func loadConfig(path string) (Config, error) {
 	var cfg Config
 
@@ -206,9 +722,20 @@ func loadConfig(path string) (Config, error) {
 		return cfg, fmt.Errorf("read file: %w", err)
 	}
 
-	if err := json.Unmarshal(data, &cfg); err != nil {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
 		return cfg, fmt.Errorf("parse json: %w", err)
 	}
+	// A second Decode catches any trailing token (dec.More misses "}" and
+	// "]").
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return cfg, errors.New("parse json: trailing data after config object")
+	}
+
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config:\n%w", err)
+	}
 
 	return cfg, nil
 }
@@ -243,6 +770,31 @@ func expandSecretPath(path string) (string, error) {
 	return filepath.Join(credDir, rel), nil
 }
 
+// delivery is a verified webhook delivery routed to a repo handler.
+type delivery struct {
+	handler *repoHandler
+	event   string
+	// runs are the runs to trigger; empty for deliveries that are only
+	// acknowledged (ping, or no matching trigger).
+	runs []triggerContext
+	// installation is set, and handler nil, for GitHub App installation
+	// events.
+	installation *installationChange
+}
+
+// checkEvent rejects missing or unsupported X-GitHub-Event values.
+func checkEvent(event string) error {
+	switch event {
+	case "":
+		return &webhook.StatusError{Status: http.StatusBadRequest, Msg: "missing X-GitHub-Event"}
+	case "push", "ping", eventRelease, eventWorkflowRun,
+		eventInstallation, eventInstallationRepositories:
+		return nil
+	default:
+		return &webhook.StatusError{Status: http.StatusBadRequest, Msg: "unsupported event"}
+	}
+}
+
 // handleWebhook routes GitHub webhooks to appropriate repo handlers.
 func (a *app) handleWebhook(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -251,78 +803,250 @@ func (a *app) handleWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	event := r.Header.Get("X-GitHub-Event")
-	switch event {
-	case "":
-		http.Error(w, "missing X-GitHub-Event", http.StatusBadRequest)
+	deliveryID := r.Header.Get("X-GitHub-Delivery")
+	clientIP := a.access.clientIP(r)
+	logger := slog.Default().With(
+		"delivery_id", deliveryID,
+		"event", event,
+		"remote_ip", clientIP.String())
+
+	// Source checks come before any work on the body.
+	if !a.access.allows(clientIP) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		logger.Warn("delivery rejected", "status", http.StatusForbidden,
+			"err", "source address not allowed")
 		return
-	case "push", "ping":
-	default:
-		http.Error(w, "unsupported event", http.StatusBadRequest)
+	}
+	if a.access.limited(clientIP) {
+		http.Error(w, "too many failed deliveries", http.StatusTooManyRequests)
+		logger.Warn("delivery rejected", "status", http.StatusTooManyRequests,
+			"err", "too many signature failures")
 		return
 	}
 
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
-	if err != nil {
-		http.Error(w, "read body", http.StatusBadRequest)
+	// The delivery span is the root of the trace; runs it triggers are
+	// recorded as its descendants.
+	root := a.tracer.startServer("webhook delivery",
+		"github.event", event,
+		"github.delivery_id", deliveryID)
+	var rootErr error
+	defer func() { root.end(rootErr) }()
+
+	if err := checkEvent(event); err != nil {
+		status := webhook.WriteError(w, err)
+		root.set("http.response.status_code", status)
+		rootErr = err
+		logger.Info("delivery rejected", "status", status, "err", err)
 		return
 	}
 
-	// Parse payload to extract repository name (works for both push and ping).
-	var repoPayload struct {
-		Repository struct {
-			FullName string `json:"full_name"`
-		} `json:"repository"`
-	}
-	if err := json.Unmarshal(body, &repoPayload); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
+	// Bound the read by the largest repo limit; route applies the repo's own.
+	body, err := webhook.ReadBody(w, r, a.maxBodyBytes())
+	if err != nil {
+		status := webhook.WriteError(w, err)
+		root.set("http.response.status_code", status)
+		rootErr = err
+		logger.Warn("delivery rejected", "status", status, "err", err)
 		return
 	}
 
-	handler, exists := a.handlers[repoPayload.Repository.FullName]
-	if !exists {
-		http.Error(w, "repository not configured", http.StatusNotFound)
+	d, err := a.route(r.Context(), root.context(), r.Header, body)
+	if d != nil && d.handler != nil {
+		// Verified: relay even if this host does not track the branch.
+		d.handler.forward(r.Header, body, logger)
+	}
+	if err != nil {
+		status := webhook.WriteError(w, err)
+		if status == http.StatusUnauthorized {
+			a.access.recordFailure(clientIP)
+		}
+		root.set("http.response.status_code", status)
+		rootErr = err
+		logger.Warn("delivery rejected",
+			"repo", payloadRepo(r.Header.Get("Content-Type"), body),
+			"status", status,
+			"err", err)
 		return
 	}
-
-	// Verify signature with this repo's handler secret.
-	sigHeader := r.Header.Get("X-Hub-Signature-256")
-	if !verifySignature(handler.secret, body, sigHeader) {
-		http.Error(w, "invalid signature", http.StatusUnauthorized)
+	if d.installation != nil {
+		status := http.StatusNoContent
+		if a.applyInstallation(d.installation, logger) > 0 {
+			status = http.StatusAccepted
+		}
+		w.WriteHeader(status)
+		root.set("http.response.status_code", status)
 		return
 	}
+	logger = logger.With("repo", d.handler.fullName)
 
-	// Handle ping events (no further processing needed).
-	if event == "ping" {
+	// Handle ping events and deliveries without matching jobs (no further
+	// processing needed).
+	if len(d.runs) == 0 {
 		w.WriteHeader(http.StatusNoContent)
+		root.set("http.response.status_code", http.StatusNoContent)
+		logger.Info("delivery acknowledged", "status", http.StatusNoContent)
 		return
 	}
 
-	// Handle push events.
-	var payload pushEvent
-	if err := json.Unmarshal(body, &payload); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
-		return
+	// Trigger debounced command execution.
+	for _, tctx := range d.runs {
+		tctx.deliveryID = deliveryID
+		tctx.trace = root.context()
+		d.handler.enqueue(tctx)
+		logger.Info("delivery accepted",
+			"status", http.StatusAccepted,
+			"job", tctx.job,
+			"branch", tctx.branch,
+			"commit", tctx.commit)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	root.set("http.response.status_code", http.StatusAccepted)
+
+	// The runs are held until the freeze lifts; tell the sender why.
+	if f, ok := d.handler.frozen(time.Now()); ok {
+		_, _ = fmt.Fprintf(w, "queued; %s\n", f)
+		root.set("freeze.until", f.Until.Format(time.RFC3339))
+	}
+}
+
+// route verifies and routes a delivery through a webhook.Dispatcher, whose
+// handler applies the repo's size limit and branch filter. It has no side
+// effects besides trace spans under parent, so it also backs `simulate`.
+// Once the signature is verified, d is non-nil even if err is set
+// (untracked branch), so the delivery can be forwarded.
+func (a *app) route(
+	ctx context.Context,
+	parent spanContext,
+	header http.Header,
+	body []byte,
+) (d *delivery, err error) {
+	event := header.Get("X-GitHub-Event")
+	sp := a.tracer.start(parent, "route", "github.event", event)
+	defer func() { sp.end(err) }()
+
+	if err := checkEvent(event); err != nil {
+		return nil, err
+	}
+
+	dispatcher := webhook.NewDispatcher(func(repo string) webhook.Verifier {
+		return a.verifier(sp.context(), repo)
+	})
+	dispatcher.HandleFunc(webhook.AnyEvent, func(_ context.Context, dl *webhook.Delivery) error {
+		var err error
+		d, err = a.routeDelivery(sp, dl)
+		return err
+	})
+	_, err = dispatcher.Dispatch(ctx, header, body)
+	return d, err
+}
+
+// verifier returns the verifier of repo's deliveries, or nil for repos that
+// are not configured. GitHub App events without a repository are verified
+// with the app secret. Checks are traced under parent.
+func (a *app) verifier(parent spanContext, repo string) webhook.Verifier {
+	var secret []byte
+	switch h := a.handler(repo); {
+	case h != nil:
+		secret = h.secret
+	case repo == "" && a.appSecret != nil:
+		secret = a.appSecret
+	default:
+		return nil
 	}
+	return tracedVerifier{webhook.HMAC(secret), a.tracer, parent}
+}
 
-	// Extract branch from ref.
-	branch := strings.TrimPrefix(payload.Ref, "refs/heads/")
+// tracedVerifier records each check as a "verify signature" span.
+type tracedVerifier struct {
+	webhook.Verifier
+	tracer *tracer
+	parent spanContext
+}
+
+// Verify implements webhook.Verifier.
+func (v tracedVerifier) Verify(body []byte, signature string) error {
+	sp := v.tracer.start(v.parent, "verify signature")
+	err := v.Verifier.Verify(body, signature)
+	sp.end(err)
+	return err
+}
 
-	// Check if branch is in allowed list.
-	allowed := false
-	for _, b := range handler.repo.Branches {
-		if b == branch {
-			allowed = true
-			break
+// routeDelivery routes a verified delivery to its repo handler's runs, or to
+// the installation change for GitHub App events.
+func (a *app) routeDelivery(sp *span, dl *webhook.Delivery) (*delivery, error) {
+	if dl.Event == eventInstallation || dl.Event == eventInstallationRepositories {
+		// Only the app secret may sign installation changes.
+		if dl.Repository != "" {
+			return nil, &webhook.StatusError{Status: http.StatusBadRequest, Msg: "unexpected repository"}
 		}
+		return routeInstallation(dl), nil
 	}
-	if !allowed {
-		http.Error(w, "branch not tracked", http.StatusBadRequest)
-		return
+
+	handler := a.handler(dl.Repository)
+	if handler == nil {
+		return nil, &webhook.StatusError{Status: http.StatusNotFound, Msg: "repository not configured"}
 	}
+	sp.set("github.repository", handler.fullName)
 
-	// Trigger debounced command execution.
-	handler.deb.triggerWithContext(event, payload.Ref, branch, payload.After, payload.Sender.Login)
-	w.WriteHeader(http.StatusAccepted)
+	if int64(len(dl.Body)) > handler.maxBodyBytes() {
+		return nil, errPayloadTooLarge
+	}
+
+	d := &delivery{handler: handler, event: dl.Event}
+	switch dl.Event {
+	case "ping":
+		return d, nil
+	case eventRelease, eventWorkflowRun:
+		var err error
+		d.runs, err = handler.eventRuns(dl.Event, dl.Payload)
+		if err != nil {
+			return nil, err
+		}
+		for i := range d.runs {
+			d.runs[i].payload = dl.Payload
+		}
+		return d, nil
+	}
+
+	// Handle push events.
+	payload := dl.Parsed.(*webhook.PushEvent)
+
+	// Check if the pushed branch is in the allowed list.
+	branch, ok := payload.Branch()
+	if !ok || handler.repo.branch(branch) == nil {
+		return d, &webhook.StatusError{Status: http.StatusBadRequest, Msg: "branch not tracked"}
+	}
+
+	if handler.repo.DisablePush {
+		return d, nil
+	}
+	// The commit reaches git's command line (if.paths diffs).
+	if !isCommitID(payload.After) {
+		return d, &webhook.StatusError{Status: http.StatusBadRequest, Msg: "invalid commit"}
+	}
+
+	d.runs = []triggerContext{{
+		event:   dl.Event,
+		ref:     payload.Ref,
+		branch:  branch,
+		commit:  payload.After,
+		sender:  payload.Sender.Login,
+		payload: dl.Payload,
+	}}
+	return d, nil
+}
+
+// isCommitID reports whether s is a full SHA-1 or SHA-256 git object id.
+func isCommitID(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
 }
 
 // handleHealth answers liveness probes.
@@ -331,50 +1055,480 @@ func handleHealth(w http.ResponseWriter, _ *http.Request) {
 	_, _ = io.WriteString(w, "ok\n")
 }
 
-// runCommand executes the configured command with GitHub event context.
+// runCommand executes the configured command with GitHub event context,
+// retrying failed attempts according to the repo's retry policy.
 func (h *repoHandler) runCommand(ctx context.Context, tctx triggerContext) error {
-	if len(h.repo.Command) == 0 {
+	steps := h.stepsFor(tctx)
+	if len(steps) == 0 {
 		return errors.New("no command configured")
 	}
 
-	cmdCtx, cancel := context.WithTimeout(ctx, h.timeout)
+	if tctx.runID == "" {
+		tctx.runID = newRunID()
+	}
+	logger := h.runLogger(tctx)
+
+	tctx.previousCommit = h.previousCommit(tctx)
+	if !tctx.force && tctx.commit != "" && tctx.commit == tctx.previousCommit {
+		logger.Info("run skipped, commit already succeeded",
+			"event", tctx.event,
+			"delivery_id", tctx.deliveryID,
+			"branch", tctx.branch,
+			"commit", tctx.commit,
+			"job", tctx.job)
+		return nil
+	}
+
+	if f, ok := h.frozen(time.Now()); ok && !tctx.force {
+		h.hold(tctx)
+		logger.Info("run held, deploys frozen",
+			"event", tctx.event,
+			"delivery_id", tctx.deliveryID,
+			"branch", tctx.branch,
+			"commit", tctx.commit,
+			"job", tctx.job,
+			"reason", f.Reason,
+			"until", f.Until.Format(time.RFC3339),
+			"locked", f.Lock)
+		return nil
+	}
+
+	// Branch debouncers run concurrently; runs sharing a working dir or a
+	// job cache dir don't. The working dir is always locked first.
+	resume := h.pause()
+	unlock := h.lockDir(h.workingDir(tctx.branch))
+	if cache := h.cacheDir(tctx.job); cache != "" {
+		unlockCache := h.lockDir(cache)
+		defer unlockCache()
+	}
+	resume()
+	defer unlock()
+
+	logger.Info("run started",
+		"event", tctx.event,
+		"delivery_id", tctx.deliveryID,
+		"branch", tctx.branch,
+		"commit", tctx.commit,
+		"previous_commit", tctx.previousCommit,
+		"sender", tctx.sender,
+		"job", tctx.job)
+
+	// The run span covers the trigger from enqueue to completion, starting
+	// with its debounce wait.
+	start := time.Now()
+	runStart := cmp.Or(tctx.enqueuedAt, start)
+	runSpan := h.tracer.startAt(tctx.trace, "run", runStart,
+		"github.repository", h.fullName,
+		"github.event", tctx.event,
+		"github.branch", tctx.branch,
+		"github.commit", tctx.commit,
+		"github.job", tctx.job,
+		"run_id", tctx.runID)
+	h.tracer.startAt(runSpan.context(), "debounce wait", runStart).end(nil)
+	tctx.trace = runSpan.context()
+
+	resultPath, cleanupResult, err := h.createResultFile()
+	if err != nil {
+		logger.Warn("run without GH_RESULT_PATH", "err", err)
+	} else {
+		defer cleanupResult()
+		tctx.resultPath = resultPath
+	}
+
+	rec := h.history.begin(tctx)
+	dep := h.startDeployment(ctx, tctx, logger)
+	if dep != nil {
+		tctx.deploymentID = dep.id
+	}
+
+	policy := h.retryPolicy()
+	for attempt := 1; ; attempt++ {
+		if tctx.resultPath != "" {
+			// Only the last attempt's result counts.
+			_ = os.Remove(tctx.resultPath)
+		}
+		err := h.runAttempt(ctx, tctx, rec, steps, attempt)
+		runSpan.set("attempts", attempt)
+		if err == nil {
+			runSpan.end(nil)
+			h.collectArtifacts(rec, tctx, logger)
+			res := h.collectResult(rec, tctx, logger)
+			h.history.finish(rec, attempt, nil)
+			h.finishDeployment(ctx, dep, nil, res, logger)
+			h.recordSuccess(tctx, logger)
+			logger.Info("run finished",
+				"status", "success",
+				"exit_code", 0,
+				"attempts", attempt,
+				"duration_ms", time.Since(start).Milliseconds(),
+				"summary", res.summary())
+			return nil
+		}
+
+		finish := func(reason string) error {
+			runSpan.end(err)
+			h.collectArtifacts(rec, tctx, logger)
+			res := h.collectResult(rec, tctx, logger)
+			h.history.finish(rec, attempt, err)
+			h.finishDeployment(ctx, dep, err, res, logger)
+			logger.Error("run finished",
+				"status", "failure",
+				"exit_code", exitCode(err),
+				"attempts", attempt,
+				"duration_ms", time.Since(start).Milliseconds(),
+				"reason", reason,
+				"summary", res.summary(),
+				"err", err)
+			return err
+		}
+		if attempt >= policy.MaxAttempts {
+			return finish("attempts exhausted")
+		}
+		if !policy.retryable(err) {
+			return finish("not retryable")
+		}
+
+		backoff := policy.backoff(attempt)
+		logger.Warn("attempt failed, retrying",
+			"attempt", attempt,
+			"max_attempts", policy.MaxAttempts,
+			"backoff_ms", backoff.Milliseconds(),
+			"err", err)
+
+		resume := h.pause()
+		timer := time.NewTimer(backoff)
+		var stopped string
+		select {
+		case <-ctx.Done():
+			stopped = "shutting down"
+		case <-tctx.superseded:
+			stopped = "retry superseded by a newer trigger"
+		case <-timer.C:
+		}
+		timer.Stop()
+		resume()
+		if stopped != "" {
+			return finish(stopped)
+		}
+	}
+}
+
+// runAttempt runs the job's steps once with GitHub event context, appending
+// their output to rec. The first failing step without continue_on_error
+// fails the attempt; later steps don't run.
+func (h *repoHandler) runAttempt(
+	ctx context.Context,
+	tctx triggerContext,
+	rec *runRecord,
+	steps []*Step,
+	attempt int,
+) error {
+	logger := h.runLogger(tctx)
+
+	if h.sem != nil {
+		queueSpan := h.tracer.start(tctx.trace, "queue wait",
+			"attempt", attempt, "priority", h.repo.Priority)
+		err := h.acquireRunSlot(ctx, logger)
+		queueSpan.end(err)
+		if err != nil {
+			return err
+		}
+		defer h.sem.release()
+	}
+
+	secretEnv, cleanupSecrets, err := h.secretEnv()
+	if err != nil {
+		return err
+	}
+	defer cleanupSecrets()
+
+	if err := h.prepareRunDirs(tctx); err != nil {
+		return err
+	}
+
+	if len(steps) == 1 && steps[0].Name == "" {
+		logger.Info("attempt started",
+			"attempt", attempt,
+			"command", strings.Join(steps[0].Command, " "))
+	} else {
+		logger.Info("attempt started", "attempt", attempt, "steps", len(steps))
+	}
+
+	// The changed files are listed at most once per attempt, when the first
+	// step with a path condition is reached, so earlier steps can fetch.
+	var changed []string
+	changedOK, changedDone := false, false
+	changedFn := func() ([]string, bool) {
+		if !changedDone {
+			changedDone = true
+			files, err := h.changedPaths(ctx, tctx)
+			if err != nil {
+				logger.Info("changed files unknown, path conditions match", "err", err)
+			}
+			changed, changedOK = files, err == nil
+		}
+		return changed, changedOK
+	}
+
+	start := time.Now()
+	metrics.runStart(h.fullName)
+	err = nil
+	for _, step := range steps {
+		if reason := stepEnabled(step, tctx, changedFn); reason != "" {
+			logger.Info("step skipped", "attempt", attempt, "step", step.Name, "reason", reason)
+			h.history.addStep(rec, stepRecord{
+				Attempt: attempt,
+				Name:    step.Name,
+				Status:  runStatusSkipped,
+				Start:   time.Now(),
+			})
+			continue
+		}
+
+		stepErr := h.runStep(ctx, tctx, rec, step, attempt, secretEnv)
+		if stepErr == nil {
+			continue
+		}
+		if step.Name != "" {
+			stepErr = fmt.Errorf("step %s failed: %w", step.Name, stepErr)
+		}
+		if step.ContinueOnError {
+			logger.Warn("step failed, continuing", "attempt", attempt, "step", step.Name, "err", stepErr)
+			continue
+		}
+		err = stepErr
+		break
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	metrics.runEnd(h.fullName, result)
+
+	logger.Info("attempt finished",
+		"attempt", attempt,
+		"status", result,
+		"exit_code", exitCode(err),
+		"duration_ms", time.Since(start).Milliseconds())
+
+	return err
+}
+
+// runStep executes one step's command, appending its output to rec.
+func (h *repoHandler) runStep(
+	ctx context.Context,
+	tctx triggerContext,
+	rec *runRecord,
+	step *Step,
+	attempt int,
+	secretEnv []string,
+) error {
+	logger := h.runLogger(tctx)
+	if step.Name != "" {
+		logger = logger.With("step", step.Name)
+	}
+	command := step.Command
+
+	timeout := h.stepTimeout(step, tctx.branch)
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(cmdCtx, h.repo.Command[0], h.repo.Command[1:]...)
-	cmd.Dir = h.repo.WorkingDir
+	cmdSpan := h.tracer.start(tctx.trace, "command",
+		"attempt", attempt,
+		"step", step.Name,
+		"command", strings.Join(command, " "))
 
-	// Set environment variables with GitHub event context.
-	cmd.Env = append(os.Environ(),
-		"GH_EVENT="+tctx.event,
-		"GH_REPO="+h.fullName,
-		"GH_REF="+tctx.ref,
-		"GH_BRANCH="+tctx.branch,
-		"GH_COMMIT="+tctx.commit,
-		"GH_SENDER="+tctx.sender,
-	)
+	env := append(h.baseEnv(), h.branchEnv(tctx.branch)...)
+	env = append(env, h.contextEnv(tctx, attempt)...)
+	if step.Name != "" {
+		env = append(env, "GH_STEP="+step.Name)
+	}
+	env = append(env, secretEnv...)
+	if sc := cmdSpan.context(); sc.valid() {
+		// Scripts can parent their own spans under the command span.
+		env = append(env, "TRACEPARENT="+sc.traceparent())
+	}
+	var writable []string
+	if tctx.resultPath != "" {
+		writable = append(writable, filepath.Dir(tctx.resultPath))
+	}
+	cmd := h.buildCmd(cmdCtx, h.workingDir(tctx.branch), writable, command, env, timeout)
+	if h.repo.StdinPayload {
+		cmd.Stdin = bytes.NewReader(tctx.payload)
+	}
 
-	var buf bytes.Buffer
-	cmd.Stdout = &buf
-	cmd.Stderr = &buf
+	// Log each output line as its own record, tagged with the run id.
+	out := &lineWriter{emit: func(line string) {
+		line = h.redact(line)
+		logger.Info(line, "stream", "output")
+		h.history.appendOutput(rec, line)
+	}}
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	// Only named steps are recorded as steps; a plain command is the run.
+	stepIdx := -1
+	if step.Name == "" {
+		h.history.appendOutput(rec, fmt.Sprintf("==> attempt %d: %s",
+			attempt, strings.Join(command, " ")))
+	} else {
+		h.history.appendOutput(rec, fmt.Sprintf("==> attempt %d, step %s: %s",
+			attempt, step.Name, strings.Join(command, " ")))
+		stepIdx = h.history.addStep(rec, stepRecord{
+			Attempt: attempt,
+			Name:    step.Name,
+			Status:  runStatusRunning,
+			Start:   time.Now(),
+		})
+		logger.Info("step started",
+			"attempt", attempt,
+			"command", strings.Join(command, " "))
+	}
 
+	start := time.Now()
 	err := cmd.Run()
-	log.Printf("[%s] cmd: %s\n%s",
-		h.fullName,
-		strings.Join(h.repo.Command, " "),
-		buf.String())
+	out.Flush()
+	cmdSpan.set("exit_code", exitCode(err))
+	cmdSpan.end(err)
+	h.history.finishStep(rec, stepIdx, err)
+
+	if step.Name != "" {
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		logger.Info("step finished",
+			"attempt", attempt,
+			"status", result,
+			"exit_code", exitCode(err),
+			"duration_ms", time.Since(start).Milliseconds())
+	}
 
 	if err != nil {
 		return fmt.Errorf("command failed: %w", err)
 	}
+	return nil
+}
+
+// runLogger returns a logger tagged with the repo and run id.
+func (h *repoHandler) runLogger(tctx triggerContext) *slog.Logger {
+	return h.logger().With("run_id", tctx.runID)
+}
+
+// logger returns a logger tagged with the repo.
+func (h *repoHandler) logger() *slog.Logger {
+	return slog.Default().With("repo", h.fullName)
+}
+
+// exitCode extracts a command's exit code: 0 on success, -1 if the command
+// did not exit normally (start failure, signal, timeout).
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// contextEnv returns the GH_* variables describing a run.
+func (h *repoHandler) contextEnv(tctx triggerContext, attempt int) []string {
+	env := []string{
+		"GH_EVENT=" + tctx.event,
+		"GH_REPO=" + h.fullName,
+		"GH_REF=" + tctx.ref,
+		"GH_BRANCH=" + tctx.branch,
+		"GH_COMMIT=" + tctx.commit,
+		"GH_PREVIOUS_COMMIT=" + tctx.previousCommit,
+		"GH_SENDER=" + tctx.sender,
+		"GH_JOB=" + tctx.job,
+		"GH_ATTEMPT=" + strconv.Itoa(attempt),
+	}
+	if environment := h.environmentFor(tctx.job); environment != "" {
+		env = append(env, "GH_ENVIRONMENT="+environment)
+	}
+	if tctx.deploymentID != 0 {
+		env = append(env, "GH_DEPLOYMENT_ID="+strconv.FormatInt(tctx.deploymentID, 10))
+	}
+	if h.dataDir != "" {
+		env = append(env,
+			"GH_CACHE_DIR="+h.cacheDir(tctx.job),
+			"GH_ARTIFACTS_DIR="+h.artifactsDir(tctx.runID))
+	}
+	if tctx.resultPath != "" {
+		env = append(env, "GH_RESULT_PATH="+tctx.resultPath)
+	}
+	return append(env, eventEnv(tctx)...)
+}
+
+// acquireRunSlot waits for a global run slot, logging queue position and wait
+// time. It is a no-op without a global run limit.
+func (h *repoHandler) acquireRunSlot(ctx context.Context, logger *slog.Logger) error {
+	if h.sem == nil {
+		return nil
+	}
+
+	defer h.pause()()
+	start := time.Now()
+	queued := false
+	err := h.sem.acquire(ctx, h.repo.Priority, func(position, waiting int) {
+		queued = true
+		metrics.queueEnter(h.fullName)
+		logger.Info("waiting for run slot",
+			"queue_position", position,
+			"queue_length", waiting,
+			"priority", h.repo.Priority)
+	})
+	if !queued {
+		return err
+	}
 
+	wait := time.Since(start)
+	metrics.queueLeave(h.fullName, wait)
+	if err != nil {
+		return fmt.Errorf("wait for run slot: %w", err)
+	}
+	logger.Info("acquired run slot", "queue_wait_ms", wait.Milliseconds())
 	return nil
 }
 
-// debouncer coalesces rapid triggers and runs a single worker call.
+// previousCommit returns the last commit tctx's job ran successfully on its
+// branch, or "".
+func (h *repoHandler) previousCommit(tctx triggerContext) string {
+	if tctx.branch == "" {
+		return ""
+	}
+	rec, _ := h.state.lastSuccess(h.fullName, tctx.job, tctx.branch)
+	return rec.Commit
+}
+
+// recordSuccess remembers tctx's commit as the last one its job ran
+// successfully on its branch. A state file write failure is logged; the run
+// still succeeded.
+func (h *repoHandler) recordSuccess(tctx triggerContext, logger *slog.Logger) {
+	if tctx.branch == "" || tctx.commit == "" {
+		return
+	}
+
+	err := h.state.recordSuccess(h.fullName, tctx.job, tctx.branch, successRecord{
+		Commit: tctx.commit,
+		RunID:  tctx.runID,
+		At:     time.Now(),
+	})
+	if err != nil {
+		logger.Error("save state failed", "err", err)
+	}
+}
+
+// debouncer coalesces rapid triggers and runs worker calls serially.
+//
+// Triggers are coalesced per job: a burst of pushes collapses into one run
+// with the newest context, while a scheduled job queued in the same window
+// still gets its own run.
 type debouncer struct {
-	quiet     time.Duration
-	triggerCh chan triggerContext
-	runFn     func(triggerContext) error
+	*webhook.Debouncer[triggerContext]
 }
 
 // triggerContext carries webhook context for debounced execution.
@@ -384,15 +1538,54 @@ type triggerContext struct {
 	branch string
 	commit string
 	sender string
+
+	// job is the schedule or trigger name, empty for the repo's
+	// webhook command.
+	job string
+
+	// superseded is closed when a newer trigger for the same job arrives
+	// while this one is running. nil for runs outside the debouncer.
+	superseded <-chan struct{}
+
+	// deliveryID is the X-GitHub-Delivery header, if any.
+	deliveryID string
+	// runID correlates all log records of one run; assigned by runCommand.
+	runID string
+	// trace is the span the run belongs to (the webhook delivery span);
+	// invalid starts a new trace.
+	trace spanContext
+	// enqueuedAt is when the trigger entered the debouncer.
+	enqueuedAt time.Time
+	// deploymentID is the GitHub deployment of the run, if any.
+	deploymentID int64
+
+	// payload is the verified JSON payload of the delivery, if any.
+	payload []byte
+	// resultPath is the run's GH_RESULT_PATH file; set by runCommand.
+	resultPath string
+
+	// releaseTag is the tag of a release event.
+	releaseTag string
+	// workflowRunID is the workflow run id of a workflow_run event.
+	workflowRunID int64
+
+	// force runs the trigger even if its commit already succeeded or
+	// deploys are frozen.
+	force bool
+	// previousCommit is the last commit the job ran successfully on the
+	// branch; set by runCommand.
+	previousCommit string
 }
 
-// newDebouncer constructs a debouncer with buffered trigger channel.
+// newDebouncer constructs a debouncer with an empty pending queue. runFn
+// failures are logged by runFn and do not stop the loop.
 func newDebouncer(quiet time.Duration, runFn func(triggerContext) error) *debouncer {
-	return &debouncer{
-		quiet:     quiet,
-		triggerCh: make(chan triggerContext, 1),
-		runFn:     runFn,
-	}
+	return &debouncer{webhook.NewDebouncer(quiet,
+		func(tctx triggerContext) string { return tctx.job },
+		func(tctx triggerContext, superseded <-chan struct{}) error {
+			tctx.superseded = superseded
+			return runFn(tctx)
+		})}
 }
 
 // trigger requests a run with minimal context (for startup).
@@ -402,78 +1595,20 @@ func (d *debouncer) trigger() {
 
 // triggerWithContext requests a run with full webhook context.
 func (d *debouncer) triggerWithContext(event, ref, branch, commit, sender string) {
-	ctx := triggerContext{
+	d.enqueue(triggerContext{
 		event:  event,
 		ref:    ref,
 		branch: branch,
 		commit: commit,
 		sender: sender,
-	}
-	select {
-	case d.triggerCh <- ctx:
-	default:
-	}
-}
-
-// run listens for triggers, waits for quiet period, then executes runFn.
-func (d *debouncer) run(ctx context.Context) {
-	var timer *time.Timer
-	var timerC <-chan time.Time
-	var pending *triggerContext
-
-	for {
-		select {
-		case <-ctx.Done():
-			if timer != nil {
-				timer.Stop()
-			}
-			return
-		case tctx := <-d.triggerCh:
-			pending = &tctx
-			// Restart quiet timer on every trigger to coalesce bursts.
-			if timer != nil {
-				if !timer.Stop() {
-					<-timer.C
-				}
-			}
-			timer = time.NewTimer(d.quiet)
-			timerC = timer.C
-		case <-timerC:
-			timerC = nil
-			if timer != nil {
-				timer.Stop()
-				timer = nil
-			}
-			if pending == nil {
-				continue
-			}
-
-			// Capture context and clear pending.
-			tctx := *pending
-			pending = nil
-
-			// Run worker; failures are logged but do not stop loop.
-			if err := d.runFn(tctx); err != nil {
-				log.Printf("command failed: %v", err)
-			}
-		}
-	}
+	})
 }
 
-// verifySignature checks GitHub X-Hub-Signature-256 against body.
-func verifySignature(secret, body []byte, header string) bool {
-	if !strings.HasPrefix(header, "sha256=") {
-		return false
+// enqueue adds tctx to the pending queue, replacing any pending trigger for
+// the same job.
+func (d *debouncer) enqueue(tctx triggerContext) {
+	if tctx.enqueuedAt.IsZero() {
+		tctx.enqueuedAt = time.Now()
 	}
-	hexSig := strings.TrimPrefix(header, "sha256=")
-	sig, err := hex.DecodeString(hexSig)
-	if err != nil {
-		return false
-	}
-
-	mac := hmac.New(sha256.New, secret)
-	_, _ = mac.Write(body)
-	expected := mac.Sum(nil)
-
-	return hmac.Equal(expected, sig)
+	d.Enqueue(tctx)
 }
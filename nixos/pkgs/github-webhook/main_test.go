@@ -16,21 +16,9 @@ import (
 	"sync"
 	"testing"
 	"time"
-)
-
-// TestVerifySignature checks happy-path HMAC validation.
-func TestVerifySignature(t *testing.T) {
-	secret := []byte("supersecret")
-	body := []byte(`{"ref":"refs/heads/master"}`)
-
-	mac := hmac.New(sha256.New, secret)
-	mac.Write(body)
-	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
 
-	if !verifySignature(secret, body, expected) {
-		t.Fatalf("expected signature to validate")
-	}
-}
+	"github.com/phlip9/github-webhook/webhook"
+)
 
 // TestReadSecretExpandsCredentialsDirectory verifies %d/ expansion and trimming.
 func TestReadSecretExpandsCredentialsDirectory(t *testing.T) {
@@ -74,7 +62,7 @@ func (r failReader) Read(_ []byte) (int, error) {
 // TestHandleWebhookTriggersSync ensures push webhook schedules a sync.
 func TestHandleWebhookTriggersSync(t *testing.T) {
 	secret := []byte("supersecret")
-	body := []byte(`{"ref":"refs/heads/master","after":"abc123","repository":{"full_name":"test/repo"},"sender":{"login":"alice"}}`)
+	body := []byte(`{"ref":"refs/heads/master","after":"abc1230000000000000000000000000000000000","repository":{"full_name":"test/repo"},"sender":{"login":"alice"}}`)
 
 	secretPath := filepath.Join(t.TempDir(), "secret")
 	if err := os.WriteFile(secretPath, secret, 0o600); err != nil {
@@ -86,7 +74,7 @@ func TestHandleWebhookTriggersSync(t *testing.T) {
 		Repos: map[string]*Repo{
 			"test/repo": {
 				SecretPath:   secretPath,
-				Branches:     []string{"master"},
+				Branches:     []Branch{{Name: "master"}},
 				Command:      []string{"true"},
 				WorkingDir:   t.TempDir(),
 				QuietMs:      5,
@@ -117,7 +105,7 @@ func TestHandleWebhookTriggersSync(t *testing.T) {
 		runs.Done()
 		return nil
 	})
-	go handler.deb.run(ctx)
+	go handler.deb.Run(ctx)
 
 	app.handlers["test/repo"] = handler
 
@@ -164,7 +152,7 @@ func TestHandleWebhookPing(t *testing.T) {
 		Repos: map[string]*Repo{
 			"test/repo": {
 				SecretPath: secretPath,
-				Branches:   []string{"master"},
+				Branches:   []Branch{{Name: "master"}},
 				Command:    []string{"true"},
 				WorkingDir: t.TempDir(),
 			},
@@ -237,7 +225,7 @@ func TestHandleWebhookWrongBranch(t *testing.T) {
 		Repos: map[string]*Repo{
 			"test/repo": {
 				SecretPath: secretPath,
-				Branches:   []string{"master"},
+				Branches:   []Branch{{Name: "master"}},
 				Command:    []string{"true"},
 				WorkingDir: t.TempDir(),
 			},
@@ -272,6 +260,28 @@ func TestHandleWebhookWrongBranch(t *testing.T) {
 	}
 }
 
+// TestRouteRejectsInvalidCommit drops pushes whose commit is not a full
+// object id, since it reaches git's command line.
+func TestRouteRejectsInvalidCommit(t *testing.T) {
+	secret := []byte("supersecret")
+	a := &app{handlers: map[string]*repoHandler{"test/repo": {
+		fullName: "test/repo",
+		repo:     Repo{Branches: []Branch{{Name: "master"}}, Command: []string{"true"}},
+		secret:   secret,
+	}}}
+
+	for _, commit := range []string{"", "abc123", "--output=/tmp/pwned", strings.Repeat("A", 40)} {
+		body := []byte(`{"ref":"refs/heads/master","after":"` + commit + `","repository":{"full_name":"test/repo"}}`)
+		header := http.Header{}
+		header.Set("X-GitHub-Event", "push")
+		header.Set("X-Hub-Signature-256", webhook.Sign(secret, body))
+		d, err := a.route(t.Context(), spanContext{}, header, body)
+		if err == nil || len(d.runs) != 0 {
+			t.Errorf("push of commit %q was routed: %+v", commit, d)
+		}
+	}
+}
+
 // TestIntegrationFetchReset spins up a temp git remote/working tree and ensures
 // a push webhook clears local dirty state via fetch+reset.
 func TestIntegrationFetchReset(t *testing.T) {
@@ -312,7 +322,7 @@ func TestIntegrationFetchReset(t *testing.T) {
 		Repos: map[string]*Repo{
 			"test/repo": {
 				SecretPath:   secretPath,
-				Branches:     []string{"master"},
+				Branches:     []Branch{{Name: "master"}},
 				Command:      []string{"bash", "-c", "git fetch upstream && git reset --hard upstream/master"},
 				WorkingDir:   work,
 				QuietMs:      20,
@@ -347,7 +357,7 @@ func TestIntegrationFetchReset(t *testing.T) {
 		}()
 		return handler.runCommand(ctx, tctx)
 	})
-	go handler.deb.run(ctx)
+	go handler.deb.Run(ctx)
 	app.handlers["test/repo"] = handler
 
 	// Run startup command.
@@ -360,7 +370,7 @@ func TestIntegrationFetchReset(t *testing.T) {
 		t.Fatalf("dirty write: %v", err)
 	}
 
-	body := []byte(`{"ref":"refs/heads/master","after":"abc123","repository":{"full_name":"test/repo"},"sender":{"login":"alice"}}`)
+	body := []byte(`{"ref":"refs/heads/master","after":"abc1230000000000000000000000000000000000","repository":{"full_name":"test/repo"},"sender":{"login":"alice"}}`)
 	mac := hmac.New(sha256.New, secret)
 	mac.Write(body)
 	sig := "sha256=" + hex.EncodeToString(mac.Sum(nil))
@@ -462,13 +472,13 @@ func TestMultiRepoRouting(t *testing.T) {
 		Repos: map[string]*Repo{
 			"owner/repo1": {
 				SecretPath: secretPath,
-				Branches:   []string{"main"},
+				Branches:   []Branch{{Name: "main"}},
 				Command:    []string{"echo", "repo1"},
 				WorkingDir: t.TempDir(),
 			},
 			"owner/repo2": {
 				SecretPath: secretPath,
-				Branches:   []string{"master"},
+				Branches:   []Branch{{Name: "master"}},
 				Command:    []string{"echo", "repo2"},
 				WorkingDir: t.TempDir(),
 			},
@@ -498,7 +508,7 @@ func TestMultiRepoRouting(t *testing.T) {
 		mu.Unlock()
 		return nil
 	})
-	go handler1.deb.run(ctx)
+	go handler1.deb.Run(ctx)
 	app.handlers["owner/repo1"] = handler1
 
 	// Setup repo2 handler.
@@ -514,11 +524,11 @@ func TestMultiRepoRouting(t *testing.T) {
 		mu.Unlock()
 		return nil
 	})
-	go handler2.deb.run(ctx)
+	go handler2.deb.Run(ctx)
 	app.handlers["owner/repo2"] = handler2
 
 	// Send webhook for repo1.
-	body1 := []byte(`{"ref":"refs/heads/main","repository":{"full_name":"owner/repo1"},"sender":{"login":"alice"}}`)
+	body1 := []byte(`{"ref":"refs/heads/main","after":"1111111111111111111111111111111111111111","repository":{"full_name":"owner/repo1"},"sender":{"login":"alice"}}`)
 	mac1 := hmac.New(sha256.New, secret)
 	mac1.Write(body1)
 
@@ -534,7 +544,7 @@ func TestMultiRepoRouting(t *testing.T) {
 	}
 
 	// Send webhook for repo2.
-	body2 := []byte(`{"ref":"refs/heads/master","repository":{"full_name":"owner/repo2"},"sender":{"login":"bob"}}`)
+	body2 := []byte(`{"ref":"refs/heads/master","after":"2222222222222222222222222222222222222222","repository":{"full_name":"owner/repo2"},"sender":{"login":"bob"}}`)
 	mac2 := hmac.New(sha256.New, secret)
 	mac2.Write(body2)
 
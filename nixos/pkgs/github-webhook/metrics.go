@@ -0,0 +1,142 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// runMetrics tracks command run counters and gauges for /metrics.
+type runMetrics struct {
+	mu sync.Mutex
+	// runs maps {repo, result} -> completed attempts.
+	runs map[[2]string]uint64
+	// running maps repo -> attempts currently executing.
+	running map[string]int
+	// queued maps repo -> attempts waiting for a global run slot.
+	queued map[string]int
+	// queueWaitSum/queueWaitCount accumulate time spent waiting for a slot.
+	queueWaitSum   map[string]float64
+	queueWaitCount map[string]uint64
+}
+
+// metrics is the process-wide run metrics registry.
+var metrics = newRunMetrics()
+
+// newRunMetrics returns an empty registry.
+func newRunMetrics() *runMetrics {
+	return &runMetrics{
+		runs:           make(map[[2]string]uint64),
+		running:        make(map[string]int),
+		queued:         make(map[string]int),
+		queueWaitSum:   make(map[string]float64),
+		queueWaitCount: make(map[string]uint64),
+	}
+}
+
+// queueEnter records an attempt starting to wait for a run slot.
+func (m *runMetrics) queueEnter(repo string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queued[repo]++
+}
+
+// queueLeave records an attempt done waiting after wait.
+func (m *runMetrics) queueLeave(repo string, wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queued[repo]--
+	m.queueWaitSum[repo] += wait.Seconds()
+	m.queueWaitCount[repo]++
+}
+
+// runStart records an attempt starting to execute.
+func (m *runMetrics) runStart(repo string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running[repo]++
+}
+
+// runEnd records an attempt finishing with result "success" or "failure".
+func (m *runMetrics) runEnd(repo, result string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running[repo]--
+	m.runs[[2]string{repo, result}]++
+}
+
+// writeTo renders metrics in the Prometheus text exposition format.
+func (m *runMetrics) writeTo(w io.Writer, sem *runSemaphore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP github_webhook_runs_total Completed command attempts.")
+	fmt.Fprintln(w, "# TYPE github_webhook_runs_total counter")
+	runKeys := make([][2]string, 0, len(m.runs))
+	for k := range m.runs {
+		runKeys = append(runKeys, k)
+	}
+	slices.SortFunc(runKeys, func(a, b [2]string) int {
+		return cmp.Or(cmp.Compare(a[0], b[0]), cmp.Compare(a[1], b[1]))
+	})
+	for _, k := range runKeys {
+		fmt.Fprintf(w, "github_webhook_runs_total{repo=%q,result=%q} %d\n",
+			k[0], k[1], m.runs[k])
+	}
+
+	writeRepoGauge(w, "github_webhook_runs_running",
+		"Command attempts currently executing.", m.running)
+	writeRepoGauge(w, "github_webhook_run_queue_waiting",
+		"Command attempts waiting for a global run slot.", m.queued)
+
+	fmt.Fprintln(w, "# HELP github_webhook_run_queue_wait_seconds Time spent waiting for a global run slot.")
+	fmt.Fprintln(w, "# TYPE github_webhook_run_queue_wait_seconds summary")
+	for _, repo := range sortedKeys(m.queueWaitCount) {
+		fmt.Fprintf(w, "github_webhook_run_queue_wait_seconds_sum{repo=%q} %g\n",
+			repo, m.queueWaitSum[repo])
+		fmt.Fprintf(w, "github_webhook_run_queue_wait_seconds_count{repo=%q} %d\n",
+			repo, m.queueWaitCount[repo])
+	}
+
+	if sem != nil {
+		active, waiting := sem.stats()
+		fmt.Fprintln(w, "# HELP github_webhook_run_slots Global run slot limit.")
+		fmt.Fprintln(w, "# TYPE github_webhook_run_slots gauge")
+		fmt.Fprintf(w, "github_webhook_run_slots %d\n", sem.limit)
+		fmt.Fprintln(w, "# HELP github_webhook_run_slots_active Global run slots in use.")
+		fmt.Fprintln(w, "# TYPE github_webhook_run_slots_active gauge")
+		fmt.Fprintf(w, "github_webhook_run_slots_active %d\n", active)
+		fmt.Fprintln(w, "# HELP github_webhook_run_slots_waiting Attempts queued for a global run slot.")
+		fmt.Fprintln(w, "# TYPE github_webhook_run_slots_waiting gauge")
+		fmt.Fprintf(w, "github_webhook_run_slots_waiting %d\n", waiting)
+	}
+}
+
+// writeRepoGauge renders a per-repo gauge.
+func writeRepoGauge(w io.Writer, name, help string, values map[string]int) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	for _, repo := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s{repo=%q} %d\n", name, repo, values[repo])
+	}
+}
+
+// sortedKeys returns map keys in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// handleMetrics serves run metrics in the Prometheus text format.
+func (a *app) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.writeTo(w, a.sem)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statusInterval is how often the systemd STATUS= text is refreshed.
+const statusInterval = 5 * time.Second
+
+// notifier sends sd_notify(3) messages to systemd over $NOTIFY_SOCKET:
+// READY=1 once the listener is bound, STATUS= listing repos with runs in
+// progress, paused, pending or frozen, and WATCHDOG=1 every half
+// $WATCHDOG_USEC. nil (not started by a Type=notify unit) drops them.
+type notifier struct {
+	conn *net.UnixConn
+	// watchdog is the interval between WATCHDOG=1 pings, half of
+	// $WATCHDOG_USEC. 0 disables the watchdog.
+	watchdog time.Duration
+}
+
+// newNotifier connects to $NOTIFY_SOCKET, or returns nil if it is unset.
+func newNotifier() (*notifier, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil, nil
+	}
+	// A leading "@" names a socket in the abstract namespace.
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("notify socket: %w", err)
+	}
+
+	n := &notifier{conn: conn}
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+		// WATCHDOG_PID, if set, names the process systemd watches.
+		if pid := os.Getenv("WATCHDOG_PID"); pid == "" || pid == strconv.Itoa(os.Getpid()) {
+			n.watchdog = time.Duration(usec) * time.Microsecond / 2
+		}
+	}
+	return n, nil
+}
+
+// send sends one notification, e.g. "READY=1". Errors are logged; systemd
+// going away must not take the daemon down.
+func (n *notifier) send(state string) {
+	if n == nil {
+		return
+	}
+	if _, err := n.conn.Write([]byte(state)); err != nil {
+		slog.Warn("sd_notify failed", "state", state, "err", err)
+	}
+}
+
+// notifyLoop refreshes STATUS= and, with a watchdog, pings it while every
+// debouncer loop is healthy. An unhealthy loop withholds pings, so systemd
+// restarts the daemon once WatchdogSec passes.
+func (a *app) notifyLoop(ctx context.Context, n *notifier) {
+	if n == nil {
+		return
+	}
+
+	interval := statusInterval
+	if n.watchdog > 0 {
+		interval = min(interval, n.watchdog)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastStatus string
+	for {
+		if status := "STATUS=" + a.statusText(); status != lastStatus {
+			n.send(status)
+			lastStatus = status
+		}
+		if n.watchdog > 0 {
+			if stuck := a.unhealthyRepos(interval / 2); len(stuck) == 0 {
+				n.send("WATCHDOG=1")
+			} else {
+				slog.Error("debouncer unresponsive, withholding watchdog ping",
+					"repos", strings.Join(stuck, ","))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// statusText summarizes the repos with runs in progress, pending or frozen.
+func (a *app) statusText() string {
+	var running, paused, debouncing, frozen []string
+	for _, h := range a.handlerList() {
+		switch h.runState() {
+		case repoStateRunning:
+			running = append(running, h.fullName)
+		case repoStatePaused:
+			paused = append(paused, h.fullName)
+		case repoStateDebouncing:
+			debouncing = append(debouncing, h.fullName)
+		case repoStateFrozen:
+			frozen = append(frozen, h.fullName)
+		}
+	}
+
+	var parts []string
+	if len(running) > 0 {
+		parts = append(parts, fmt.Sprintf("%d running: %s",
+			len(running), strings.Join(running, ", ")))
+	}
+	if len(paused) > 0 {
+		parts = append(parts, fmt.Sprintf("%d paused: %s",
+			len(paused), strings.Join(paused, ", ")))
+	}
+	if len(debouncing) > 0 {
+		parts = append(parts, fmt.Sprintf("%d pending: %s",
+			len(debouncing), strings.Join(debouncing, ", ")))
+	}
+	if len(frozen) > 0 {
+		parts = append(parts, fmt.Sprintf("%d frozen: %s",
+			len(frozen), strings.Join(frozen, ", ")))
+	}
+	if len(parts) == 0 {
+		return "idle"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// unhealthyRepos returns the repos with a debouncer loop that did not
+// respond within timeout. All loops are probed concurrently, so one check
+// takes at most timeout however many repos there are.
+func (a *app) unhealthyRepos(timeout time.Duration) []string {
+	handlers := a.handlerList()
+	deadline := time.Now().Add(timeout)
+	stuck := make([]atomic.Bool, len(handlers))
+	var wg sync.WaitGroup
+	for i, h := range handlers {
+		for _, d := range h.debouncers() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !d.Healthy(time.Until(deadline)) {
+					stuck[i].Store(true)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	var names []string
+	for i, h := range handlers {
+		if stuck[i].Load() {
+			names = append(names, h.fullName)
+		}
+	}
+	return names
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestNotifyLoop reports status and pings the watchdog only while every
+// debouncer loop is healthy.
+func TestNotifyLoop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify")
+	sock, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer sock.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	n, err := newNotifier()
+	if err != nil {
+		t.Fatalf("newNotifier: %v", err)
+	}
+	if n.watchdog != 50*time.Millisecond {
+		t.Fatalf("watchdog interval = %s, want 50ms", n.watchdog)
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	busy := &repoHandler{fullName: "test/busy", history: newRunHistory()}
+	busy.deb = newDebouncer(0, func(tctx triggerContext) error {
+		busy.history.begin(tctx)
+		close(started)
+		<-release
+		return nil
+	})
+	defer close(release)
+	a := &app{handlers: map[string]*repoHandler{"test/busy": busy}}
+	go busy.deb.Run(t.Context())
+	busy.deb.trigger()
+
+	<-started
+	go a.notifyLoop(t.Context(), n)
+
+	read := func() []string {
+		t.Helper()
+		var msgs []string
+		buf := make([]byte, 1024)
+		deadline := time.Now().Add(200 * time.Millisecond)
+		for {
+			_ = sock.SetReadDeadline(deadline)
+			k, err := sock.Read(buf)
+			if err != nil {
+				return msgs
+			}
+			msgs = append(msgs, string(buf[:k]))
+		}
+	}
+	// A loop busy in a run counts as healthy.
+	msgs := read()
+	if !slices.Contains(msgs, "STATUS=1 running: test/busy") || !slices.Contains(msgs, "WATCHDOG=1") {
+		t.Fatalf("messages = %q, want status and watchdog pings", msgs)
+	}
+
+	// A debouncer whose loop never answers is reported.
+	stuck := &app{handlers: map[string]*repoHandler{
+		"test/busy":  busy,
+		"test/stuck": {fullName: "test/stuck", deb: newDebouncer(0, nil)},
+	}}
+	if got := stuck.unhealthyRepos(10 * time.Millisecond); !slices.Equal(got, []string{"test/stuck"}) {
+		t.Fatalf("unhealthyRepos = %v, want [test/stuck]", got)
+	}
+
+	// Stuck loops are probed concurrently, within one timeout.
+	want := []string{"test/stuck"}
+	for i := range 20 {
+		name := fmt.Sprintf("test/stuck%02d", i)
+		stuck.handlers[name] = &repoHandler{fullName: name, deb: newDebouncer(0, nil)}
+		want = append(want, name)
+	}
+	begin := time.Now()
+	got := stuck.unhealthyRepos(50 * time.Millisecond)
+	if !slices.Equal(got, want) {
+		t.Fatalf("unhealthyRepos = %v, want %v", got, want)
+	}
+	if elapsed := time.Since(begin); elapsed > 500*time.Millisecond {
+		t.Fatalf("unhealthyRepos took %s for 21 stuck repos", elapsed)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+// errPayloadTooLarge rejects deliveries over the size limit.
+var errPayloadTooLarge = &webhook.StatusError{Status: http.StatusRequestEntityTooLarge, Msg: "payload too large"}
+
+// maxBodyBytes returns the repo's delivery size limit.
+func (h *repoHandler) maxBodyBytes() int64 {
+	if h.repo.MaxBodyBytes > 0 {
+		return h.repo.MaxBodyBytes
+	}
+	return webhook.DefaultMaxBodyBytes
+}
+
+// maxBodyBytes returns the largest size limit of any repo, which bounds
+// reading a delivery before its repo is known.
+func (a *app) maxBodyBytes() int64 {
+	limit := int64(0)
+	for _, h := range a.handlerList() {
+		limit = max(limit, h.maxBodyBytes())
+	}
+	if limit == 0 {
+		return webhook.DefaultMaxBodyBytes
+	}
+	return limit
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+// TestHandleWebhookFormPayload accepts form-encoded deliveries, verifying the
+// signature over the raw body, and rejects oversized ones with 413.
+func TestHandleWebhookFormPayload(t *testing.T) {
+	secret := []byte("supersecret")
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Branches:     []Branch{{Name: "master"}},
+			MaxBodyBytes: 512,
+		},
+		secret: secret,
+	}
+	triggers := make(chan triggerContext, 1)
+	handler.deb = newDebouncer(time.Millisecond, func(tctx triggerContext) error {
+		triggers <- tctx
+		return nil
+	})
+	go handler.deb.Run(t.Context())
+	a := &app{handlers: map[string]*repoHandler{"test/repo": handler}}
+
+	post := func(contentType string, body []byte) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", webhook.Sign(secret, body))
+		rr := httptest.NewRecorder()
+		a.handleWebhook(rr, req)
+		return rr.Code
+	}
+
+	payload := `{"ref":"refs/heads/master","after":"abc1230000000000000000000000000000000000","repository":{"full_name":"test/repo"}}`
+	form := []byte(url.Values{"payload": {payload}}.Encode())
+	if code := post(webhook.ContentTypeForm, form); code != http.StatusAccepted {
+		t.Fatalf("form delivery: got %d, want 202", code)
+	}
+	select {
+	case tctx := <-triggers:
+		if tctx.commit != "abc1230000000000000000000000000000000000" {
+			t.Errorf("commit = %q, want abc1230000000000000000000000000000000000", tctx.commit)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("form delivery did not trigger a run")
+	}
+
+	if code := post(webhook.ContentTypeForm, []byte("zen=hi")); code != http.StatusBadRequest {
+		t.Errorf("form without payload: got %d, want 400", code)
+	}
+
+	// Over the repo limit but under the read bound: rejected by route.
+	padded := strings.Replace(payload, `"after"`, `"pad":"`+strings.Repeat("x", 600)+`","after"`, 1)
+	if code := post(webhook.ContentTypeJSON, []byte(padded)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized delivery: got %d, want 413", code)
+	}
+
+	// Over the largest repo limit: rejected while reading.
+	huge := bytes.Repeat([]byte("x"), 1024)
+	if code := post(webhook.ContentTypeJSON, huge); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("huge delivery: got %d, want 413", code)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// pollTimeout bounds a single `git ls-remote` invocation.
+const pollTimeout = time.Minute
+
+// pollLoop polls the configured remote every interval until ctx is done.
+func (h *repoHandler) pollLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.pollOnce(ctx); err != nil {
+				h.logger().Warn("poll failed", "err", err)
+			}
+		}
+	}
+}
+
+// pollOnce checks tracked branch heads on the remote and triggers a synthetic
+// "poll" event for each head that differs from the last successful commit.
+func (h *repoHandler) pollOnce(ctx context.Context) error {
+	if h.repo.RemoteURL == "" {
+		return fmt.Errorf("poll_interval_ms set but remote_url is empty")
+	}
+
+	pollCtx, cancel := context.WithTimeout(ctx, pollTimeout)
+	defer cancel()
+
+	heads, err := lsRemoteHeads(pollCtx, h.repo.RemoteURL)
+	if err != nil {
+		return err
+	}
+
+	for _, branch := range h.repo.branchNames() {
+		commit, ok := heads[branch]
+		if !ok || !h.shouldPollTrigger(branch, commit) {
+			continue
+		}
+
+		h.logger().Info("poll: branch moved", "branch", branch, "commit", commit)
+		h.debouncerFor(branch).triggerWithContext("poll", "refs/heads/"+branch, branch, commit, "")
+	}
+
+	return nil
+}
+
+// shouldPollTrigger reports whether a polled head needs a run. A head is only
+// triggered once, so a failing command is not retried on every poll.
+func (h *repoHandler) shouldPollTrigger(branch, commit string) bool {
+	if h.previousCommit(triggerContext{branch: branch}) == commit {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.lastPolled[branch] == commit {
+		return false
+	}
+	if h.lastPolled == nil {
+		h.lastPolled = make(map[string]string)
+	}
+	h.lastPolled[branch] = commit
+	return true
+}
+
+// runStartup queues the startup runs. With remote_url set, each tracked
+// branch head is resolved first and queued on its branch's debouncer, where
+// heads that already succeeded are skipped; otherwise the command is queued
+// once, unless every working dir is checked out at a commit that already
+// succeeded.
+func (h *repoHandler) runStartup(ctx context.Context) {
+	if h.repo.RemoteURL == "" {
+		if h.checkoutsCurrent(ctx) {
+			h.logger().Info("startup: checkout already at its last successful commit, skipping")
+			return
+		}
+		h.logger().Info("queueing startup command")
+		h.enqueue(triggerContext{event: "startup"})
+		return
+	}
+
+	lsCtx, cancel := context.WithTimeout(ctx, pollTimeout)
+	heads, err := lsRemoteHeads(lsCtx, h.repo.RemoteURL)
+	cancel()
+	if err != nil {
+		h.logger().Warn("startup: resolve branch heads failed, running anyway", "err", err)
+		h.enqueue(triggerContext{event: "startup"})
+		return
+	}
+
+	for _, branch := range h.repo.branchNames() {
+		commit, ok := heads[branch]
+		if !ok {
+			h.logger().Warn("startup: branch not found on remote", "branch", branch)
+			continue
+		}
+		h.logger().Info("queueing startup command", "branch", branch, "commit", commit)
+		// Already-deployed heads are skipped by runCommand.
+		h.enqueue(triggerContext{
+			event:  "startup",
+			ref:    "refs/heads/" + branch,
+			branch: branch,
+			commit: commit,
+		})
+	}
+}
+
+// checkoutsCurrent reports whether each tracked branch's working dir has HEAD
+// at the last successful commit of a branch using that dir. Repos without
+// tracked branches, or with a dir that is not a git checkout, are never
+// current.
+func (h *repoHandler) checkoutsCurrent(ctx context.Context) bool {
+	succeeded := make(map[string]map[string]bool) // working dir -> commits
+	for _, branch := range h.repo.branchNames() {
+		dir := h.workingDir(branch)
+		if succeeded[dir] == nil {
+			succeeded[dir] = make(map[string]bool)
+		}
+		if rec, ok := h.state.lastSuccess(h.fullName, "", branch); ok {
+			succeeded[dir][rec.Commit] = true
+		}
+	}
+	if len(succeeded) == 0 {
+		return false
+	}
+
+	for _, dir := range sortedKeys(succeeded) {
+		head, err := h.checkoutHead(ctx, dir)
+		if err != nil {
+			h.logger().Warn("startup: resolve checkout head failed, running anyway",
+				"working_dir", dir, "err", err)
+			return false
+		}
+		if !succeeded[dir][head] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkoutHead returns the commit checked out in the git working dir dir.
+func (h *repoHandler) checkoutHead(ctx context.Context, dir string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, pollTimeout)
+	defer cancel()
+
+	cmd := h.gitCmd(ctx, dir, pollTimeout, "rev-parse", "--verify", "HEAD")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse: %w: %s",
+			err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// lsRemoteHeads returns branch -> commit for all heads on the remote.
+func lsRemoteHeads(ctx context.Context, url string) (map[string]string, error) {
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--heads", url)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-remote: %w: %s",
+			err, strings.TrimSpace(stderr.String()))
+	}
+
+	heads := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		commit, ref, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		branch, ok := strings.CutPrefix(ref, "refs/heads/")
+		if !ok {
+			continue
+		}
+		heads[branch] = commit
+	}
+
+	return heads, scanner.Err()
+}
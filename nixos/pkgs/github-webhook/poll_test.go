@@ -0,0 +1,210 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPollTriggersOnNewHead polls a local bare repo and ensures only unseen
+// heads trigger a synthetic poll event.
+func TestPollTriggersOnNewHead(t *testing.T) {
+	base := t.TempDir()
+	remote := filepath.Join(base, "remote.git")
+	work := filepath.Join(base, "work")
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(content string) string {
+		readme := filepath.Join(work, "README.md")
+		if err := os.WriteFile(readme, []byte(content), 0o644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+		git("-C", work, "add", "README.md")
+		git("-C", work, "commit", "-m", content)
+		git("-C", work, "push", "origin", "HEAD:master")
+		return git("-C", work, "rev-parse", "HEAD")
+	}
+
+	git("init", "--bare", remote)
+	git("clone", remote, work)
+	git("-C", work, "config", "user.email", "test@example.com")
+	git("-C", work, "config", "user.name", "tester")
+	git("-C", work, "config", "commit.gpgsign", "false")
+	first := commit("one\n")
+
+	state, err := openStateStore("")
+	if err != nil {
+		t.Fatalf("openStateStore: %v", err)
+	}
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Branches:  []Branch{{Name: "master"}},
+			RemoteURL: remote,
+		},
+		state: state,
+	}
+
+	triggers := make(chan triggerContext, 4)
+	handler.deb = newDebouncer(5*time.Millisecond, func(tctx triggerContext) error {
+		triggers <- tctx
+		return nil
+	})
+	go handler.deb.Run(t.Context())
+
+	expectTrigger := func(want string) {
+		t.Helper()
+		select {
+		case tctx := <-triggers:
+			if tctx.event != "poll" || tctx.branch != "master" ||
+				tctx.commit != want {
+				t.Fatalf("unexpected trigger: %+v", tctx)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected poll trigger for %s", want)
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case tctx := <-triggers:
+			t.Fatalf("unexpected trigger: %+v", tctx)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// Unknown head triggers a run.
+	if err := handler.pollOnce(t.Context()); err != nil {
+		t.Fatalf("pollOnce: %v", err)
+	}
+	expectTrigger(first)
+
+	// Same head does not trigger again, even before it succeeds.
+	if err := handler.pollOnce(t.Context()); err != nil {
+		t.Fatalf("pollOnce: %v", err)
+	}
+	expectNone()
+
+	// Already-deployed head does not trigger.
+	second := commit("two\n")
+	handler.recordSuccess(triggerContext{branch: "master", commit: second}, handler.logger())
+	if err := handler.pollOnce(t.Context()); err != nil {
+		t.Fatalf("pollOnce: %v", err)
+	}
+	expectNone()
+
+	// A missed push triggers a run.
+	third := commit("three\n")
+	if err := handler.pollOnce(t.Context()); err != nil {
+		t.Fatalf("pollOnce: %v", err)
+	}
+	expectTrigger(third)
+}
+
+// TestStartQueuesStartupRun checks that start returns before the startup
+// run, which is queued on the debouncer instead of run inline.
+func TestStartQueuesStartupRun(t *testing.T) {
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo:     Repo{RunOnStartup: true},
+	}
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	triggers := make(chan triggerContext, 1)
+	handler.deb = newDebouncer(5*time.Millisecond, func(tctx triggerContext) error {
+		triggers <- tctx
+		<-release
+		return nil
+	})
+
+	started := make(chan struct{})
+	go func() {
+		handler.start(t.Context())
+		close(started)
+	}()
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("start blocked on the startup run")
+	}
+	select {
+	case tctx := <-triggers:
+		if tctx.event != "startup" {
+			t.Fatalf("unexpected trigger: %+v", tctx)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("startup run was not queued")
+	}
+}
+
+// TestStartupSkipsCurrentCheckout skips the startup run of a repo without
+// remote_url while its checkout is at the last successful commit.
+func TestStartupSkipsCurrentCheckout(t *testing.T) {
+	work := t.TempDir()
+	run := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-C", work}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	run("init", "--initial-branch", "master")
+	run("-c", "user.email=test@example.com", "-c", "user.name=tester",
+		"commit", "--allow-empty", "-m", "init")
+	deployed := run("rev-parse", "HEAD")
+
+	state, err := openStateStore("")
+	if err != nil {
+		t.Fatalf("open state: %v", err)
+	}
+	if err := state.recordSuccess("test/repo", "", "master",
+		successRecord{Commit: deployed, At: time.Now()}); err != nil {
+		t.Fatalf("record success: %v", err)
+	}
+
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Branches:     []Branch{{Name: "master"}},
+			WorkingDir:   work,
+			RunOnStartup: true,
+		},
+		state: state,
+	}
+	triggers := make(chan triggerContext, 2)
+	handler.deb = newDebouncer(5*time.Millisecond, func(tctx triggerContext) error {
+		triggers <- tctx
+		return nil
+	})
+	go handler.deb.Run(t.Context())
+
+	handler.runStartup(t.Context())
+	select {
+	case tctx := <-triggers:
+		t.Fatalf("startup run queued for a current checkout: %+v", tctx)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	run("-c", "user.email=test@example.com", "-c", "user.name=tester",
+		"commit", "--allow-empty", "-m", "next")
+	handler.runStartup(t.Context())
+	select {
+	case tctx := <-triggers:
+		if tctx.event != "startup" {
+			t.Fatalf("unexpected trigger: %+v", tctx)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("startup run was not queued for a moved checkout")
+	}
+}
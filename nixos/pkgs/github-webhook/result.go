@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unicode/utf8"
+)
+
+const (
+	// maxResultBytes limits the result file a command may write.
+	maxResultBytes = 64 << 10
+	// deploymentDescriptionLimit is GitHub's deployment status description
+	// length limit.
+	deploymentDescriptionLimit = 140
+)
+
+// runResult is the structured result a command may write to GH_RESULT_PATH,
+// e.g. {"summary": "deployed 3 hosts", "urls": [{"name": "preview", "url":
+// "https://..."}], "outputs": {"version": "1.2.3"}}. Unknown fields are
+// rejected. The last attempt's file (at most 64 KiB) is kept in the run
+// record and shown on the dashboard, logged as summary= with "run finished",
+// and used as the deployment status description (cut to 140 bytes) and
+// environment_url (the first URL). An invalid file (including a symlink or
+// anything but a regular file) is logged and never fails the run.
+type runResult struct {
+	// Summary is a one-line description of what the run did.
+	Summary string `json:"summary"`
+	// URLs links to what the run produced, e.g. a preview deployment.
+	URLs []resultURL `json:"urls"`
+	// Outputs are free-form key/value results.
+	Outputs map[string]string `json:"outputs"`
+}
+
+// resultURL is one named link of a runResult.
+type resultURL struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// createResultFile creates the private per-run directory holding the
+// GH_RESULT_PATH file, owned by run_as, and returns the file path and a
+// cleanup func.
+func (h *repoHandler) createResultFile() (string, func(), error) {
+	dir, err := os.MkdirTemp(
+		cmp.Or(runtimeDir(), os.Getenv("XDG_RUNTIME_DIR"), os.TempDir()), "result-")
+	if err != nil {
+		return "", nil, fmt.Errorf("create result dir: %w", err)
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+
+	if runAs := h.repo.RunAs; runAs != nil {
+		if err := os.Chown(dir, int(runAs.UID), int(runAs.GID)); err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("chown result dir: %w", err)
+		}
+	}
+	return filepath.Join(dir, "result.json"), cleanup, nil
+}
+
+// readResult strictly parses the result file at path. A missing file is no
+// result. The run owns the file's dir, so symlinks and special files are
+// refused, and opening never blocks (a FIFO would wedge the debouncer).
+func readResult(path string) (*runResult, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil {
+		return nil, err
+	} else if !info.Mode().IsRegular() {
+		return nil, errors.New("not a regular file")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, maxResultBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxResultBytes {
+		return nil, fmt.Errorf("larger than %d bytes", maxResultBytes)
+	}
+
+	var res runResult
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&res); err != nil {
+		return nil, fmt.Errorf("parse json: %w", err)
+	}
+	for i, u := range res.URLs {
+		parsed, err := url.Parse(u.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return nil, fmt.Errorf("urls[%d]: must be an http(s) URL, got %q", i, u.URL)
+		}
+	}
+	return &res, nil
+}
+
+// summary returns the summary, or "" without a result.
+func (res *runResult) summary() string {
+	if res == nil {
+		return ""
+	}
+	return res.Summary
+}
+
+// description returns the summary cut to a deployment status description.
+func (res *runResult) description() string {
+	if res == nil {
+		return ""
+	}
+	if len(res.Summary) <= deploymentDescriptionLimit {
+		return res.Summary
+	}
+	// Cut on a rune boundary, leaving room for the ellipsis.
+	s := res.Summary[:deploymentDescriptionLimit-len("…")]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s + "…"
+}
+
+// environmentURL returns the first URL, or "".
+func (res *runResult) environmentURL() string {
+	if res == nil || len(res.URLs) == 0 {
+		return ""
+	}
+	return res.URLs[0].URL
+}
+
+// collectResult reads the run's result file and records it in rec. An
+// invalid result is logged and ignored; it never fails the run.
+func (h *repoHandler) collectResult(
+	rec *runRecord,
+	tctx triggerContext,
+	logger *slog.Logger,
+) *runResult {
+	if tctx.resultPath == "" {
+		return nil
+	}
+	res, err := readResult(tctx.resultPath)
+	if err != nil {
+		logger.Warn("invalid result file", "err", err)
+		return nil
+	}
+	h.history.setResult(rec, res)
+	return res
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// TestRunCommandResult pipes the payload to the command and records the
+// result it writes to GH_RESULT_PATH.
+func TestRunCommandResult(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("RUNTIME_DIRECTORY", dir)
+	stdinPath := filepath.Join(dir, "stdin")
+
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Command: []string{"sh", "-c", `cat > ` + stdinPath + ` && cat > "$GH_RESULT_PATH" <<'EOF'
+{"summary": "deployed", "urls": [{"name": "preview", "url": "https://preview.example.com"}], "outputs": {"version": "1.2.3"}}
+EOF`},
+			WorkingDir:   dir,
+			StdinPayload: true,
+		},
+		timeout: 5 * time.Second,
+		history: newRunHistory(),
+	}
+	tctx := triggerContext{event: "push", branch: "master", payload: []byte(`{"ref":"refs/heads/master"}`)}
+	if err := handler.runCommand(t.Context(), tctx); err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+
+	if got, _ := os.ReadFile(stdinPath); string(got) != string(tctx.payload) {
+		t.Errorf("stdin = %q, want the payload", got)
+	}
+	runs := handler.history.snapshot()
+	if len(runs) != 1 || runs[0].Result == nil {
+		t.Fatalf("runs = %+v, want one run with a result", runs)
+	}
+	res := runs[0].Result
+	if res.Summary != "deployed" || res.environmentURL() != "https://preview.example.com" ||
+		res.Outputs["version"] != "1.2.3" {
+		t.Errorf("result = %+v", res)
+	}
+
+	// The per-run result dir is removed with the run.
+	if matches, _ := filepath.Glob(filepath.Join(dir, "result-*")); len(matches) > 0 {
+		t.Errorf("result dirs left behind: %v", matches)
+	}
+}
+
+func TestReadResult(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "result.json")
+
+	if res, err := readResult(path); res != nil || err != nil {
+		t.Fatalf("missing file: got %+v, %v; want no result", res, err)
+	}
+
+	for _, tc := range []struct {
+		data, want string
+	}{
+		{`{"summary": "ok", "url": "x"}`, `unknown field "url"`},
+		{`{"urls": [{"name": "x", "url": "javascript:alert(1)"}]}`, "urls[0]: must be an http(s) URL"},
+		{`{"summary": "` + strings.Repeat("x", maxResultBytes) + `"}`, "larger than"},
+	} {
+		if err := os.WriteFile(path, []byte(tc.data), 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, err := readResult(path); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%.40s: got %v, want %q", tc.data, err, tc.want)
+		}
+	}
+
+	// Runs own the result dir: links and FIFOs are refused without blocking.
+	target := filepath.Join(dir, "target.json")
+	if err := os.WriteFile(target, []byte(`{"summary": "ok"}`), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	link := filepath.Join(dir, "link.json")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	fifo := filepath.Join(dir, "fifo.json")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Fatalf("mkfifo: %v", err)
+	}
+	for _, path := range []string{link, fifo} {
+		if res, err := readResult(path); res != nil || err == nil {
+			t.Errorf("%s: got %+v, %v; want an error", filepath.Base(path), res, err)
+		}
+	}
+
+	res := &runResult{Summary: strings.Repeat("é", 100)}
+	if d := res.description(); len(d) > deploymentDescriptionLimit || !utf8.ValidString(d) {
+		t.Errorf("description = %q (%d bytes), want a valid cut to %d bytes",
+			d, len(d), deploymentDescriptionLimit)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+const (
+	// defaultInitialBackoff is the delay before the first retry.
+	defaultInitialBackoff = time.Second
+
+	// defaultMaxBackoff caps the exponential backoff.
+	defaultMaxBackoff = time.Minute
+)
+
+// RetryConfig configures automatic retries of failed commands, with
+// exponential backoff. A pending retry is cancelled when a newer trigger for
+// the same job arrives.
+type RetryConfig struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int `json:"max_attempts"`
+	// InitialBackoffMs is the delay before the first retry.
+	InitialBackoffMs int `json:"initial_backoff_ms"`
+	// MaxBackoffMs caps the doubling backoff delay.
+	MaxBackoffMs int `json:"max_backoff_ms"`
+	// ExitCodes lists retryable exit codes; empty means any non-zero exit.
+	ExitCodes []int `json:"exit_codes"`
+}
+
+// retryPolicy returns the repo's retry policy with defaults applied. Repos
+// without a retry config run each trigger exactly once.
+func (h *repoHandler) retryPolicy() RetryConfig {
+	if h.repo.Retry == nil {
+		return RetryConfig{MaxAttempts: 1}
+	}
+
+	policy := *h.repo.Retry
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.InitialBackoffMs <= 0 {
+		policy.InitialBackoffMs = int(defaultInitialBackoff / time.Millisecond)
+	}
+	if policy.MaxBackoffMs <= 0 {
+		policy.MaxBackoffMs = int(defaultMaxBackoff / time.Millisecond)
+	}
+	return policy
+}
+
+// backoff returns the delay after the given failed attempt (1-based).
+func (p RetryConfig) backoff(attempt int) time.Duration {
+	delay := time.Duration(p.InitialBackoffMs) * time.Millisecond
+	limit := time.Duration(p.MaxBackoffMs) * time.Millisecond
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
+
+// retryable reports whether err is a command exit that may be retried.
+// Timeouts and start failures are never retried.
+func (p RetryConfig) retryable(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+
+	code := exitErr.ExitCode()
+	if code <= 0 {
+		// Killed by a signal, e.g. the command timeout.
+		return false
+	}
+	return len(p.ExitCodes) == 0 || slices.Contains(p.ExitCodes, code)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunCommandRetriesRetryableExit re-runs a flaky command until it succeeds
+// and exposes the attempt number.
+func TestRunCommandRetriesRetryableExit(t *testing.T) {
+	work := t.TempDir()
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			// Fail with 75 (EX_TEMPFAIL) until the third attempt.
+			Command: []string{"bash", "-c",
+				`echo "$GH_ATTEMPT" >> attempts; [ "$GH_ATTEMPT" -ge 3 ] || exit 75`},
+			WorkingDir: work,
+			Retry: &RetryConfig{
+				MaxAttempts:      3,
+				InitialBackoffMs: 1,
+				ExitCodes:        []int{75},
+			},
+		},
+		timeout: 5 * time.Second,
+	}
+
+	if err := handler.runCommand(t.Context(), triggerContext{event: "push"}); err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(work, "attempts"))
+	if err != nil {
+		t.Fatalf("read attempts: %v", err)
+	}
+	if got := strings.Fields(string(data)); strings.Join(got, ",") != "1,2,3" {
+		t.Fatalf("expected attempts 1,2,3, got %v", got)
+	}
+}
+
+// TestRunCommandDoesNotRetryOtherExit stops after one attempt when the exit
+// code is not listed as retryable.
+func TestRunCommandDoesNotRetryOtherExit(t *testing.T) {
+	work := t.TempDir()
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Command:    []string{"bash", "-c", `echo x >> attempts; exit 1`},
+			WorkingDir: work,
+			Retry: &RetryConfig{
+				MaxAttempts:      3,
+				InitialBackoffMs: 1,
+				ExitCodes:        []int{75},
+			},
+		},
+		timeout: 5 * time.Second,
+	}
+
+	if err := handler.runCommand(t.Context(), triggerContext{}); err == nil {
+		t.Fatalf("expected failure")
+	}
+
+	data, err := os.ReadFile(filepath.Join(work, "attempts"))
+	if err != nil {
+		t.Fatalf("read attempts: %v", err)
+	}
+	if n := strings.Count(string(data), "x"); n != 1 {
+		t.Fatalf("expected 1 attempt, got %d", n)
+	}
+}
+
+// TestRunCommandRetryCancelledBySupersede abandons the backoff wait when a
+// newer trigger arrives.
+func TestRunCommandRetryCancelledBySupersede(t *testing.T) {
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Command:    []string{"false"},
+			WorkingDir: t.TempDir(),
+			Retry: &RetryConfig{
+				MaxAttempts:      5,
+				InitialBackoffMs: 60_000,
+			},
+		},
+		timeout: 5 * time.Second,
+	}
+
+	superseded := make(chan struct{})
+	close(superseded)
+
+	start := time.Now()
+	err := handler.runCommand(t.Context(), triggerContext{superseded: superseded})
+	if err == nil {
+		t.Fatalf("expected failure")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("retry was not cancelled, took %s", elapsed)
+	}
+}
+
+// TestRetryBackoff doubles the delay up to the cap.
+func TestRetryBackoff(t *testing.T) {
+	p := RetryConfig{InitialBackoffMs: 100, MaxBackoffMs: 350}
+	want := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		350 * time.Millisecond,
+		350 * time.Millisecond,
+	}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+}
+
+// TestDebouncerSupersedesRunningJob closes the superseded channel when a new
+// trigger for the running job arrives.
+func TestDebouncerSupersedesRunningJob(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+
+	deb := newDebouncer(5*time.Millisecond, func(tctx triggerContext) error {
+		if tctx.commit != "aaa" {
+			return nil
+		}
+		close(started)
+		select {
+		case <-tctx.superseded:
+			close(cancelled)
+		case <-time.After(time.Second):
+		}
+		return nil
+	})
+	go deb.Run(t.Context())
+
+	deb.triggerWithContext("push", "refs/heads/master", "master", "aaa", "")
+	<-started
+	deb.triggerWithContext("push", "refs/heads/master", "master", "bbb", "")
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("running job was not superseded")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// isolationSystemdRun runs each attempt as a transient systemd unit.
+const isolationSystemdRun = "systemd-run"
+
+// systemdRunBaseEnv lists the daemon variables systemd-run units get when
+// neither pass_env nor clean_env is set. Their environment is passed on the
+// world-readable systemd-run command line, so the daemon's own (e.g.
+// CREDENTIALS_DIRECTORY, NOTIFY_SOCKET) is not inherited wholesale.
+var systemdRunBaseEnv = []string{"PATH", "HOME"}
+
+// RunAs selects the user and group a command runs as.
+type RunAs struct {
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+}
+
+// SystemdRunConfig configures the transient unit for isolation=systemd-run.
+type SystemdRunConfig struct {
+	// ProtectSystem is the unit's ProtectSystem= (default "strict").
+	ProtectSystem string `json:"protect_system"`
+	// PrivateTmp is the unit's PrivateTmp= (default true).
+	PrivateTmp *bool `json:"private_tmp"`
+	// MemoryMax is the unit's MemoryMax= (e.g. "4G"); empty means no limit.
+	MemoryMax string `json:"memory_max"`
+	// ReadWritePaths are writable under ProtectSystem; the working dir is
+	// always included.
+	ReadWritePaths []string `json:"read_write_paths"`
+	// Properties are extra unit properties, e.g. "ProtectHome=read-only".
+	Properties []string `json:"properties"`
+}
+
+// validateSandbox checks the repo's sandbox settings.
+func validateSandbox(repo *Repo) error {
+	switch repo.Isolation {
+	case "", isolationSystemdRun:
+	default:
+		return fmt.Errorf("isolation must be empty or %q, got %q",
+			isolationSystemdRun, repo.Isolation)
+	}
+	if repo.SystemdRun != nil && repo.Isolation != isolationSystemdRun {
+		return fmt.Errorf("systemd_run set but isolation is %q", repo.Isolation)
+	}
+	// systemd-run passes the environment as --setenv arguments, which any
+	// local user can read from /proc/PID/cmdline.
+	if repo.Isolation == isolationSystemdRun && len(repo.Secrets) > 0 &&
+		repo.SecretsMode != secretsModeFiles {
+		return fmt.Errorf("isolation %q requires secrets_mode %q",
+			isolationSystemdRun, secretsModeFiles)
+	}
+	for _, name := range repo.PassEnv {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("pass_env: invalid variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// baseEnv returns the daemon environment visible to the repo's commands.
+// By default the full daemon environment is inherited (only PATH and HOME
+// under isolation="systemd-run"); with pass_env or clean_env only the listed
+// variables are copied.
+func (h *repoHandler) baseEnv() []string {
+	names := h.repo.PassEnv
+	if !h.repo.CleanEnv && len(names) == 0 {
+		if h.repo.Isolation != isolationSystemdRun {
+			return os.Environ()
+		}
+		names = systemdRunBaseEnv
+	}
+
+	env := make([]string, 0, len(names))
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// buildCmd constructs the exec.Cmd for one attempt (or step) in dir bounded
+// by timeout, applying run_as and isolation settings. writable lists extra
+// paths the command writes to, which systemd-run isolation must allow.
+func (h *repoHandler) buildCmd(
+	ctx context.Context,
+	dir string,
+	writable []string,
+	command []string,
+	env []string,
+	timeout time.Duration,
+) *exec.Cmd {
+	if h.repo.Isolation == isolationSystemdRun {
+		argv := h.systemdRunArgs(dir, writable, command, env, timeout)
+		cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+		// systemd-run itself talks to the service manager; the unit gets
+		// only the --setenv variables.
+		cmd.Env = os.Environ()
+		return cmd
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = dir
+	cmd.Env = env
+	if h.repo.RunAs != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid: h.repo.RunAs.UID,
+				Gid: h.repo.RunAs.GID,
+				// Drop the daemon's supplementary groups. Only a privileged
+				// daemon may, and an unprivileged one can only run_as itself.
+				Groups:      []uint32{},
+				NoSetGroups: os.Geteuid() != 0,
+			},
+		}
+	}
+	return cmd
+}
+
+// gitCmd builds a git command inspecting the checkout in dir. It runs with
+// the repo's run_as and isolation settings, so the checkout's git config is
+// never acted on with the daemon's privileges.
+func (h *repoHandler) gitCmd(
+	ctx context.Context,
+	dir string,
+	timeout time.Duration,
+	args ...string,
+) *exec.Cmd {
+	command := append([]string{"git", "-C", dir}, args...)
+	return h.buildCmd(ctx, dir, nil, command, h.baseEnv(), timeout)
+}
+
+// systemdRunArgs wraps command in a `systemd-run` invocation that runs it as
+// a transient, hardened service unit and waits for it to finish.
+func (h *repoHandler) systemdRunArgs(
+	dir string,
+	writable []string,
+	command []string,
+	env []string,
+	timeout time.Duration,
+) []string {
+	sr := h.repo.SystemdRun
+	if sr == nil {
+		sr = &SystemdRunConfig{}
+	}
+
+	protectSystem := sr.ProtectSystem
+	if protectSystem == "" {
+		protectSystem = "strict"
+	}
+	privateTmp := sr.PrivateTmp == nil || *sr.PrivateTmp
+
+	argv := []string{
+		"systemd-run",
+		"--quiet",
+		"--wait",
+		"--pipe",
+		"--collect",
+		"--service-type=exec",
+		"--property=ProtectSystem=" + protectSystem,
+		"--property=PrivateTmp=" + strconv.FormatBool(privateTmp),
+		"--property=NoNewPrivileges=yes",
+	}
+	if timeout > 0 {
+		// Bound the unit too: killing the systemd-run client does not stop it.
+		argv = append(argv, fmt.Sprintf("--property=RuntimeMaxSec=%dms",
+			timeout/time.Millisecond))
+	}
+	if sr.MemoryMax != "" {
+		argv = append(argv, "--property=MemoryMax="+sr.MemoryMax)
+	}
+	if dir != "" {
+		argv = append(argv,
+			"--working-directory="+dir,
+			"--property=ReadWritePaths="+dir)
+	}
+	if h.dataDir != "" {
+		argv = append(argv,
+			"--property=ReadWritePaths="+h.cacheRoot(),
+			"--property=ReadWritePaths="+h.artifactsRoot())
+	}
+	for _, path := range append(writable, sr.ReadWritePaths...) {
+		argv = append(argv, "--property=ReadWritePaths="+path)
+	}
+	for _, prop := range sr.Properties {
+		argv = append(argv, "--property="+prop)
+	}
+	if h.repo.RunAs != nil {
+		argv = append(argv,
+			"--uid="+strconv.FormatUint(uint64(h.repo.RunAs.UID), 10),
+			"--gid="+strconv.FormatUint(uint64(h.repo.RunAs.GID), 10))
+	}
+	// env never holds secret values: validateSandbox requires
+	// secrets_mode="files" under systemd-run.
+	for _, kv := range env {
+		argv = append(argv, "--setenv="+kv)
+	}
+
+	argv = append(argv, "--")
+	return append(argv, command...)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRunCommandPassEnv only passes allow-listed daemon variables.
+func TestRunCommandPassEnv(t *testing.T) {
+	t.Setenv("GH_TEST_ALLOWED", "yes")
+	t.Setenv("GH_TEST_SECRET", "leak")
+
+	work := t.TempDir()
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Command:    []string{"sh", "-c", "env > env.txt"},
+			WorkingDir: work,
+			PassEnv:    []string{"GH_TEST_ALLOWED"},
+		},
+		timeout: 5 * time.Second,
+	}
+
+	if err := handler.runCommand(t.Context(), triggerContext{event: "push"}); err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+
+	env := readEnvFile(t, filepath.Join(work, "env.txt"))
+	if !slices.Contains(env, "GH_TEST_ALLOWED=yes") {
+		t.Errorf("allow-listed variable missing: %v", env)
+	}
+	if !slices.Contains(env, "GH_EVENT=push") {
+		t.Errorf("GH_EVENT missing: %v", env)
+	}
+	if slices.Contains(env, "GH_TEST_SECRET=leak") {
+		t.Errorf("non-allow-listed variable leaked: %v", env)
+	}
+}
+
+// TestRunCommandCleanEnv starts from an empty environment.
+func TestRunCommandCleanEnv(t *testing.T) {
+	t.Setenv("GH_TEST_SECRET", "leak")
+
+	work := t.TempDir()
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Command:    []string{"sh", "-c", "env > env.txt"},
+			WorkingDir: work,
+			CleanEnv:   true,
+		},
+		timeout: 5 * time.Second,
+	}
+
+	if err := handler.runCommand(t.Context(), triggerContext{}); err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+
+	for _, kv := range readEnvFile(t, filepath.Join(work, "env.txt")) {
+		name, _, _ := strings.Cut(kv, "=")
+		// sh may export PWD/SHLVL/_ on its own.
+		if strings.HasPrefix(name, "GH_") && name != "GH_TEST_SECRET" ||
+			name == "PWD" || name == "SHLVL" || name == "_" {
+			continue
+		}
+		t.Errorf("unexpected variable in clean env: %s", kv)
+	}
+}
+
+// TestRunCommandRunAsSelf applies run_as credentials (to the current ids so
+// the test works unprivileged).
+func TestRunCommandRunAsSelf(t *testing.T) {
+	work := t.TempDir()
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Command:    []string{"sh", "-c", "id -u > uid.txt"},
+			WorkingDir: work,
+			RunAs:      &RunAs{UID: uint32(os.Getuid()), GID: uint32(os.Getgid())},
+		},
+		timeout: 5 * time.Second,
+	}
+
+	if err := handler.runCommand(t.Context(), triggerContext{}); err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(work, "uid.txt"))
+	if err != nil {
+		t.Fatalf("read uid: %v", err)
+	}
+	if got, want := strings.TrimSpace(string(data)), strconv.Itoa(os.Getuid()); got != want {
+		t.Fatalf("expected uid %s, got %s", want, got)
+	}
+}
+
+// TestRunCommandRunAsDropsGroups clears the daemon's supplementary groups
+// for run_as commands.
+func TestRunCommandRunAsDropsGroups(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("clearing supplementary groups needs root")
+	}
+	groups, err := syscall.Getgroups()
+	if err != nil {
+		t.Fatalf("getgroups: %v", err)
+	}
+	// Give the daemon (this process) a supplementary group to leak.
+	if err := syscall.Setgroups([]int{4242}); err != nil {
+		t.Fatalf("setgroups: %v", err)
+	}
+	t.Cleanup(func() { _ = syscall.Setgroups(groups) })
+
+	work := t.TempDir()
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Command:    []string{"sh", "-c", "grep ^Groups: /proc/self/status > groups.txt"},
+			WorkingDir: work,
+			RunAs:      &RunAs{UID: 0, GID: 0},
+		},
+		timeout: 5 * time.Second,
+	}
+
+	if err := handler.runCommand(t.Context(), triggerContext{}); err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(work, "groups.txt"))
+	if err != nil {
+		t.Fatalf("read groups: %v", err)
+	}
+	if got := strings.TrimSpace(strings.TrimPrefix(string(data), "Groups:")); got != "" {
+		t.Fatalf("child kept supplementary groups: %q", got)
+	}
+}
+
+// TestSystemdRunArgs builds a hardened transient unit invocation.
+func TestSystemdRunArgs(t *testing.T) {
+	handler := &repoHandler{
+		repo: Repo{
+			WorkingDir: "/srv/work",
+			RunAs:      &RunAs{UID: 1000, GID: 100},
+			Isolation:  isolationSystemdRun,
+			SystemdRun: &SystemdRunConfig{
+				MemoryMax:  "4G",
+				Properties: []string{"ProtectHome=read-only"},
+			},
+		},
+		timeout: time.Hour,
+	}
+
+	argv := handler.systemdRunArgs("/srv/work", []string{"/run/result-1"},
+		[]string{"deploy.sh", "--fast"}, []string{"GH_EVENT=push"}, handler.timeout)
+	got := strings.Join(argv, " ")
+
+	for _, want := range []string{
+		"systemd-run --quiet --wait --pipe --collect",
+		"--property=ProtectSystem=strict",
+		"--property=PrivateTmp=true",
+		"--property=RuntimeMaxSec=3600000ms",
+		"--property=MemoryMax=4G",
+		"--working-directory=/srv/work",
+		"--property=ReadWritePaths=/srv/work",
+		"--property=ReadWritePaths=/run/result-1",
+		"--property=ProtectHome=read-only",
+		"--uid=1000 --gid=100",
+		"--setenv=GH_EVENT=push",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("argv missing %q: %s", want, got)
+		}
+	}
+	if !strings.HasSuffix(got, "-- deploy.sh --fast") {
+		t.Errorf("argv should end with the command: %s", got)
+	}
+}
+
+// TestValidateSandbox rejects unknown isolation modes and bad names.
+func TestValidateSandbox(t *testing.T) {
+	bad := []Repo{
+		{Isolation: "docker"},
+		{SystemdRun: &SystemdRunConfig{}},
+		{PassEnv: []string{"A=B"}},
+		{Isolation: isolationSystemdRun, Secrets: map[string]string{"TOKEN": "/tmp/token"}},
+	}
+	for _, repo := range bad {
+		if err := validateSandbox(&repo); err == nil {
+			t.Errorf("expected error for %+v", repo)
+		}
+	}
+}
+
+// readEnvFile reads `env` output into KEY=VALUE lines.
+func readEnvFile(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read env: %v", err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// TestBaseEnvSystemdRun keeps the daemon environment off the systemd-run
+// command line unless pass_env asks for it.
+func TestBaseEnvSystemdRun(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("NOTIFY_SOCKET", "/run/systemd/notify")
+
+	handler := &repoHandler{repo: Repo{Isolation: isolationSystemdRun}}
+	env := handler.baseEnv()
+	if !slices.Contains(env, "PATH=/usr/bin") {
+		t.Errorf("env missing PATH: %v", env)
+	}
+	if slices.Contains(env, "NOTIFY_SOCKET=/run/systemd/notify") {
+		t.Errorf("env inherits NOTIFY_SOCKET: %v", env)
+	}
+
+	handler.repo.PassEnv = []string{"NOTIFY_SOCKET"}
+	if env := handler.baseEnv(); !slices.Equal(env, []string{"NOTIFY_SOCKET=/run/systemd/notify"}) {
+		t.Errorf("pass_env env = %v", env)
+	}
+}
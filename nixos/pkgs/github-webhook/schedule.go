@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleLateTolerance is how late a schedule may fire and still count as on
+// time. Anything later (suspend, clock jump) is a missed run.
+const scheduleLateTolerance = time.Minute
+
+const (
+	// missedSkip drops runs whose fire time passed unobserved.
+	missedSkip = "skip"
+	// missedCatchUp runs once to make up for any missed fire times.
+	missedCatchUp = "catch_up"
+)
+
+// Schedule is a cron-triggered job that runs through the repo's debouncer.
+type Schedule struct {
+	// Name identifies the job in logs and as GH_JOB.
+	Name string `json:"name"`
+	// Cron is a 5-field cron expression or @hourly/@daily/@weekly/@monthly/
+	// @yearly.
+	Cron string `json:"cron"`
+	// Timezone is an IANA zone name; defaults to UTC.
+	Timezone string `json:"timezone"`
+	// Command overrides the repo command for this job.
+	Command []string `json:"command"`
+	// Missed is "skip" (default) or "catch_up": what to do about fire times
+	// missed by more than scheduleLateTolerance, including while the daemon
+	// was down (with a state file).
+	Missed string `json:"missed"`
+	// Environment reports this job's runs as GitHub deployments to the
+	// named environment.
+	Environment string `json:"environment"`
+}
+
+// cronSpec is a parsed cron expression. Each field is a bitset of allowed
+// values.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+
+	// domStar/dowStar record unrestricted day fields; when both day fields
+	// are restricted, a day matches if either matches (vixie cron semantics).
+	domStar, dowStar bool
+
+	loc *time.Location
+}
+
+// cronField describes the legal range of one cron field.
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronMinute = cronField{name: "minute", min: 0, max: 59}
+	cronHour   = cronField{name: "hour", min: 0, max: 23}
+	cronDom    = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDow = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// cronMacros maps @-shorthands to their 5-field equivalents.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseSchedule validates a schedule entry and parses its cron expression.
+func parseSchedule(s *Schedule) (*cronSpec, error) {
+	if s.Name == "" {
+		return nil, fmt.Errorf("schedule %q: name is required", s.Cron)
+	}
+	switch s.Missed {
+	case "":
+		s.Missed = missedSkip
+	case missedSkip, missedCatchUp:
+	default:
+		return nil, fmt.Errorf("schedule %s: missed must be %q or %q, got %q",
+			s.Name, missedSkip, missedCatchUp, s.Missed)
+	}
+
+	spec, err := parseCron(s.Cron, s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %s: %w", s.Name, err)
+	}
+	return spec, nil
+}
+
+// parseCron parses a cron expression evaluated in the named timezone.
+func parseCron(expr, timezone string) (*cronSpec, error) {
+	loc := time.UTC
+	if timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("timezone %q: %w", timezone, err)
+		}
+	}
+
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: expected 5 fields, got %d",
+			expr, len(fields))
+	}
+
+	spec := &cronSpec{loc: loc}
+	var err error
+	if spec.minute, err = parseCronField(fields[0], cronMinute); err != nil {
+		return nil, err
+	}
+	if spec.hour, err = parseCronField(fields[1], cronHour); err != nil {
+		return nil, err
+	}
+	if spec.dom, err = parseCronField(fields[2], cronDom); err != nil {
+		return nil, err
+	}
+	if spec.month, err = parseCronField(fields[3], cronMonth); err != nil {
+		return nil, err
+	}
+	if spec.dow, err = parseCronField(fields[4], cronDow); err != nil {
+		return nil, err
+	}
+	// Both 0 and 7 mean Sunday.
+	if spec.dow&(1<<7) != 0 {
+		spec.dow |= 1
+	}
+	spec.domStar = fields[2] == "*" || fields[2] == "?"
+	spec.dowStar = fields[4] == "*" || fields[4] == "?"
+
+	return spec, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps.
+func parseCronField(field string, f cronField) (uint64, error) {
+	var bits uint64
+	for part := range strings.SplitSeq(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("cron %s: invalid step %q", f.name, part)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.Contains(rangePart, "-"):
+			loStr, hiStr, _ := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = f.value(loStr); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(hiStr); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("cron %s: invalid range %q", f.name, part)
+			}
+		default:
+			v, err := f.value(rangePart)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if !hasStep {
+				hi = v
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// value parses a single numeric or named field value.
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("cron %s: invalid value %q", f.name, s)
+	}
+	return v, nil
+}
+
+// matchDay reports whether t's day matches the day-of-month/day-of-week
+// fields.
+func (c *cronSpec) matchDay(t time.Time) bool {
+	domMatch := c.dom&(1<<t.Day()) != 0
+	dowMatch := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns the first fire time strictly after t, or the zero time if the
+// expression never fires (e.g. "0 0 31 2 *").
+func (c *cronSpec) next(t time.Time) time.Time {
+	t = t.In(c.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.loc)
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.loc)
+			continue
+		}
+		if c.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.loc)
+			continue
+		}
+		if c.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// scheduleDecision is the outcome of waking up for a scheduled fire time.
+type scheduleDecision struct {
+	// run is true if the job should be triggered now.
+	run bool
+	// late is true if the newest fire time was missed by more than
+	// scheduleLateTolerance.
+	late bool
+	// missed counts fire times that passed without their own run.
+	missed int
+	// next is the next fire time to wait for.
+	next time.Time
+}
+
+// decide computes what to do at now for a schedule that was due at due.
+func (c *cronSpec) decide(due, now time.Time, missedPolicy string) scheduleDecision {
+	// Find the newest fire time that has already passed.
+	latest := due
+	fires := 1
+	for n := c.next(latest); !n.IsZero() && !n.After(now); n = c.next(n) {
+		latest = n
+		fires++
+	}
+
+	d := scheduleDecision{next: c.next(now)}
+	if now.Sub(latest) <= scheduleLateTolerance {
+		d.run = true
+		d.missed = fires - 1
+		return d
+	}
+
+	d.late = true
+	d.missed = fires
+	d.run = missedPolicy == missedCatchUp
+	return d
+}
+
+// firstFire returns the first fire time scheduleLoop waits for: the first one
+// after the schedule was last checked (possibly already past, if the daemon
+// was down), or the next one after now for a schedule never checked before.
+func (h *repoHandler) firstFire(s *Schedule, spec *cronSpec, now time.Time) time.Time {
+	if last, ok := h.state.scheduleChecked(h.fullName, s.Name); ok && last.Before(now) {
+		return spec.next(last)
+	}
+	h.checkedSchedule(s, now)
+	return spec.next(now)
+}
+
+// checkedSchedule persists that s handled its fire times up to at.
+func (h *repoHandler) checkedSchedule(s *Schedule, at time.Time) {
+	if err := h.state.setScheduleChecked(h.fullName, s.Name, at); err != nil {
+		h.logger().Warn("schedule: persist state failed", "job", s.Name, "err", err)
+	}
+}
+
+// scheduleLoop triggers the scheduled job through the repo's debouncer until
+// ctx is done. Fire times missed while the daemon was down are handled on
+// startup according to the schedule's missed policy.
+func (h *repoHandler) scheduleLoop(ctx context.Context, s *Schedule, spec *cronSpec) {
+	logger := h.logger().With("job", s.Name)
+	next := h.firstFire(s, spec, time.Now())
+	for !next.IsZero() {
+		logger.Info("schedule: next run", "at", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		d := spec.decide(next, now, s.Missed)
+		next = d.next
+		h.checkedSchedule(s, now)
+
+		switch {
+		case d.late && d.run:
+			logger.Info("schedule: catching up missed runs", "missed", d.missed)
+		case d.late:
+			logger.Info("schedule: skipping missed runs", "missed", d.missed)
+		case d.missed > 0:
+			logger.Info("schedule: coalescing missed runs", "missed", d.missed)
+		}
+		if !d.run {
+			continue
+		}
+
+		h.enqueue(triggerContext{event: "schedule", job: s.Name})
+	}
+
+	logger.Warn("schedule: never fires again")
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCronNext checks fire time calculation for common expressions.
+func TestCronNext(t *testing.T) {
+	la, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+
+	from := time.Date(2025, time.January, 31, 10, 30, 0, 0, time.UTC)
+
+	cases := []struct {
+		expr, tz string
+		want     time.Time
+	}{
+		{"*/15 * * * *", "", time.Date(2025, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 4 * * *", "", time.Date(2025, 2, 1, 4, 0, 0, 0, time.UTC)},
+		{"@hourly", "", time.Date(2025, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"0 0 1 */3 *", "", time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * mon-fri", "", time.Date(2025, 2, 3, 9, 30, 0, 0, time.UTC)},
+		{"0 12 * * 7", "", time.Date(2025, 2, 2, 12, 0, 0, 0, time.UTC)},
+		// Restricted dom and dow match if either matches.
+		{"0 0 15 * fri", "", time.Date(2025, 2, 7, 0, 0, 0, 0, time.UTC)},
+		// 10:30 UTC is 02:30 in Los Angeles, so 04:00 local is the same day.
+		{"0 4 * * *", "America/Los_Angeles", time.Date(2025, 1, 31, 4, 0, 0, 0, la)},
+	}
+
+	for _, tc := range cases {
+		spec, err := parseCron(tc.expr, tc.tz)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tc.expr, err)
+		}
+		if got := spec.next(from); !got.Equal(tc.want) {
+			t.Errorf("next(%q) = %s, want %s", tc.expr, got, tc.want)
+		}
+	}
+
+	// Never-firing expressions return the zero time.
+	spec, err := parseCron("0 0 31 2 *", "")
+	if err != nil {
+		t.Fatalf("parseCron: %v", err)
+	}
+	if got := spec.next(from); !got.IsZero() {
+		t.Errorf("expected zero time for Feb 31, got %s", got)
+	}
+}
+
+// TestParseScheduleErrors rejects malformed schedule entries.
+func TestParseScheduleErrors(t *testing.T) {
+	bad := []*Schedule{
+		{Cron: "0 4 * * *"},
+		{Name: "x", Cron: "0 4 * *"},
+		{Name: "x", Cron: "61 4 * * *"},
+		{Name: "x", Cron: "0 4 * * *", Timezone: "Mars/Olympus"},
+		{Name: "x", Cron: "0 4 * * *", Missed: "sometimes"},
+	}
+	for _, s := range bad {
+		if _, err := parseSchedule(s); err == nil {
+			t.Errorf("expected error for %+v", s)
+		}
+	}
+
+	s := &Schedule{Name: "gc", Cron: "@daily"}
+	if _, err := parseSchedule(s); err != nil {
+		t.Fatalf("parseSchedule: %v", err)
+	}
+	if s.Missed != missedSkip {
+		t.Errorf("expected default missed policy %q, got %q", missedSkip, s.Missed)
+	}
+}
+
+// TestScheduleDecide covers on-time, coalesced and missed fire times.
+func TestScheduleDecide(t *testing.T) {
+	spec, err := parseCron("0 * * * *", "")
+	if err != nil {
+		t.Fatalf("parseCron: %v", err)
+	}
+	due := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	// On time.
+	d := spec.decide(due, due.Add(time.Second), missedSkip)
+	if !d.run || d.late || d.missed != 0 {
+		t.Errorf("on time: %+v", d)
+	}
+
+	// Woke up late, right after a later fire time: run once, coalescing.
+	d = spec.decide(due, due.Add(2*time.Hour+time.Second), missedSkip)
+	if !d.run || d.late || d.missed != 2 {
+		t.Errorf("coalesced: %+v", d)
+	}
+
+	// Woke up well after the newest fire time: skip or catch up.
+	d = spec.decide(due, due.Add(2*time.Hour+30*time.Minute), missedSkip)
+	if d.run || !d.late || d.missed != 3 {
+		t.Errorf("skip: %+v", d)
+	}
+	d = spec.decide(due, due.Add(2*time.Hour+30*time.Minute), missedCatchUp)
+	if !d.run || !d.late {
+		t.Errorf("catch up: %+v", d)
+	}
+	if want := due.Add(3 * time.Hour); !d.next.Equal(want) {
+		t.Errorf("next = %s, want %s", d.next, want)
+	}
+}
+
+// TestDebouncerKeepsJobsSeparate ensures a schedule trigger is not coalesced
+// away by a webhook trigger in the same quiet window.
+func TestDebouncerKeepsJobsSeparate(t *testing.T) {
+	var mu sync.Mutex
+	var got []triggerContext
+	done := make(chan struct{})
+
+	deb := newDebouncer(10*time.Millisecond, func(tctx triggerContext) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, tctx)
+		if len(got) == 2 {
+			close(done)
+		}
+		return nil
+	})
+	go deb.Run(t.Context())
+
+	deb.triggerWithContext("push", "refs/heads/master", "master", "aaa", "alice")
+	deb.enqueue(triggerContext{event: "schedule", job: "gc"})
+	deb.triggerWithContext("push", "refs/heads/master", "master", "bbb", "alice")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected two runs")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got[0].commit != "bbb" || got[1].job != "gc" {
+		t.Fatalf("unexpected runs: %+v", got)
+	}
+}
+
+// TestScheduleLoopAfterRestart applies the missed policy to a fire time that
+// passed while the daemon was down.
+func TestScheduleLoopAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	for _, tc := range []struct {
+		missed string
+		run    bool
+	}{
+		{missedSkip, false},
+		{missedCatchUp, true},
+	} {
+		s := &Schedule{Name: "gc", Cron: "0 * * * *", Missed: tc.missed}
+		spec, err := parseSchedule(s)
+		if err != nil {
+			t.Fatalf("parseSchedule: %v", err)
+		}
+
+		// The previous daemon last checked the schedule two hours ago.
+		state, err := openStateStore(path)
+		if err != nil {
+			t.Fatalf("openStateStore: %v", err)
+		}
+		stopped := time.Now().Add(-2 * time.Hour)
+		if err := state.setScheduleChecked("test/repo", "gc", stopped); err != nil {
+			t.Fatalf("setScheduleChecked: %v", err)
+		}
+
+		// Restart.
+		state, err = openStateStore(path)
+		if err != nil {
+			t.Fatalf("reopen state: %v", err)
+		}
+		handler := &repoHandler{fullName: "test/repo", state: state}
+		triggers := make(chan triggerContext, 1)
+		handler.deb = newDebouncer(time.Millisecond, func(tctx triggerContext) error {
+			triggers <- tctx
+			return nil
+		})
+		ctx, cancel := context.WithCancel(t.Context())
+		go handler.deb.Run(ctx)
+		go handler.scheduleLoop(ctx, s, spec)
+
+		select {
+		case tctx := <-triggers:
+			if !tc.run || tctx.job != "gc" {
+				t.Errorf("%s: unexpected run %+v", tc.missed, tctx)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if tc.run {
+				t.Errorf("%s: missed fire did not run", tc.missed)
+			}
+		}
+		cancel()
+
+		if at, _ := state.scheduleChecked("test/repo", "gc"); !at.After(stopped.Add(time.Hour)) {
+			t.Errorf("%s: checked at %s, want after the restart", tc.missed, at)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// secretsModeEnv injects secrets as environment variables.
+	secretsModeEnv = "env"
+	// secretsModeFiles writes secrets to a private per-run directory and
+	// exports NAME_FILE paths instead.
+	secretsModeFiles = "files"
+)
+
+// redactedSecret replaces secret values in logged output.
+const redactedSecret = "[REDACTED]"
+
+// minRedactedLine is the shortest secret line, in non-whitespace bytes, that
+// redact replaces on its own.
+const minRedactedLine = 8
+
+// envNameRe matches a portable environment variable name.
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateSecrets checks the repo's secret names and mode.
+func validateSecrets(repo *Repo) error {
+	switch repo.SecretsMode {
+	case "", secretsModeEnv, secretsModeFiles:
+	default:
+		return fmt.Errorf("secrets_mode must be %q or %q, got %q",
+			secretsModeEnv, secretsModeFiles, repo.SecretsMode)
+	}
+	for name := range repo.Secrets {
+		if !envNameRe.MatchString(name) {
+			return fmt.Errorf("secrets: invalid variable name %q", name)
+		}
+		if strings.HasPrefix(name, "GH_") {
+			return fmt.Errorf("secrets: %q collides with reserved GH_ variables", name)
+		}
+	}
+	return nil
+}
+
+// loadSecrets reads every configured secret, resolving %d/ paths.
+func loadSecrets(repo *Repo) (map[string][]byte, error) {
+	secrets := make(map[string][]byte, len(repo.Secrets))
+	for name, path := range repo.Secrets {
+		value, err := readSecret(path)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %w", name, err)
+		}
+		secrets[name] = value
+	}
+	return secrets, nil
+}
+
+// secretEnv returns the environment entries exposing the repo's secrets to a
+// single run, plus a cleanup func that removes any per-run files.
+func (h *repoHandler) secretEnv() ([]string, func(), error) {
+	if len(h.secrets) == 0 {
+		return nil, func() {}, nil
+	}
+
+	if h.repo.SecretsMode != secretsModeFiles {
+		env := make([]string, 0, len(h.secrets))
+		for _, name := range sortedKeys(h.secrets) {
+			env = append(env, name+"="+string(h.secrets[name]))
+		}
+		return env, func() {}, nil
+	}
+
+	base := runtimeDir()
+	if base == "" {
+		return nil, nil, fmt.Errorf("create secrets dir: RUNTIME_DIRECTORY is not set")
+	}
+	dir, err := os.MkdirTemp(base, "secrets-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("create secrets dir: %w", err)
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+
+	env := []string{"GH_SECRETS_DIR=" + dir}
+	for _, name := range sortedKeys(h.secrets) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, h.secrets[name], 0o400); err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("write secret %s: %w", name, err)
+		}
+		env = append(env, name+"_FILE="+path)
+	}
+
+	// Hand the directory to the run_as user so it can read its secrets.
+	if runAs := h.repo.RunAs; runAs != nil {
+		paths := []string{dir}
+		for _, name := range sortedKeys(h.secrets) {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+		for _, path := range paths {
+			if err := os.Chown(path, int(runAs.UID), int(runAs.GID)); err != nil {
+				cleanup()
+				return nil, nil, fmt.Errorf("chown secrets: %w", err)
+			}
+		}
+	}
+
+	return env, cleanup, nil
+}
+
+// runtimeDir returns the service's RuntimeDirectory, the tmpfs-backed parent
+// of per-run secret directories, or "" when unset.
+func runtimeDir() string {
+	// RUNTIME_DIRECTORY may list several colon-separated paths.
+	first, _, _ := strings.Cut(os.Getenv("RUNTIME_DIRECTORY"), ":")
+	return first
+}
+
+// checkSecretsDir checks that a files-mode repo has a RuntimeDirectory to
+// write its secrets to, rather than a disk-backed temp dir.
+func checkSecretsDir(repo *Repo) error {
+	if repo.SecretsMode != secretsModeFiles || len(repo.Secrets) == 0 {
+		return nil
+	}
+	if runtimeDir() == "" {
+		return fmt.Errorf("secrets_mode %q requires RUNTIME_DIRECTORY", secretsModeFiles)
+	}
+	return nil
+}
+
+// redact replaces every secret value in s. Output is redacted line by line,
+// so each line of a multi-line secret (e.g. a PEM key) is replaced as well.
+// Lines shorter than minRedactedLine non-whitespace bytes (a PEM's "AB==" or
+// closing brace) are only replaced as part of the whole value, so they don't
+// blank out unrelated output.
+func (h *repoHandler) redact(s string) string {
+	for _, value := range h.secrets {
+		if len(value) == 0 {
+			continue
+		}
+		s = strings.ReplaceAll(s, string(value), redactedSecret)
+		for _, line := range strings.Split(string(value), "\n") {
+			line = strings.TrimSpace(line)
+			if nonSpaceLen(line) >= minRedactedLine {
+				s = strings.ReplaceAll(s, line, redactedSecret)
+			}
+		}
+	}
+	return s
+}
+
+// nonSpaceLen counts the non-whitespace bytes of s.
+func nonSpaceLen(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ' ', '\t', '\n', '\r', '\v', '\f':
+		default:
+			n++
+		}
+	}
+	return n
+}
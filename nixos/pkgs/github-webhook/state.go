@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateVersion is the current state file format.
+const stateVersion = 1
+
+// stateStore remembers the last successful commit per repo, job and branch,
+// each repo's admin deploy lock and triggers held by a freeze, and the repos
+// enabled from GitHub App installation events.
+// With a path, it is loaded at startup and rewritten atomically after every
+// success so it survives restarts; without one it only lives in memory. A
+// nil stateStore is valid and remembers nothing.
+type stateStore struct {
+	path string
+
+	mu   sync.Mutex
+	data stateData
+}
+
+// stateData is the state file format.
+type stateData struct {
+	Version int                   `json:"version"`
+	Repos   map[string]*repoState `json:"repos"`
+}
+
+// repoState is one repo's persisted state.
+type repoState struct {
+	// Jobs maps job name ("" for the default job) -> branch -> last success.
+	Jobs map[string]map[string]successRecord `json:"jobs"`
+	// Lock is the admin deploy lock, if any.
+	Lock *deployLock `json:"lock,omitempty"`
+	// Held maps job -> branch -> the newest trigger held by a freeze.
+	Held map[string]map[string]heldTrigger `json:"held,omitempty"`
+	// Schedules maps schedule name -> when its fire times were last
+	// handled, so fires missed while the daemon was down are noticed.
+	Schedules map[string]time.Time `json:"schedules,omitempty"`
+	// AutoEnabled is set for repos enabled from an installation event.
+	AutoEnabled bool `json:"auto_enabled,omitempty"`
+}
+
+// successRecord is the last successful run of a job on a branch.
+type successRecord struct {
+	Commit string    `json:"commit"`
+	RunID  string    `json:"run_id"`
+	At     time.Time `json:"at"`
+}
+
+// openStateStore loads the state file at path. A missing file is an empty
+// state; an empty path keeps state in memory only.
+func openStateStore(path string) (*stateStore, error) {
+	s := &stateStore{
+		path: path,
+		data: stateData{Version: stateVersion, Repos: make(map[string]*repoState)},
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.data); err != nil {
+		return nil, fmt.Errorf("parse state %s: %w", path, err)
+	}
+	if s.data.Version != stateVersion {
+		return nil, fmt.Errorf("state %s: unsupported version %d", path, s.data.Version)
+	}
+	if s.data.Repos == nil {
+		s.data.Repos = make(map[string]*repoState)
+	}
+	return s, nil
+}
+
+// detach keeps s in memory only: it keeps what it read, but no longer writes
+// the state file.
+func (s *stateStore) detach() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = ""
+}
+
+// lastSuccess returns the last successful run of repo's job on branch.
+func (s *stateStore) lastSuccess(repo, job, branch string) (successRecord, bool) {
+	if s == nil {
+		return successRecord{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs := s.data.Repos[repo]
+	if rs == nil {
+		return successRecord{}, false
+	}
+	rec, ok := rs.Jobs[job][branch]
+	return rec, ok
+}
+
+// recordSuccess stores a successful run and persists the state.
+func (s *stateStore) recordSuccess(repo, job, branch string, rec successRecord) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs := s.data.Repos[repo]
+	if rs == nil {
+		rs = &repoState{}
+		s.data.Repos[repo] = rs
+	}
+	if rs.Jobs == nil {
+		rs.Jobs = make(map[string]map[string]successRecord)
+	}
+	if rs.Jobs[job] == nil {
+		rs.Jobs[job] = make(map[string]successRecord)
+	}
+	rs.Jobs[job][branch] = rec
+
+	return s.save()
+}
+
+// lock returns repo's admin deploy lock. It may have expired.
+func (s *stateStore) lock(repo string) (deployLock, bool) {
+	if s == nil {
+		return deployLock{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs := s.data.Repos[repo]
+	if rs == nil || rs.Lock == nil {
+		return deployLock{}, false
+	}
+	return *rs.Lock, true
+}
+
+// setLock sets, or with nil clears, repo's admin deploy lock and persists the
+// state.
+func (s *stateStore) setLock(repo string, lock *deployLock) error {
+	if s == nil {
+		return errors.New("no state store")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs := s.data.Repos[repo]
+	if rs == nil {
+		rs = &repoState{}
+		s.data.Repos[repo] = rs
+	}
+	rs.Lock = lock
+
+	return s.save()
+}
+
+// held returns repo's held triggers as job -> branch -> trigger.
+func (s *stateStore) held(repo string) map[string]map[string]heldTrigger {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs := s.data.Repos[repo]
+	if rs == nil {
+		return nil
+	}
+	held := make(map[string]map[string]heldTrigger, len(rs.Held))
+	for job, branches := range rs.Held {
+		held[job] = maps.Clone(branches)
+	}
+	return held
+}
+
+// setHeld replaces repo's held triggers (job -> branch -> trigger) and
+// persists the state.
+func (s *stateStore) setHeld(repo string, held map[string]map[string]heldTrigger) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs := s.data.Repos[repo]
+	if rs == nil {
+		if len(held) == 0 {
+			return nil
+		}
+		rs = &repoState{}
+		s.data.Repos[repo] = rs
+	}
+	if len(held) == 0 {
+		if rs.Held == nil {
+			return nil
+		}
+		held = nil
+	}
+	rs.Held = held
+
+	return s.save()
+}
+
+// scheduleChecked returns when repo's schedule last handled its fire times.
+func (s *stateStore) scheduleChecked(repo, name string) (time.Time, bool) {
+	if s == nil {
+		return time.Time{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs := s.data.Repos[repo]
+	if rs == nil {
+		return time.Time{}, false
+	}
+	at, ok := rs.Schedules[name]
+	return at, ok
+}
+
+// setScheduleChecked records when repo's schedule handled its fire times and
+// persists the state.
+func (s *stateStore) setScheduleChecked(repo, name string, at time.Time) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs := s.data.Repos[repo]
+	if rs == nil {
+		rs = &repoState{}
+		s.data.Repos[repo] = rs
+	}
+	if rs.Schedules == nil {
+		rs.Schedules = make(map[string]time.Time)
+	}
+	rs.Schedules[name] = at
+
+	return s.save()
+}
+
+// autoEnabled returns the repos enabled from installation events, sorted.
+func (s *stateStore) autoEnabled() []string {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var repos []string
+	for _, repo := range sortedKeys(s.data.Repos) {
+		if s.data.Repos[repo].AutoEnabled {
+			repos = append(repos, repo)
+		}
+	}
+	return repos
+}
+
+// setAutoEnabled records whether repo was enabled from an installation event
+// and persists the state.
+func (s *stateStore) setAutoEnabled(repo string, enabled bool) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs := s.data.Repos[repo]
+	if rs == nil {
+		rs = &repoState{}
+		s.data.Repos[repo] = rs
+	}
+	rs.AutoEnabled = enabled
+
+	return s.save()
+}
+
+// save atomically rewrites the state file. Callers hold s.mu.
+func (s *stateStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".state-*.json")
+	if err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunCommandSkipsSucceededCommit persists successes across restarts,
+// skips a commit that already succeeded unless forced, and passes
+// GH_PREVIOUS_COMMIT.
+func TestRunCommandSkipsSucceededCommit(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	outPath := filepath.Join(dir, "runs")
+
+	newHandler := func() *repoHandler {
+		t.Helper()
+		state, err := openStateStore(statePath)
+		if err != nil {
+			t.Fatalf("openStateStore: %v", err)
+		}
+		return &repoHandler{
+			fullName: "test/repo",
+			repo: Repo{
+				Command: []string{"sh", "-c",
+					`echo "$GH_COMMIT prev=$GH_PREVIOUS_COMMIT" >> ` + outPath},
+				WorkingDir: dir,
+			},
+			timeout: 5 * time.Second,
+			state:   state,
+		}
+	}
+	push := func(commit string) triggerContext {
+		return triggerContext{event: "push", branch: "master", commit: commit}
+	}
+	runs := func() []string {
+		t.Helper()
+		data, err := os.ReadFile(outPath)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("read runs: %v", err)
+		}
+		return strings.Fields(strings.ReplaceAll(string(data), "\n", " "))
+	}
+
+	handler := newHandler()
+	if err := handler.runCommand(t.Context(), push("aaa")); err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+
+	// A restarted daemon skips the same commit...
+	handler = newHandler()
+	if err := handler.runCommand(t.Context(), push("aaa")); err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+	// ...unless forced, and passes the previous commit for a new one.
+	tctx := push("aaa")
+	tctx.force = true
+	if err := handler.runCommand(t.Context(), tctx); err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+	if err := handler.runCommand(t.Context(), push("bbb")); err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+
+	want := []string{"aaa", "prev=", "aaa", "prev=aaa", "bbb", "prev=aaa"}
+	if got := runs(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("runs = %q, want %q", got, want)
+	}
+
+	// Scheduled jobs are tracked separately from the webhook command.
+	state, err := openStateStore(statePath)
+	if err != nil {
+		t.Fatalf("openStateStore: %v", err)
+	}
+	if rec, _ := state.lastSuccess("test/repo", "", "master"); rec.Commit != "bbb" {
+		t.Errorf("last success = %q, want bbb", rec.Commit)
+	}
+	if _, ok := state.lastSuccess("test/repo", "nightly", "master"); ok {
+		t.Errorf("unexpected success for job nightly")
+	}
+}
+
+// TestOpenStateStoreRejectsCorruptFile fails loudly instead of forgetting
+// every deployed commit.
+func TestOpenStateStoreRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatalf("write state: %v", err)
+	}
+	if _, err := openStateStore(path); err == nil {
+		t.Fatalf("expected error for corrupt state file")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// changedPathsTimeout bounds the `git diff` listing a run's changed files.
+const changedPathsTimeout = time.Minute
+
+// stepEvents lists the events a step condition can match.
+var stepEvents = []string{"push", "poll", "startup", "schedule", eventRelease, eventWorkflowRun}
+
+// Step is one stage of a repo's pipeline. Steps run in order within each
+// attempt; a retry re-runs the whole pipeline.
+type Step struct {
+	// Name identifies the step in logs, the dashboard and as GH_STEP.
+	Name string `json:"name"`
+	// Command is the step's argv.
+	Command []string `json:"command"`
+	// TimeoutMs bounds the step. 0 uses the repo's timeout_ms.
+	TimeoutMs int `json:"timeout_ms"`
+	// ContinueOnError records a failure of this step but keeps going; it
+	// does not fail the run.
+	ContinueOnError bool `json:"continue_on_error"`
+	// If restricts when the step runs. nil always runs.
+	If *StepCondition `json:"if"`
+}
+
+// StepCondition restricts a step to matching runs. Every non-empty field
+// must match; within a field any entry may match.
+type StepCondition struct {
+	// Branches lists branch names.
+	Branches []string `json:"branches"`
+	// Events lists trigger events ("push", "poll", "startup", "schedule",
+	// "release", "workflow_run").
+	Events []string `json:"events"`
+	// Paths lists patterns matched against the files changed since the
+	// job's last successful commit: path.Match globs, or "dir/**" for
+	// everything under dir. They are diffed (`git diff` in working_dir, as
+	// run_as) when the step is reached, so an earlier step can fetch. If the
+	// changed files are unknown (no previous commit, or the diff fails), the
+	// step runs. Pushes and workflow runs whose commit is not a full hex
+	// object id are dropped when routed.
+	Paths []string `json:"paths"`
+}
+
+// validate checks a step. Errors are prefixed with the field name.
+func (s *Step) validate() []error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if s.Name == "" {
+		add("name: required")
+	}
+	if len(s.Command) == 0 || s.Command[0] == "" {
+		add("command: required")
+	}
+	if s.TimeoutMs < 0 {
+		add("timeout_ms: must be >= 0, got %d", s.TimeoutMs)
+	}
+	if c := s.If; c != nil {
+		for _, event := range c.Events {
+			if !slices.Contains(stepEvents, event) {
+				add("if.events: unknown event %q (want one of %s)",
+					event, strings.Join(stepEvents, ", "))
+			}
+		}
+		for _, pattern := range c.Paths {
+			if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+				add("if.paths: invalid pattern %q", pattern)
+			}
+		}
+	}
+	return errs
+}
+
+// stepsFor returns the pipeline of tctx: a scheduled or event-triggered
+// job's own command, its branch's command, the repo's steps, or the repo
+// command as a single unnamed step.
+func (h *repoHandler) stepsFor(tctx triggerContext) []*Step {
+	for _, s := range h.repo.Schedule {
+		if s.Name == tctx.job && len(s.Command) > 0 {
+			return []*Step{{Command: s.Command}}
+		}
+	}
+	if et := h.eventTrigger(tctx.job); et != nil && len(et.Command) > 0 {
+		return []*Step{{Command: et.Command}}
+	}
+	if b := h.repo.branch(tctx.branch); b != nil && len(b.Command) > 0 {
+		return []*Step{{Command: b.Command}}
+	}
+	if len(h.repo.Steps) > 0 {
+		return h.repo.Steps
+	}
+	if len(h.repo.Command) == 0 {
+		return nil
+	}
+	return []*Step{{Command: h.repo.Command}}
+}
+
+// stepTimeout returns the timeout of step in a run on branch.
+func (h *repoHandler) stepTimeout(step *Step, branch string) time.Duration {
+	if step.TimeoutMs > 0 {
+		return time.Duration(step.TimeoutMs) * time.Millisecond
+	}
+	return h.timeoutFor(branch)
+}
+
+// stepEnabled evaluates step.If for tctx. It returns "" if the step should
+// run, or why it is skipped. changed lists the run's changed files (ok false
+// if unknown) and is only called for path conditions.
+func stepEnabled(
+	step *Step,
+	tctx triggerContext,
+	changed func() (files []string, ok bool),
+) (skipReason string) {
+	c := step.If
+	if c == nil {
+		return ""
+	}
+	if len(c.Branches) > 0 && !slices.Contains(c.Branches, tctx.branch) {
+		return "branch " + tctx.branch + " not in if.branches"
+	}
+	if len(c.Events) > 0 && !slices.Contains(c.Events, tctx.event) {
+		return "event " + tctx.event + " not in if.events"
+	}
+	if len(c.Paths) > 0 {
+		files, ok := changed()
+		if ok && !slices.ContainsFunc(files, func(f string) bool {
+			return slices.ContainsFunc(c.Paths, func(p string) bool {
+				return matchPath(p, f)
+			})
+		}) {
+			return "no changed file matches if.paths"
+		}
+	}
+	return ""
+}
+
+// matchPath reports whether file matches pattern: a path.Match glob, or
+// "dir/**" for anything under dir.
+func matchPath(pattern, file string) bool {
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return strings.HasPrefix(file, dir+"/")
+	}
+	ok, _ := path.Match(pattern, file)
+	return ok
+}
+
+// changedPaths lists the files changed between tctx's previous successful
+// commit and its commit, using `git diff` in the working dir with the repo's
+// run_as and isolation settings.
+func (h *repoHandler) changedPaths(ctx context.Context, tctx triggerContext) ([]string, error) {
+	if tctx.previousCommit == "" || tctx.commit == "" {
+		return nil, errors.New("no previous commit")
+	}
+	if !isCommitID(tctx.previousCommit) || !isCommitID(tctx.commit) {
+		return nil, errors.New("invalid commit id")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, changedPathsTimeout)
+	defer cancel()
+
+	cmd := h.gitCmd(ctx, h.workingDir(tctx.branch), changedPathsTimeout,
+		"diff", "--name-only", "-z", "--end-of-options", tctx.previousCommit, tctx.commit)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.FieldsFunc(string(out), func(r rune) bool { return r == 0 }), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunCommandSteps records each step separately, skips steps whose
+// conditions don't match, keeps going past continue_on_error failures and
+// stops at the first other failure.
+func TestRunCommandSteps(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(file string) string {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(file), 0o644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+		git("add", file)
+		git("-c", "user.email=test@example.com", "-c", "user.name=tester",
+			"-c", "commit.gpgsign=false", "commit", "-m", file)
+		return git("rev-parse", "HEAD")
+	}
+	git("init")
+	first := commit("README.md")
+	second := commit("docs/index.md")
+
+	state, err := openStateStore("")
+	if err != nil {
+		t.Fatalf("openStateStore: %v", err)
+	}
+	if err := state.recordSuccess("test/repo", "", "master", successRecord{Commit: first}); err != nil {
+		t.Fatalf("recordSuccess: %v", err)
+	}
+
+	sh := func(script string) []string { return []string{"sh", "-c", script} }
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			WorkingDir: dir,
+			Steps: []*Step{
+				{Name: "lint", Command: sh("echo lint $GH_STEP; exit 2"), ContinueOnError: true},
+				{Name: "release", Command: sh("echo release"), If: &StepCondition{Branches: []string{"release"}}},
+				{Name: "pkgs", Command: sh("echo pkgs"), If: &StepCondition{Paths: []string{"pkgs/**"}}},
+				{Name: "docs", Command: sh("echo docs"), If: &StepCondition{Paths: []string{"docs/*.md"}}},
+				{Name: "build", Command: sh("exec sleep 5"), TimeoutMs: 50},
+				{Name: "deploy", Command: sh("echo deploy")},
+			},
+		},
+		timeout: 5 * time.Second,
+		state:   state,
+		history: newRunHistory(),
+	}
+
+	tctx := triggerContext{event: "push", branch: "master", commit: second, runID: "r1"}
+	err = handler.runCommand(t.Context(), tctx)
+	if err == nil || !strings.Contains(err.Error(), "step build failed") {
+		t.Fatalf("runCommand err = %v, want step build failed", err)
+	}
+
+	rec, ok := handler.history.get("r1")
+	if !ok {
+		t.Fatalf("run not recorded")
+	}
+	var got []string
+	for _, step := range rec.Steps {
+		got = append(got, step.Name+"="+step.Status)
+	}
+	want := "lint=failure release=skipped pkgs=skipped docs=success build=failure"
+	if strings.Join(got, " ") != want {
+		t.Errorf("steps = %s, want %s", strings.Join(got, " "), want)
+	}
+	if rec.Steps[0].ExitCode != 2 {
+		t.Errorf("lint exit code = %d, want 2", rec.Steps[0].ExitCode)
+	}
+
+	output := strings.Join(rec.Output, "\n")
+	for _, line := range []string{"==> attempt 1, step lint: sh -c", "lint lint", "docs"} {
+		if !strings.Contains(output, line) {
+			t.Errorf("output missing %q:\n%s", line, output)
+		}
+	}
+	if strings.Contains(output, "deploy") {
+		t.Errorf("deploy ran after a failed step:\n%s", output)
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, file string
+		want          bool
+	}{
+		{"flake.lock", "flake.lock", true},
+		{"*.nix", "default.nix", true},
+		{"*.nix", "pkgs/default.nix", false},
+		{"pkgs/**", "pkgs/a/default.nix", true},
+		{"pkgs/**", "pkgs", false},
+		{"pkgs/*/default.nix", "pkgs/a/default.nix", true},
+	} {
+		if got := matchPath(tc.pattern, tc.file); got != tc.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tc.pattern, tc.file, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// traceExportInterval is how often queued spans are sent to the collector.
+	traceExportInterval = 5 * time.Second
+	// traceExportTimeout bounds one export request.
+	traceExportTimeout = 10 * time.Second
+	// traceMaxQueued caps spans buffered between exports; extra spans are
+	// dropped so an unreachable collector cannot grow memory without bound.
+	traceMaxQueued = 4096
+
+	// OTLP span kinds and status codes.
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanStatusError  = 2
+)
+
+// TracingConfig enables OTLP/HTTP (JSON) trace export. Each delivery is a
+// trace: "webhook delivery" -> "route" -> "verify signature", and for
+// triggered runs "run" -> "debounce wait", "queue wait" and "command" (one
+// per attempt). Poll, schedule and startup runs start their own trace. When
+// several deliveries coalesce into one run, the run joins the trace of the
+// last one.
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP base URL of a collector, e.g.
+	// "http://127.0.0.1:4318". Spans are POSTed as JSON to /v1/traces.
+	Endpoint string `json:"endpoint"`
+
+	// ServiceName is the service.name resource attribute (default
+	// "github-webhook").
+	ServiceName string `json:"service_name"`
+}
+
+// validate checks the tracing config. Errors are prefixed with the field
+// name.
+func (tc *TracingConfig) validate() error {
+	u, err := url.Parse(tc.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint: must be an http(s) URL, got %q", tc.Endpoint)
+	}
+	return nil
+}
+
+// spanContext identifies a span within a trace.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// valid reports whether sc belongs to a trace.
+func (sc spanContext) valid() bool {
+	return sc.traceID != [16]byte{}
+}
+
+// traceparent formats sc as a W3C Trace Context `traceparent` value.
+func (sc spanContext) traceparent() string {
+	return "00-" + hex.EncodeToString(sc.traceID[:]) + "-" +
+		hex.EncodeToString(sc.spanID[:]) + "-01"
+}
+
+// tracer records spans and periodically exports them to an OTLP/HTTP
+// collector. A nil tracer is valid and records nothing.
+type tracer struct {
+	url         string
+	serviceName string
+	client      *http.Client
+
+	mu      sync.Mutex
+	queued  []otlpSpan
+	dropped int
+}
+
+// newTracer returns a tracer for cfg, or nil if tracing is disabled.
+func newTracer(cfg *TracingConfig) *tracer {
+	if cfg == nil || cfg.Endpoint == "" {
+		return nil
+	}
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "github-webhook"
+	}
+	return &tracer{
+		url:         strings.TrimSuffix(cfg.Endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: traceExportTimeout},
+	}
+}
+
+// span is an in-progress span. A nil span is valid and records nothing.
+type span struct {
+	t      *tracer
+	sc     spanContext
+	parent [8]byte
+	name   string
+	kind   int
+	start  time.Time
+	attrs  []otlpKeyValue
+}
+
+// start begins a span under parent, or a new trace if parent is invalid.
+// attrs are alternating keys and values, like slog.
+func (t *tracer) start(parent spanContext, name string, attrs ...any) *span {
+	return t.startAt(parent, name, time.Now(), attrs...)
+}
+
+// startServer begins a root span for an incoming request.
+func (t *tracer) startServer(name string, attrs ...any) *span {
+	s := t.start(spanContext{}, name, attrs...)
+	if s != nil {
+		s.kind = spanKindServer
+	}
+	return s
+}
+
+// startAt is start with an explicit start time, for spans covering work that
+// began before it could be traced (e.g. debounce waits).
+func (t *tracer) startAt(parent spanContext, name string, start time.Time, attrs ...any) *span {
+	if t == nil {
+		return nil
+	}
+	s := &span{t: t, name: name, kind: spanKindInternal, start: start}
+	if parent.valid() {
+		s.sc.traceID = parent.traceID
+		s.parent = parent.spanID
+	} else {
+		_, _ = rand.Read(s.sc.traceID[:])
+	}
+	_, _ = rand.Read(s.sc.spanID[:])
+	s.set(attrs...)
+	return s
+}
+
+// context returns the span's context for starting children.
+func (s *span) context() spanContext {
+	if s == nil {
+		return spanContext{}
+	}
+	return s.sc
+}
+
+// set adds alternating key/value attributes.
+func (s *span) set(attrs ...any) {
+	if s == nil {
+		return
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		key, _ := attrs[i].(string)
+		s.attrs = append(s.attrs, otlpAttr(key, attrs[i+1]))
+	}
+}
+
+// end finishes the span, marking it failed if err is non-nil, and queues it
+// for export.
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+
+	rec := otlpSpan{
+		TraceID:           hex.EncodeToString(s.sc.traceID[:]),
+		SpanID:            hex.EncodeToString(s.sc.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes:        s.attrs,
+	}
+	if s.parent != [8]byte{} {
+		rec.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	if err != nil {
+		rec.Status = &otlpStatus{Code: spanStatusError, Message: err.Error()}
+	}
+
+	t := s.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queued) >= traceMaxQueued {
+		t.dropped++
+		return
+	}
+	t.queued = append(t.queued, rec)
+}
+
+// run exports queued spans every traceExportInterval until ctx is done, then
+// flushes once more.
+func (t *tracer) run(ctx context.Context) {
+	if t == nil {
+		return
+	}
+
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), traceExportTimeout)
+			t.flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			t.flush(ctx)
+		}
+	}
+}
+
+// flush exports all queued spans. Export failures are logged and the spans
+// dropped; traces are best-effort.
+func (t *tracer) flush(ctx context.Context) {
+	t.mu.Lock()
+	spans, dropped := t.queued, t.dropped
+	t.queued, t.dropped = nil, 0
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		slog.Warn("trace queue full, dropped spans", "spans", dropped)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.export(ctx, spans); err != nil {
+		slog.Warn("trace export failed", "spans", len(spans), "err", err)
+	}
+}
+
+// export POSTs spans to the collector as an OTLP/JSON request.
+func (t *tracer) export(ctx context.Context, spans []otlpSpan) error {
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			otlpAttr("service.name", t.serviceName),
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github-webhook"},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP/JSON wire types (opentelemetry-proto, JSON encoding). Only the fields
+// the daemon emits are declared.
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            *otlpStatus    `json:"status,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+		BoolValue   *bool   `json:"boolValue,omitempty"`
+	}
+)
+
+// otlpAttr converts a key/value pair to an OTLP attribute. Integers use
+// intValue (a decimal string in OTLP/JSON); everything else is stringified.
+func otlpAttr(key string, value any) otlpKeyValue {
+	var v otlpValue
+	switch x := value.(type) {
+	case int:
+		s := strconv.Itoa(x)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		v.IntValue = &s
+	case bool:
+		v.BoolValue = &x
+	case string:
+		v.StringValue = &x
+	default:
+		s := fmt.Sprint(x)
+		v.StringValue = &s
+	}
+	return otlpKeyValue{Key: key, Value: v}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+// TestTracingDeliveryToCommand exports one trace covering the delivery and
+// the run it triggers, and hands the command span to the command as
+// TRACEPARENT.
+func TestTracingDeliveryToCommand(t *testing.T) {
+	var mu sync.Mutex
+	var spans []otlpSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("unexpected export path %s", r.URL.Path)
+		}
+		var req otlpTraces
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode export: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	secret := []byte("supersecret")
+	work := t.TempDir()
+	tr := newTracer(&TracingConfig{Endpoint: collector.URL})
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Branches:   []Branch{{Name: "master"}},
+			Command:    []string{"sh", "-c", `echo "$TRACEPARENT" > traceparent.txt`},
+			WorkingDir: work,
+		},
+		secret:  secret,
+		timeout: 5 * time.Second,
+		sem:     newRunSemaphore(1),
+		tracer:  tr,
+	}
+	done := make(chan error, 1)
+	handler.deb = newDebouncer(5*time.Millisecond, func(tctx triggerContext) error {
+		err := handler.runCommand(t.Context(), tctx)
+		done <- err
+		return err
+	})
+	go handler.deb.Run(t.Context())
+
+	a := &app{handlers: map[string]*repoHandler{"test/repo": handler}, tracer: tr}
+
+	body := []byte(`{"ref":"refs/heads/master","after":"abc1230000000000000000000000000000000000","repository":{"full_name":"test/repo"}}`)
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-Hub-Signature-256", webhook.Sign(secret, body))
+	rr := httptest.NewRecorder()
+	a.handleWebhook(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("runCommand: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("run did not happen")
+	}
+	tr.flush(t.Context())
+
+	mu.Lock()
+	defer mu.Unlock()
+	byName := make(map[string]otlpSpan)
+	for _, s := range spans {
+		byName[s.Name] = s
+	}
+
+	parents := map[string]string{
+		"webhook delivery": "",
+		"route":            "webhook delivery",
+		"verify signature": "route",
+		"run":              "webhook delivery",
+		"debounce wait":    "run",
+		"queue wait":       "run",
+		"command":          "run",
+	}
+	root := byName["webhook delivery"]
+	for name, parent := range parents {
+		s, ok := byName[name]
+		if !ok {
+			t.Errorf("missing span %q", name)
+			continue
+		}
+		if s.TraceID != root.TraceID {
+			t.Errorf("span %q in trace %s, want %s", name, s.TraceID, root.TraceID)
+		}
+		if s.ParentSpanID != byName[parent].SpanID {
+			t.Errorf("span %q parent %s, want %q (%s)",
+				name, s.ParentSpanID, parent, byName[parent].SpanID)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(work, "traceparent.txt"))
+	if err != nil {
+		t.Fatalf("read traceparent: %v", err)
+	}
+	cmdSpan := byName["command"]
+	want := "00-" + cmdSpan.TraceID + "-" + cmdSpan.SpanID + "-01"
+	if got := strings.TrimSpace(string(data)); got != want {
+		t.Fatalf("TRACEPARENT = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+const (
+	eventRelease     = "release"
+	eventWorkflowRun = "workflow_run"
+)
+
+// EventTrigger is a job run by release or workflow_run deliveries that pass
+// its filters. Like schedules, it runs through the repo's debouncer with
+// GH_JOB set to its name.
+type EventTrigger struct {
+	// Name identifies the job in logs and as GH_JOB. Names are unique across
+	// the repo's triggers and schedule.
+	Name string `json:"name"`
+	// Event is "release" or "workflow_run".
+	Event string `json:"event"`
+	// Command overrides the repo command (or steps) for this job.
+	Command []string `json:"command"`
+	// Environment reports this job's runs as GitHub deployments to the
+	// named environment.
+	Environment string `json:"environment"`
+
+	// Actions lists matching payload actions. Default: "published" for
+	// release, "completed" for workflow_run.
+	Actions []string `json:"actions"`
+
+	// Prerelease, if set, only matches releases whose prerelease flag
+	// equals it.
+	Prerelease *bool `json:"prerelease"`
+
+	// Workflows lists matching workflow names. Empty matches any.
+	Workflows []string `json:"workflows"`
+	// Conclusions lists matching workflow run conclusions. Default:
+	// "success".
+	Conclusions []string `json:"conclusions"`
+	// Branches lists matching workflow run head branches. Default: the
+	// repo's branches.
+	Branches []string `json:"branches"`
+	// Events lists matching workflow run trigger events. Default: "push".
+	// Runs whose head commit lives in another repository (forks) never
+	// match, whatever their head branch is named.
+	Events []string `json:"events"`
+}
+
+// validate checks a trigger. Errors are prefixed with the field name.
+func (et *EventTrigger) validate() []error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if et.Name == "" {
+		add("name: required")
+	}
+	switch et.Event {
+	case eventRelease:
+		if len(et.Workflows) > 0 || len(et.Conclusions) > 0 || len(et.Branches) > 0 || len(et.Events) > 0 {
+			add("workflows, conclusions, branches and events only apply to workflow_run")
+		}
+	case eventWorkflowRun:
+		if et.Prerelease != nil {
+			add("prerelease: only applies to release")
+		}
+	default:
+		add("event: must be %q or %q, got %q", eventRelease, eventWorkflowRun, et.Event)
+	}
+	return errs
+}
+
+// actions returns the matching actions, applying the event's default.
+func (et *EventTrigger) actions() []string {
+	switch {
+	case len(et.Actions) > 0:
+		return et.Actions
+	case et.Event == eventRelease:
+		return []string{"published"}
+	default:
+		return []string{"completed"}
+	}
+}
+
+// releaseEvent models GitHub release webhook payload (minimal fields).
+type releaseEvent struct {
+	Action  string `json:"action"`
+	Release struct {
+		TagName    string `json:"tag_name"`
+		Prerelease bool   `json:"prerelease"`
+	} `json:"release"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+}
+
+// workflowRunEvent models GitHub workflow_run webhook payload (minimal
+// fields).
+type workflowRunEvent struct {
+	Action      string `json:"action"`
+	WorkflowRun struct {
+		ID         int64  `json:"id"`
+		Name       string `json:"name"`
+		HeadBranch string `json:"head_branch"`
+		HeadSHA    string `json:"head_sha"`
+		Conclusion string `json:"conclusion"`
+		Event      string `json:"event"`
+
+		HeadRepository struct {
+			FullName string `json:"full_name"`
+		} `json:"head_repository"`
+	} `json:"workflow_run"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+}
+
+// eventTrigger returns the trigger named job, or nil.
+func (h *repoHandler) eventTrigger(job string) *EventTrigger {
+	for _, et := range h.repo.Triggers {
+		if et.Name == job {
+			return et
+		}
+	}
+	return nil
+}
+
+// eventRuns returns a run for each of the repo's triggers matching a
+// release or workflow_run payload.
+func (h *repoHandler) eventRuns(event string, payload []byte) ([]triggerContext, error) {
+	var runs []triggerContext
+	switch event {
+	case eventRelease:
+		var p releaseEvent
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return nil, &webhook.StatusError{Status: http.StatusBadRequest, Msg: "invalid json"}
+		}
+		for _, et := range h.repo.Triggers {
+			if et.Event != eventRelease ||
+				!slices.Contains(et.actions(), p.Action) ||
+				(et.Prerelease != nil && *et.Prerelease != p.Release.Prerelease) {
+				continue
+			}
+			runs = append(runs, triggerContext{
+				event:      event,
+				ref:        "refs/tags/" + p.Release.TagName,
+				sender:     p.Sender.Login,
+				job:        et.Name,
+				releaseTag: p.Release.TagName,
+			})
+		}
+
+	case eventWorkflowRun:
+		var p workflowRunEvent
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return nil, &webhook.StatusError{Status: http.StatusBadRequest, Msg: "invalid json"}
+		}
+		wr := p.WorkflowRun
+		// A fork's pull request runs carry the fork's branch name and
+		// commit; only runs of the delivery's own commits may deploy.
+		if wr.HeadRepository.FullName == "" || wr.HeadRepository.FullName != p.Repository.FullName {
+			break
+		}
+		if !isCommitID(wr.HeadSHA) {
+			break
+		}
+		for _, et := range h.repo.Triggers {
+			conclusions := et.Conclusions
+			if len(conclusions) == 0 {
+				conclusions = []string{"success"}
+			}
+			branches := et.Branches
+			if len(branches) == 0 {
+				branches = h.repo.branchNames()
+			}
+			events := et.Events
+			if len(events) == 0 {
+				events = []string{"push"}
+			}
+			if et.Event != eventWorkflowRun ||
+				!slices.Contains(et.actions(), p.Action) ||
+				(len(et.Workflows) > 0 && !slices.Contains(et.Workflows, wr.Name)) ||
+				!slices.Contains(conclusions, wr.Conclusion) ||
+				!slices.Contains(events, wr.Event) ||
+				!slices.Contains(branches, wr.HeadBranch) {
+				continue
+			}
+			runs = append(runs, triggerContext{
+				event:         event,
+				ref:           "refs/heads/" + wr.HeadBranch,
+				branch:        wr.HeadBranch,
+				commit:        wr.HeadSHA,
+				sender:        p.Sender.Login,
+				job:           et.Name,
+				workflowRunID: wr.ID,
+			})
+		}
+	}
+	return runs, nil
+}
+
+// eventEnv returns the event-specific GH_* variables of a run.
+func eventEnv(tctx triggerContext) []string {
+	var env []string
+	if tctx.releaseTag != "" {
+		env = append(env, "GH_RELEASE_TAG="+tctx.releaseTag)
+	}
+	if tctx.workflowRunID != 0 {
+		env = append(env, "GH_WORKFLOW_RUN_ID="+strconv.FormatInt(tctx.workflowRunID, 10))
+	}
+	return env
+}
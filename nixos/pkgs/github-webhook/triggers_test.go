@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/phlip9/github-webhook/webhook"
+)
+
+// TestRouteEventTriggers runs the jobs whose filters match release and
+// workflow_run deliveries, and ignores pushes with disable_push.
+func TestRouteEventTriggers(t *testing.T) {
+	secret := []byte("supersecret")
+	no := false
+	handler := &repoHandler{
+		fullName: "test/repo",
+		repo: Repo{
+			Branches:    []Branch{{Name: "master"}},
+			Command:     []string{"true"},
+			DisablePush: true,
+			Triggers: []*EventTrigger{
+				{Name: "release", Event: eventRelease, Prerelease: &no},
+				{Name: "any-release", Event: eventRelease, Actions: []string{"published", "prereleased"}},
+				{Name: "green-ci", Event: eventWorkflowRun, Workflows: []string{"CI"}},
+			},
+		},
+		secret: secret,
+	}
+	a := &app{handlers: map[string]*repoHandler{"test/repo": handler}}
+
+	route := func(event, body string) []triggerContext {
+		t.Helper()
+		header := http.Header{}
+		header.Set("X-GitHub-Event", event)
+		header.Set("X-Hub-Signature-256", webhook.Sign(secret, []byte(body)))
+		d, err := a.route(t.Context(), spanContext{}, header, []byte(body))
+		if err != nil {
+			t.Fatalf("route %s: %v", event, err)
+		}
+		return d.runs
+	}
+	jobs := func(runs []triggerContext) []string {
+		var names []string
+		for _, r := range runs {
+			names = append(names, r.job)
+		}
+		return names
+	}
+
+	release := func(action string, prerelease bool) string {
+		p := "false"
+		if prerelease {
+			p = "true"
+		}
+		return `{"action":"` + action + `","release":{"tag_name":"v1.2.0","prerelease":` + p +
+			`},"repository":{"full_name":"test/repo"},"sender":{"login":"alice"}}`
+	}
+	if got := jobs(route(eventRelease, release("published", false))); !slices.Equal(got, []string{"release", "any-release"}) {
+		t.Errorf("published release ran %v", got)
+	}
+	if got := jobs(route(eventRelease, release("published", true))); !slices.Equal(got, []string{"any-release"}) {
+		t.Errorf("published prerelease ran %v", got)
+	}
+	if got := jobs(route(eventRelease, release("created", false))); len(got) != 0 {
+		t.Errorf("created release ran %v", got)
+	}
+
+	runs := route(eventRelease, release("published", false))
+	env := handler.contextEnv(runs[0], 1)
+	if !slices.Contains(env, "GH_RELEASE_TAG=v1.2.0") || !slices.Contains(env, "GH_JOB=release") {
+		t.Errorf("release env missing tag or job: %v", env)
+	}
+
+	workflowRunFrom := func(headRepo, event, name, branch, conclusion string) string {
+		return `{"action":"completed","workflow_run":{"id":42,"name":"` + name +
+			`","head_branch":"` + branch + `","head_sha":"abc1230000000000000000000000000000000000","conclusion":"` + conclusion +
+			`","event":"` + event + `","head_repository":{"full_name":"` + headRepo + `"}}` +
+			`,"repository":{"full_name":"test/repo"}}`
+	}
+	workflowRun := func(name, branch, conclusion string) string {
+		return workflowRunFrom("test/repo", "push", name, branch, conclusion)
+	}
+	runs = route(eventWorkflowRun, workflowRun("CI", "master", "success"))
+	if got := jobs(runs); !slices.Equal(got, []string{"green-ci"}) {
+		t.Fatalf("green CI on master ran %v", got)
+	}
+	env = handler.contextEnv(runs[0], 1)
+	for _, want := range []string{"GH_WORKFLOW_RUN_ID=42", "GH_COMMIT=abc1230000000000000000000000000000000000", "GH_BRANCH=master"} {
+		if !slices.Contains(env, want) {
+			t.Errorf("workflow_run env missing %s: %v", want, env)
+		}
+	}
+	for _, body := range []string{
+		workflowRun("CI", "master", "failure"),
+		workflowRun("CI", "feature", "success"),
+		workflowRun("Lint", "master", "success"),
+		// A fork's pull request from its own master branch.
+		workflowRunFrom("evil/fork", "pull_request", "CI", "master", "success"),
+		workflowRunFrom("evil/fork", "push", "CI", "master", "success"),
+		workflowRunFrom("test/repo", "pull_request", "CI", "master", "success"),
+		// A head_sha that git would parse as an option.
+		strings.Replace(workflowRun("CI", "master", "success"),
+			"abc1230000000000000000000000000000000000", "--output=/tmp/pwned", 1),
+	} {
+		if got := route(eventWorkflowRun, body); len(got) != 0 {
+			t.Errorf("%s ran %v", body, jobs(got))
+		}
+	}
+
+	push := `{"ref":"refs/heads/master","after":"abc1230000000000000000000000000000000000","repository":{"full_name":"test/repo"}}`
+	if got := route("push", push); len(got) != 0 {
+		t.Errorf("push ran %v with disable_push", jobs(got))
+	}
+}
@@ -0,0 +1,192 @@
+package webhook
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Debouncer coalesces rapid triggers and runs them serially once no new
+// trigger arrived for the quiet period.
+//
+// Pending items are coalesced by key: a burst of pushes collapses into one
+// run with the newest item, while an item with another key queued in the
+// same window still gets its own run. A newer item for the key being run
+// closes that run's superseded channel, e.g. to cancel its pending retries.
+type Debouncer[T any] struct {
+	quiet time.Duration
+	key   func(T) string
+	run   func(item T, superseded <-chan struct{}) error
+
+	mu sync.Mutex
+	// pending holds at most one item per key, in arrival order.
+	pending []T
+	// wake is signalled on every trigger to restart the quiet timer.
+	wake chan struct{}
+	// ping is answered by the loop when idle, as a health check.
+	ping chan chan struct{}
+	// running is set while run executes.
+	running bool
+	// taken counts items taken from pending whose run has not returned.
+	taken int
+	// runningKey is the key of the item being run.
+	runningKey string
+	// superseded is closed when a newer item arrives for runningKey.
+	superseded chan struct{}
+	// last is the most recent item.
+	last T
+}
+
+// NewDebouncer returns a Debouncer that waits for quiet, coalesces pending
+// items by key and calls run for each. Run starts its loop.
+func NewDebouncer[T any](
+	quiet time.Duration,
+	key func(T) string,
+	run func(item T, superseded <-chan struct{}) error,
+) *Debouncer[T] {
+	return &Debouncer[T]{
+		quiet: quiet,
+		key:   key,
+		run:   run,
+		wake:  make(chan struct{}, 1),
+		ping:  make(chan chan struct{}),
+	}
+}
+
+// Enqueue adds item to the pending queue, replacing any pending item with
+// the same key, and restarts the quiet period.
+func (d *Debouncer[T]) Enqueue(item T) {
+	key := d.key(item)
+
+	d.mu.Lock()
+	replaced := false
+	for i := range d.pending {
+		if d.key(d.pending[i]) == key {
+			d.pending[i] = item
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		d.pending = append(d.pending, item)
+	}
+	d.last = item
+	if d.superseded != nil && d.runningKey == key {
+		close(d.superseded)
+		d.superseded = nil
+	}
+	d.mu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the most recent item (zero if none) and the number of
+// pending items.
+func (d *Debouncer[T]) Status() (last T, pending int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.last, len(d.pending)
+}
+
+// Idle reports whether no item is pending or being run.
+func (d *Debouncer[T]) Idle() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.pending) == 0 && d.taken == 0
+}
+
+// Healthy reports whether the loop is responsive: it answers a ping within
+// timeout, or is busy running an item, which the caller's run func bounds.
+func (d *Debouncer[T]) Healthy(timeout time.Duration) bool {
+	busy := func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.running
+	}
+	if busy() {
+		return true
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	reply := make(chan struct{})
+	select {
+	case d.ping <- reply:
+	case <-timer.C:
+		return busy()
+	}
+	select {
+	case <-reply:
+		return true
+	case <-timer.C:
+		return busy()
+	}
+}
+
+// Run listens for triggers, waits for the quiet period, then runs the
+// pending items serially, until ctx is done. Run errors do not stop the
+// loop; the run func is expected to report them.
+func (d *Debouncer[T]) Run(ctx context.Context) {
+	var timer *time.Timer
+	var timerC <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case reply := <-d.ping:
+			close(reply)
+		case <-d.wake:
+			// Restart quiet timer on every trigger to coalesce bursts.
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(d.quiet)
+			timerC = timer.C
+		case <-timerC:
+			timerC = nil
+			timer = nil
+
+			for _, item := range d.takePending() {
+				_ = d.run(item, d.beginRun(item))
+				d.endRun()
+			}
+		}
+	}
+}
+
+// takePending returns and clears the pending queue.
+func (d *Debouncer[T]) takePending() []T {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	pending := d.pending
+	d.pending = nil
+	d.taken = len(pending)
+	return pending
+}
+
+// beginRun marks item's key as running and returns its superseded channel.
+func (d *Debouncer[T]) beginRun(item T) <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running = true
+	d.runningKey = d.key(item)
+	d.superseded = make(chan struct{})
+	return d.superseded
+}
+
+// endRun clears the running key.
+func (d *Debouncer[T]) endRun() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running = false
+	d.taken--
+	d.runningKey = ""
+	d.superseded = nil
+}
@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDebouncer coalesces a burst per key, keeps other keys' runs, and
+// supersedes the running key.
+func TestDebouncer(t *testing.T) {
+	type item struct{ key, val string }
+
+	var mu sync.Mutex
+	var ran []string
+	started := make(chan (<-chan struct{}), 1)
+	release := make(chan struct{})
+	d := NewDebouncer(10*time.Millisecond,
+		func(it item) string { return it.key },
+		func(it item, superseded <-chan struct{}) error {
+			mu.Lock()
+			ran = append(ran, it.key+"="+it.val)
+			mu.Unlock()
+			if it.val == "block" {
+				started <- superseded
+				<-release
+			}
+			return nil
+		})
+	go d.Run(t.Context())
+
+	d.Enqueue(item{"push", "a"})
+	d.Enqueue(item{"gc", "1"})
+	d.Enqueue(item{"push", "b"})
+	if last, pending := d.Status(); last.val != "b" || pending != 2 {
+		t.Fatalf("Status = %v, %d; want b, 2", last, pending)
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ran) == 2
+	})
+	mu.Lock()
+	if want := []string{"push=b", "gc=1"}; !slices.Equal(ran, want) {
+		t.Fatalf("ran %v, want %v", ran, want)
+	}
+	mu.Unlock()
+
+	d.Enqueue(item{"push", "block"})
+	superseded := <-started
+	if !d.Healthy(time.Millisecond) {
+		t.Errorf("a loop busy running should be healthy")
+	}
+	if d.Idle() {
+		t.Errorf("a loop busy running should not be idle")
+	}
+	d.Enqueue(item{"gc", "2"})
+	select {
+	case <-superseded:
+		t.Fatalf("another key superseded the running one")
+	default:
+	}
+	d.Enqueue(item{"push", "c"})
+	select {
+	case <-superseded:
+	case <-time.After(time.Second):
+		t.Fatalf("a newer item for the running key did not supersede it")
+	}
+	close(release)
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ran) == 5
+	})
+	if !d.Healthy(time.Second) {
+		t.Errorf("idle loop should be healthy")
+	}
+	waitFor(t, d.Idle)
+}
+
+// waitFor polls cond for up to a few seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,203 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// DefaultMaxBodyBytes is the default delivery size limit, GitHub's own 25 MB
+// payload cap.
+const DefaultMaxBodyBytes = 25 << 20
+
+// AnyEvent registers a handler for every event type.
+const AnyEvent = "*"
+
+// Delivery is one verified webhook delivery.
+type Delivery struct {
+	// ID is the X-GitHub-Delivery header.
+	ID string
+	// Event is the X-GitHub-Event header, e.g. "push".
+	Event string
+	// Repository is the payload's repository.full_name.
+	Repository string
+	// Header holds the request headers.
+	Header http.Header
+	// Body is the raw, signed request body.
+	Body []byte
+	// Payload is the JSON payload (decoded from form bodies).
+	Payload []byte
+	// Parsed is the typed payload from ParseEvent, nil for other events.
+	Parsed any
+}
+
+// Handler reacts to verified deliveries.
+type Handler interface {
+	HandleDelivery(ctx context.Context, d *Delivery) error
+}
+
+// HandlerFunc adapts a function to Handler.
+type HandlerFunc func(ctx context.Context, d *Delivery) error
+
+// HandleDelivery calls f.
+func (f HandlerFunc) HandleDelivery(ctx context.Context, d *Delivery) error {
+	return f(ctx, d)
+}
+
+// StatusError is an error with the HTTP status to answer a delivery with.
+type StatusError struct {
+	Status int
+	Msg    string
+}
+
+func (e *StatusError) Error() string { return fmt.Sprintf("%d %s", e.Status, e.Msg) }
+
+// Dispatcher verifies deliveries and routes them to the handlers registered
+// for their event type. It is an http.Handler for the webhook endpoint.
+type Dispatcher struct {
+	// Verifier returns the verifier of a repository's hook; nil rejects
+	// deliveries for it with 404. repository is empty for GitHub App events
+	// without one, such as installation, which the app hook's verifier
+	// checks.
+	Verifier func(repository string) Verifier
+	// MaxBodyBytes limits delivery size; 0 uses DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+
+	mu       sync.RWMutex
+	handlers map[string][]Handler // key: event type or AnyEvent
+}
+
+// NewDispatcher returns a Dispatcher using verifier to look up each
+// repository's hook verifier.
+func NewDispatcher(verifier func(repository string) Verifier) *Dispatcher {
+	return &Dispatcher{Verifier: verifier}
+}
+
+// Handle registers h for event, or for every event with AnyEvent. Handlers
+// run in registration order.
+func (d *Dispatcher) Handle(event string, h Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.handlers == nil {
+		d.handlers = make(map[string][]Handler)
+	}
+	d.handlers[event] = append(d.handlers[event], h)
+}
+
+// HandleFunc registers fn for event.
+func (d *Dispatcher) HandleFunc(event string, fn func(ctx context.Context, d *Delivery) error) {
+	d.Handle(event, HandlerFunc(fn))
+}
+
+// ServeHTTP reads, verifies and dispatches a delivery. It answers 202 if a
+// handler ran, 204 if none is registered for the event, 401 for a bad
+// signature, 413 for oversized bodies, and a handler's StatusError status
+// (500 for other errors).
+func (d *Dispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ReadBody(w, r, d.MaxBodyBytes)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	handled, err := d.Dispatch(r.Context(), r.Header, body)
+	switch {
+	case err != nil:
+		WriteError(w, err)
+	case handled:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Dispatch verifies a delivery given its headers and raw body, then runs the
+// matching handlers. It reports whether any handler ran; the first handler
+// error stops dispatch.
+func (d *Dispatcher) Dispatch(ctx context.Context, header http.Header, body []byte) (bool, error) {
+	event := header.Get("X-GitHub-Event")
+	if event == "" {
+		return false, &StatusError{http.StatusBadRequest, "missing X-GitHub-Event"}
+	}
+
+	payload, err := DecodePayload(header.Get("Content-Type"), body)
+	if err != nil {
+		return false, &StatusError{http.StatusBadRequest, err.Error()}
+	}
+	repo := RepositoryName(payload)
+
+	var verifier Verifier
+	if d.Verifier != nil {
+		verifier = d.Verifier(repo)
+	}
+	if verifier == nil {
+		return false, &StatusError{http.StatusNotFound, "unknown repository"}
+	}
+	if err := verifier.Verify(body, header.Get("X-Hub-Signature-256")); err != nil {
+		return false, &StatusError{http.StatusUnauthorized, "invalid signature"}
+	}
+
+	parsed, err := ParseEvent(event, payload)
+	if err != nil {
+		return false, &StatusError{http.StatusBadRequest, "invalid json"}
+	}
+	delivery := &Delivery{
+		ID:         header.Get("X-GitHub-Delivery"),
+		Event:      event,
+		Repository: repo,
+		Header:     header,
+		Body:       body,
+		Payload:    payload,
+		Parsed:     parsed,
+	}
+
+	d.mu.RLock()
+	handlers := slices.Concat(d.handlers[event], d.handlers[AnyEvent])
+	d.mu.RUnlock()
+
+	for _, h := range handlers {
+		if err := h.HandleDelivery(ctx, delivery); err != nil {
+			return true, err
+		}
+	}
+	return len(handlers) > 0, nil
+}
+
+// ReadBody reads a delivery body of at most limit bytes (0 uses
+// DefaultMaxBodyBytes). Errors are StatusErrors: 413 for oversized bodies,
+// 400 otherwise.
+func ReadBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		limit = DefaultMaxBodyBytes
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, &StatusError{http.StatusRequestEntityTooLarge, "payload too large"}
+		}
+		return nil, &StatusError{http.StatusBadRequest, "read body failed"}
+	}
+	return body, nil
+}
+
+// WriteError answers with err's StatusError status and message, or 500
+// otherwise, and returns the status written.
+func WriteError(w http.ResponseWriter, err error) int {
+	var se *StatusError
+	if errors.As(err, &se) {
+		http.Error(w, se.Msg, se.Status)
+		return se.Status
+	}
+	http.Error(w, "internal error", http.StatusInternalServerError)
+	return http.StatusInternalServerError
+}
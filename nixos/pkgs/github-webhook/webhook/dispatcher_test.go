@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestDispatcher verifies deliveries per repository and routes typed events
+// to the registered handlers.
+func TestDispatcher(t *testing.T) {
+	secret := []byte("supersecret")
+	d := NewDispatcher(func(repo string) Verifier {
+		if repo != "test/repo" {
+			return nil
+		}
+		return HMAC(secret)
+	})
+
+	var prs []*PullRequestEvent
+	var all []string
+	d.HandleFunc("pull_request", func(_ context.Context, dl *Delivery) error {
+		prs = append(prs, dl.Parsed.(*PullRequestEvent))
+		return nil
+	})
+	d.HandleFunc(AnyEvent, func(_ context.Context, dl *Delivery) error {
+		all = append(all, dl.Event+"/"+dl.ID)
+		if dl.Event == "push" {
+			if _, ok := dl.Parsed.(*PushEvent).Branch(); !ok {
+				return &StatusError{http.StatusBadRequest, "not a branch"}
+			}
+		}
+		return nil
+	})
+
+	post := func(event, contentType string, body []byte, sig string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-GitHub-Delivery", "d1")
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-Hub-Signature-256", sig)
+		rr := httptest.NewRecorder()
+		d.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	pr := []byte(`{"action":"opened","number":7,"pull_request":{"number":7,` +
+		`"head":{"ref":"feature","sha":"abc123"}},"repository":{"full_name":"test/repo"}}`)
+	if code := post("pull_request", ContentTypeJSON, pr, Sign(secret, pr)); code != http.StatusAccepted {
+		t.Fatalf("pull_request: got %d, want 202", code)
+	}
+	if len(prs) != 1 || prs[0].Action != "opened" || prs[0].PullRequest.Head.SHA != "abc123" {
+		t.Fatalf("parsed pull requests = %+v", prs)
+	}
+
+	form := []byte("payload=" + url.QueryEscape(`{"ref":"refs/tags/v1","repository":{"full_name":"test/repo"}}`))
+	if code := post("push", ContentTypeForm, form, Sign(secret, form)); code != http.StatusBadRequest {
+		t.Errorf("tag push: got %d, want the handler's 400", code)
+	}
+
+	other := []byte(`{"repository":{"full_name":"other/repo"}}`)
+	for _, tc := range []struct {
+		name string
+		body []byte
+		sig  string
+		want int
+	}{
+		{"bad signature", pr, Sign([]byte("wrong"), pr), http.StatusUnauthorized},
+		{"unknown repo", other, Sign(secret, other), http.StatusNotFound},
+	} {
+		if code := post("pull_request", ContentTypeJSON, tc.body, tc.sig); code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, code, tc.want)
+		}
+	}
+	if len(prs) != 1 {
+		t.Errorf("rejected deliveries reached the handler: %d", len(prs))
+	}
+
+	if want := []string{"pull_request/d1", "push/d1"}; len(all) != 2 || all[0] != want[0] || all[1] != want[1] {
+		t.Errorf("AnyEvent handler saw %v, want %v", all, want)
+	}
+
+	d.MaxBodyBytes = 16
+	if code := post("pull_request", ContentTypeJSON, pr, Sign(secret, pr)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized delivery: got %d, want 413", code)
+	}
+
+	// No handler for the event.
+	d = NewDispatcher(func(string) Verifier { return HMAC(secret) })
+	if code := post("ping", ContentTypeJSON, pr, Sign(secret, pr)); code != http.StatusNoContent {
+		t.Errorf("unhandled event: got %d, want 204", code)
+	}
+}
@@ -0,0 +1,180 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+const (
+	// ContentTypeJSON is the "application/json" hook content type.
+	ContentTypeJSON = "application/json"
+	// ContentTypeForm is the "application/x-www-form-urlencoded" hook content
+	// type, which carries the JSON in the "payload" form field.
+	ContentTypeForm = "application/x-www-form-urlencoded"
+)
+
+// Payload decoding errors.
+var (
+	ErrInvalidForm    = errors.New("invalid form body")
+	ErrMissingPayload = errors.New("form body has no payload field")
+)
+
+// Repository is the repository a delivery is about (minimal fields).
+type Repository struct {
+	FullName      string `json:"full_name"`
+	CloneURL      string `json:"clone_url"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+// User is a GitHub account (minimal fields).
+type User struct {
+	Login string `json:"login"`
+}
+
+// PushEvent models GitHub push webhook payload (minimal fields).
+type PushEvent struct {
+	Ref        string     `json:"ref"`
+	Before     string     `json:"before"`
+	After      string     `json:"after"`
+	Deleted    bool       `json:"deleted"`
+	Repository Repository `json:"repository"`
+	Sender     User       `json:"sender"`
+}
+
+// Branch returns the pushed branch name, or false for tags and other refs.
+func (e *PushEvent) Branch() (string, bool) {
+	return strings.CutPrefix(e.Ref, "refs/heads/")
+}
+
+// PingEvent models GitHub ping webhook payload (minimal fields), sent when a
+// hook is created.
+type PingEvent struct {
+	Zen        string     `json:"zen"`
+	HookID     int64      `json:"hook_id"`
+	Repository Repository `json:"repository"`
+}
+
+// PullRequestEvent models GitHub pull_request webhook payload (minimal
+// fields).
+type PullRequestEvent struct {
+	// Action is e.g. "opened", "synchronize", "closed".
+	Action      string      `json:"action"`
+	Number      int         `json:"number"`
+	PullRequest PullRequest `json:"pull_request"`
+	Repository  Repository  `json:"repository"`
+	Sender      User        `json:"sender"`
+}
+
+// PullRequest is the pull request of a PullRequestEvent.
+type PullRequest struct {
+	Number  int            `json:"number"`
+	State   string         `json:"state"`
+	Title   string         `json:"title"`
+	Draft   bool           `json:"draft"`
+	Merged  bool           `json:"merged"`
+	HTMLURL string         `json:"html_url"`
+	User    User           `json:"user"`
+	Head    PullRequestRef `json:"head"`
+	Base    PullRequestRef `json:"base"`
+}
+
+// PullRequestRef is the head or base of a pull request.
+type PullRequestRef struct {
+	Ref  string     `json:"ref"`
+	SHA  string     `json:"sha"`
+	Repo Repository `json:"repo"`
+}
+
+// Installation is a GitHub App installation (minimal fields).
+type Installation struct {
+	ID      int64 `json:"id"`
+	Account User  `json:"account"`
+}
+
+// InstallationRepository is a repository listed in installation events.
+type InstallationRepository struct {
+	FullName string `json:"full_name"`
+	Private  bool   `json:"private"`
+}
+
+// InstallationEvent models GitHub installation webhook payload (minimal
+// fields), sent to a GitHub App's hook when it is installed or uninstalled.
+type InstallationEvent struct {
+	// Action is e.g. "created", "deleted", "suspend".
+	Action       string                   `json:"action"`
+	Installation Installation             `json:"installation"`
+	Repositories []InstallationRepository `json:"repositories"`
+	Sender       User                     `json:"sender"`
+}
+
+// InstallationRepositoriesEvent models GitHub installation_repositories
+// webhook payload (minimal fields), sent when repositories are added to or
+// removed from an installation.
+type InstallationRepositoriesEvent struct {
+	// Action is "added" or "removed".
+	Action              string                   `json:"action"`
+	Installation        Installation             `json:"installation"`
+	RepositorySelection string                   `json:"repository_selection"`
+	RepositoriesAdded   []InstallationRepository `json:"repositories_added"`
+	RepositoriesRemoved []InstallationRepository `json:"repositories_removed"`
+	Sender              User                     `json:"sender"`
+}
+
+// DecodePayload returns the JSON payload of a delivery body. Hooks with the
+// form content type carry it in the "payload" form field; anything else is
+// taken as JSON. The signature always covers the raw body, not the decoded
+// payload.
+func DecodePayload(contentType string, body []byte) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != ContentTypeForm {
+		return body, nil
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, ErrInvalidForm
+	}
+	if !form.Has("payload") {
+		return nil, ErrMissingPayload
+	}
+	return []byte(form.Get("payload")), nil
+}
+
+// RepositoryName extracts repository.full_name from a JSON payload, or "".
+func RepositoryName(payload []byte) string {
+	var p struct {
+		Repository Repository `json:"repository"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return ""
+	}
+	return p.Repository.FullName
+}
+
+// ParseEvent decodes a JSON payload into the typed event for event:
+// *PushEvent, *PingEvent, *PullRequestEvent, *InstallationEvent or
+// *InstallationRepositoriesEvent. Other events return nil and no error.
+func ParseEvent(event string, payload []byte) (any, error) {
+	var v any
+	switch event {
+	case "push":
+		v = &PushEvent{}
+	case "ping":
+		v = &PingEvent{}
+	case "pull_request":
+		v = &PullRequestEvent{}
+	case "installation":
+		v = &InstallationEvent{}
+	case "installation_repositories":
+		v = &InstallationRepositoriesEvent{}
+	default:
+		return nil, nil
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
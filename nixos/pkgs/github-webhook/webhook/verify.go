@@ -0,0 +1,62 @@
+// Package webhook receives GitHub webhook deliveries: it verifies their
+// signatures, decodes typed event payloads and dispatches them to registered
+// handlers. Debouncer coalesces bursts of deliveries into serial runs.
+//
+// The github-webhook daemon is built on it; other tools can embed a
+// Dispatcher to react to deliveries themselves:
+//
+//	d := webhook.NewDispatcher(func(repo string) webhook.Verifier {
+//		return webhook.HMAC(secrets[repo])
+//	})
+//	d.HandleFunc("pull_request", func(ctx context.Context, dl *webhook.Delivery) error {
+//		pr := dl.Parsed.(*webhook.PullRequestEvent)
+//		...
+//	})
+//	http.Handle("/webhooks/github", d)
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidSignature is returned for a missing or wrong signature.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Verifier authenticates a delivery body against its X-Hub-Signature-256
+// header value.
+type Verifier interface {
+	Verify(body []byte, signature string) error
+}
+
+// HMAC verifies GitHub's HMAC-SHA256 signatures with a hook secret.
+type HMAC []byte
+
+// Verify checks a "sha256=<hex>" signature of body in constant time. An
+// empty secret verifies nothing, so a missing secret can't be signed for.
+func (secret HMAC) Verify(body []byte, signature string) error {
+	hexSig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok || len(secret) == 0 {
+		return ErrInvalidSignature
+	}
+	sig, err := hex.DecodeString(hexSig)
+	if err != nil || !hmac.Equal(computeSignature(secret, body), sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Sign returns the X-Hub-Signature-256 header value of body for secret.
+func Sign(secret, body []byte) string {
+	return "sha256=" + hex.EncodeToString(computeSignature(secret, body))
+}
+
+// computeSignature returns the raw HMAC-SHA256 of body.
+func computeSignature(secret, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(body)
+	return mac.Sum(nil)
+}
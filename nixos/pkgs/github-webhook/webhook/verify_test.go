@@ -0,0 +1,34 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// TestVerifySignature checks HMAC validation against an independently
+// computed signature.
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("supersecret")
+	body := []byte(`{"ref":"refs/heads/master"}`)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if err := HMAC(secret).Verify(body, expected); err != nil {
+		t.Fatalf("expected signature to validate: %v", err)
+	}
+	if got := Sign(secret, body); got != expected {
+		t.Fatalf("Sign = %s, want %s", got, expected)
+	}
+	for _, sig := range []string{"", "sha256=zz", expected[:len(expected)-2] + "00", "sha1=" + expected[7:]} {
+		if err := HMAC(secret).Verify(body, sig); err != ErrInvalidSignature {
+			t.Errorf("Verify(%q) = %v, want ErrInvalidSignature", sig, err)
+		}
+	}
+	if err := HMAC(nil).Verify(body, Sign(nil, body)); err != ErrInvalidSignature {
+		t.Errorf("empty secret verified a signature")
+	}
+}
@@ -170,6 +170,12 @@
   services.github-webhook = {
     enable = true;
     user = "phlip9";
+    # nginx proxies deliveries from localhost.
+    trustedProxies = [
+      "::1/128"
+      "127.0.0.1/32"
+    ];
+    maxAuthFailuresPerMinute = 10;
     repos =
       let
         cmdFetchReset = builtins.toString (
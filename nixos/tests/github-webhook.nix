@@ -4,10 +4,12 @@
 # - The github-webhook service configured to watch multiple repos
 # - Simulated GitHub webhook POST requests
 # - Verification that commands execute on push events
+# - A second VM running the service as root, with a `runAs` repo reading
+#   files-mode secrets
 {
   name = "github-webhook";
 
-  globalTimeout = 120;
+  globalTimeout = 240;
 
   nodes.machine =
     {
@@ -65,6 +67,48 @@
       ];
     };
 
+  # The service runs as root (the default) and drops to testuser for runs.
+  nodes.runas =
+    {
+      config,
+      ...
+    }:
+    {
+      users.users.testuser = {
+        isNormalUser = true;
+        uid = 1000;
+      };
+
+      sops.secrets.test-webhook-secret = { };
+
+      services.github-webhook = {
+        enable = true;
+        port = 8673;
+
+        repos."test/repo3" = {
+          secretName = "test-webhook-secret";
+          branches = [ "main" ];
+          command = [
+            "${config.nixpkgs.pkgs.bash}/bin/bash"
+            "-c"
+            "echo '{\"summary\": \"runas-ok\"}' > \"$GH_RESULT_PATH\" && echo \"$(id -u) $(cat \"$TOKEN_FILE\")\" > /tmp/repo3-work/result"
+          ];
+          workingDir = "/tmp/repo3-work";
+          quietMs = 100;
+          runAs = {
+            uid = 1000;
+            gid = 100;
+          };
+          secrets.TOKEN = "test-webhook-secret";
+          secretsMode = "files";
+        };
+      };
+
+      systemd.tmpfiles.rules = [
+        "d /tmp/repo3-work 0755 testuser users -"
+      ];
+    };
+
   testScript = ''
     import json
     import hashlib
@@ -98,7 +142,7 @@
     secret = "e166c93083dfde95614643dc805a2670f663b20544831a2a"
     payload = json.dumps({
         "ref": "refs/heads/main",
-        "after": "abc123def456",
+        "after": "abc123def4560000000000000000000000000000",
         "repository": {"full_name": "test/repo1"},
         "sender": {"login": "testuser"}
     })
@@ -127,7 +171,7 @@
     print("Test 4: Send push webhook for repo2 with env context...")
     payload2 = json.dumps({
         "ref": "refs/heads/master",
-        "after": "def456abc789",
+        "after": "def456abc7890000000000000000000000000000",
         "repository": {"full_name": "test/repo2"},
         "sender": {"login": "alice"}
     })
@@ -219,6 +263,48 @@
     assert response == "204", f"Expected 204 for ping, got '{response}'"
     print("✓ Test 7 passed: Ping event accepted")
 
+    # Test 8: Status dashboard lists repos and recent runs.
+    print("Test 8: Status dashboard...")
+    page = machine.succeed("curl -f -s http://127.0.0.1:8674/")
+    assert "test/repo1" in page and "test/repo2" in page, "dashboard missing repos"
+    assert "def456abc789" in page, "dashboard missing repo2 run commit"
+    machine.fail("curl -f -s http://localhost:8673/")
+    print("✓ Test 8 passed: Dashboard lists repos and runs, off the webhook port")
+
+    # Test 9: runAs commands read files-mode secrets from the private per-run
+    # dir in the root-owned RuntimeDirectory, and write GH_RESULT_PATH there.
+    print("Test 9: runAs with files-mode secrets...")
+    runas.start()
+    runas.wait_for_unit("github-webhook.service")
+    runas.wait_for_open_port(8673)
+    payload5 = json.dumps({
+        "ref": "refs/heads/main",
+        "after": "fed9870000000000000000000000000000000000",
+        "repository": {"full_name": "test/repo3"},
+        "sender": {"login": "alice"}
+    })
+    sig5 = make_signature(secret, payload5)
+
+    response = runas.succeed(f"""
+        curl -X POST http://localhost:8673/webhooks/github \
+          -H 'Content-Type: application/json' \
+          -H 'X-GitHub-Event: push' \
+          -H 'X-Hub-Signature-256: {sig5}' \
+          -d '{payload5}' \
+          -w '%{{http_code}}' -o /dev/null -s
+    """).strip()
+    assert response == "202", f"Expected 202, got {response}"
+
+    runas.wait_for_file("/tmp/repo3-work/result", timeout=10)
+    result = runas.succeed("cat /tmp/repo3-work/result").strip()
+    assert result == f"1000 {secret}", f"Expected runAs uid and secret, got '{result}'"
+    # The runAs user can write GH_RESULT_PATH in the runtime dir.
+    runas.wait_until_succeeds(
+        "journalctl -u github-webhook.service | grep 'run finished.*summary=runas-ok'",
+        timeout=10,
+    )
+    print("✓ Test 9 passed: runAs command read its files-mode secret and wrote its result")
+
     print("✅ All tests passed!")
   '';
 }